@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/thecontrolapp/controlme-go/internal/api/routes"
 	"github.com/thecontrolapp/controlme-go/internal/config"
 	"github.com/thecontrolapp/controlme-go/internal/database"
+	"github.com/thecontrolapp/controlme-go/internal/mailer"
 	"github.com/thecontrolapp/controlme-go/internal/websocket"
 
 	// Import generated swagger docs
@@ -32,6 +34,9 @@ import (
 // @host localhost:8080
 // @BasePath /api/v1
 // @schemes http https
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 func main() {
 	// Initialize logger
 	logrus.SetFormatter(&logrus.JSONFormatter{})
@@ -50,9 +55,18 @@ func main() {
 	}
 
 	// Initialize WebSocket hub
-	hub := websocket.NewHub()
+	hub := websocket.NewHub(cfg.WebSocket)
 	go hub.Run()
 
+	// Initialize mailer
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		logrus.Fatal("Failed to initialize mailer: ", err)
+	}
+	if cfg.Environment == "production" && cfg.Mail.Driver != mailer.DriverSMTP {
+		logrus.Warnf("Mail driver %q does not deliver email", cfg.Mail.Driver)
+	}
+
 	// Set Gin mode based on environment
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -66,7 +80,21 @@ func main() {
 	router.Use(gin.Recovery())
 
 	// Setup routes
-	routes.SetupRoutes(router, db, hub, cfg)
+	jobs, err := routes.SetupRoutes(router, db, hub, mail, cfg)
+	if err != nil {
+		logrus.Fatal("Failed to set up routes: ", err)
+	}
+
+	// Start background jobs, which stop when the server shuts down
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobsDone sync.WaitGroup
+	for _, job := range jobs {
+		jobsDone.Add(1)
+		go func() {
+			defer jobsDone.Done()
+			job(jobsCtx)
+		}()
+	}
 
 	// Setup server
 	server := &http.Server{
@@ -97,5 +125,9 @@ func main() {
 		logrus.Fatal("Server forced to shutdown: ", err)
 	}
 
+	// Let jobs finish the round they are in
+	stopJobs()
+	jobsDone.Wait()
+
 	logrus.Info("Server exited")
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+
+	"github.com/thecontrolapp/controlme-go/internal/blocklist"
+)
+
+// blocklist-hash prints blocklist entries for files, to be appended to the
+// file configured as scanning.blocklist_file:
+//
+//	go run ./cmd/tools/blocklist-hash <file>... >> blocklist.txt
+//
+// Images get phash and dhash entries besides the sha256 entry.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: blocklist-hash <file>...")
+		os.Exit(1)
+	}
+
+	for _, path := range os.Args[1:] {
+		if err := printEntries(path); err != nil {
+			log.Fatalf("Failed to hash %s: %v", path, err)
+		}
+	}
+}
+
+// printEntries prints the blocklist entries of one file, labelled with its path
+func printEntries(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	fmt.Printf("%s %s %s\n", blocklist.KindSHA256, hex.EncodeToString(hash.Sum(nil)), path)
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		// Not an image, so only the exact hash applies
+		return nil
+	}
+	fmt.Printf("%s %s %s\n", blocklist.KindPHash, blocklist.FormatHash(blocklist.PHash(img)), path)
+	fmt.Printf("%s %s %s\n", blocklist.KindDHash, blocklist.FormatHash(blocklist.DHash(img)), path)
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/thecontrolapp/controlme-go/internal/config"
 	"github.com/thecontrolapp/controlme-go/internal/database"
+	"github.com/thecontrolapp/controlme-go/internal/models"
 	"github.com/thecontrolapp/controlme-go/internal/services"
 )
 
@@ -25,7 +26,7 @@ func main() {
 	}
 
 	// Create services
-	cmdService := services.NewCommandService(db)
+	cmdService := services.NewCommandService(db, false)
 
 	// Find testdom and testsub users
 	var users []struct {
@@ -64,34 +65,34 @@ func main() {
 	// Create some test commands
 	fmt.Println("=== Creating Test Commands ===")
 
-	commands := []struct {
-		Type    string
-		Content string
-		Data    string
-	}{
-		{"message", "Hello there, pet!", ""},
-		{"task", "Go make me a sandwich", "kitchen"},
-		{"pose", "Kneel and wait for 5 minutes", "duration:5min"},
-		{"rule", "No speaking unless spoken to", "silence"},
+	commands := []services.CreateCommandRequest{
+		{
+			Instructions: []models.Instruction{{Type: "popup-msg", Content: map[string]string{"body": "Hello there, pet!", "button": "OK"}}},
+			Tags:         []string{"general"},
+		},
+		{
+			Instructions: []models.Instruction{{Type: "display-text", Content: map[string]string{"text": "Go make me a sandwich", "format": "plain"}}},
+			Tags:         []string{"general"},
+		},
+		{
+			Instructions: []models.Instruction{{Type: "timer", Content: map[string]interface{}{"duration": 300, "title": "Kneel and wait for 5 minutes"}}},
+			Tags:         []string{"general"},
+		},
+		{
+			Instructions: []models.Instruction{{Type: "notification", Content: map[string]string{"title": "New rule", "body": "No speaking unless spoken to"}}},
+			Tags:         []string{"general"},
+		},
 	}
 
-	for i, cmd := range commands {
-		// Create command
-		command, err := cmdService.CreateCommand(cmd.Type, cmd.Content, cmd.Data)
+	for i, req := range commands {
+		req.Receiver = subID.String()
+		command, err := cmdService.CreateCommand(domID, req)
 		if err != nil {
 			log.Printf("Failed to create command %d: %v", i+1, err)
 			continue
 		}
 
-		// Assign to sub
-		assignment, err := cmdService.AssignCommandToUser(domID, subID, command.ID, nil)
-		if err != nil {
-			log.Printf("Failed to assign command %d: %v", i+1, err)
-			continue
-		}
-
-		fmt.Printf("✓ Created command: %s -> %s (Assignment ID: %s)\n",
-			cmd.Content, assignment.ID, command.ID)
+		fmt.Printf("✓ Created %s command: %s\n", req.Instructions[0].Type, command.ID)
 	}
 
 	// Show pending commands count
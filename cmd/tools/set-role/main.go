@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/thecontrolapp/controlme-go/internal/config"
+	"github.com/thecontrolapp/controlme-go/internal/database"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+)
+
+// set-role changes a user's role from the command line, for example to
+// create the first admin:
+//
+//	go run ./cmd/tools/set-role <login_name> admin
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Println("Usage: set-role <login_name> <user|moderator|admin>")
+		os.Exit(1)
+	}
+	loginName, role := os.Args[1], os.Args[2]
+
+	if !models.IsValidRole(role) {
+		log.Fatalf("Unknown role %q", role)
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Connect to database
+	db, err := database.Initialize(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	result := db.Model(&models.User{}).Where("login_name = ?", loginName).Update("role", role)
+	if result.Error != nil {
+		log.Fatalf("Failed to update role: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		log.Fatalf("User %q not found", loginName)
+	}
+
+	fmt.Printf("✅ %s is now %s\n", loginName, role)
+}
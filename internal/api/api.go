@@ -4,12 +4,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/thecontrolapp/controlme-go/internal/api/routes"
 	"github.com/thecontrolapp/controlme-go/internal/config"
+	"github.com/thecontrolapp/controlme-go/internal/mailer"
 	"github.com/thecontrolapp/controlme-go/internal/websocket"
 	"gorm.io/gorm"
 )
 
-// SetupRouter configures and returns the main HTTP router
-func SetupRouter(db *gorm.DB, hub *websocket.Hub, cfg *config.Config) *gin.Engine {
+// SetupRouter configures and returns the main HTTP router and the
+// background jobs of its services
+func SetupRouter(db *gorm.DB, hub *websocket.Hub, mail mailer.Mailer, cfg *config.Config) (*gin.Engine, []routes.Job, error) {
 	// Set Gin mode based on environment
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -21,7 +23,10 @@ func SetupRouter(db *gorm.DB, hub *websocket.Hub, cfg *config.Config) *gin.Engin
 	router := gin.Default()
 
 	// Setup routes
-	routes.SetupRoutes(router, db, hub, cfg)
+	jobs, err := routes.SetupRoutes(router, db, hub, mail, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return router
+	return router, jobs, nil
 }
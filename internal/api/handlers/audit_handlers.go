@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+type AuditHandlers struct {
+	Service *services.AuditService
+}
+
+func NewAuditHandlers(service *services.AuditService) *AuditHandlers {
+	return &AuditHandlers{Service: service}
+}
+
+// ListAuditLogs godoc
+// @Summary      List audit log entries
+// @Description  Retrieves the security audit log, newest first. Requires audit:read.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        event query string false "Only entries of this event, such as account_locked"
+// @Param        user_id query string false "Only entries about this user"
+// @Param        page query int false "Page number, starting at 1"
+// @Param        page_size query int false "Entries per page (max 200)"
+// @Success      200  {object}  responses.AuditLogsResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /admin/audit-logs [get]
+func (h *AuditHandlers) ListAuditLogs(c *gin.Context) {
+	var userID uuid.UUID
+	if value := c.Query("user_id"); value != "" {
+		var err error
+		if userID, err = uuid.Parse(value); err != nil {
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid user ID"})
+			return
+		}
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultAuditPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxAuditPageSize {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid page_size"})
+		return
+	}
+
+	entries, total, err := h.Service.ListEntries(c.Query("event"), userID, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.AuditLogsResponse{
+		Entries:  entries,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
@@ -1,24 +1,75 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/models"
 	"github.com/thecontrolapp/controlme-go/internal/services"
+	wshub "github.com/thecontrolapp/controlme-go/internal/websocket"
 )
 
 type AuthHandlers struct {
-	UserService *services.UserService
+	UserService         *services.UserService
+	SessionService      *services.SessionService
+	VerificationService *services.VerificationService
+	PasswordService     *services.PasswordService
+	LoginGuard          *services.LoginGuard
+	TwoFactorService    *services.TwoFactorService
 }
 
-func NewAuthHandlers(userService *services.UserService) *AuthHandlers {
-	return &AuthHandlers{UserService: userService}
+func NewAuthHandlers(userService *services.UserService, sessionService *services.SessionService, verificationService *services.VerificationService, passwordService *services.PasswordService, loginGuard *services.LoginGuard, twoFactorService *services.TwoFactorService) *AuthHandlers {
+	return &AuthHandlers{
+		UserService:         userService,
+		SessionService:      sessionService,
+		VerificationService: verificationService,
+		PasswordService:     passwordService,
+		LoginGuard:          loginGuard,
+		TwoFactorService:    twoFactorService,
+	}
 }
 
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	DeviceName string `json:"device_name" binding:"max=100"`
+	ClientType string `json:"client_type"` // web (default) or desktop
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"` // Authenticator or recovery code
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
 }
 
 type RegisterRequest struct {
@@ -29,17 +80,20 @@ type RegisterRequest struct {
 	RandomOptIn bool   `json:"random_opt_in" binding:"required"`
 }
 
-// Login authenticates a user and returns a JWT token
+// Login authenticates a user and starts a session for the device
 // Login godoc
 // @Summary      User login
-// @Description  Authenticates a user and returns a JWT token
+// @Description  Authenticates a user and starts a session, returning a short-lived JWT access token and a refresh token. If the user has two-factor authentication enabled, no session is started; the response carries a challenge token to complete the login at /auth/login/2fa instead.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        credentials body LoginRequest true "User credentials"
 // @Success      200  {object}  responses.AuthResponse
+// @Success      202  {object}  responses.TwoFactorChallengeResponse
 // @Failure      400  {object}  responses.ErrorResponse
 // @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      429  {object}  responses.RateLimitResponse
 // @Failure      500  {object}  responses.ErrorResponse
 // @Router       /auth/login [post]
 func (h *AuthHandlers) Login(c *gin.Context) {
@@ -49,29 +103,308 @@ func (h *AuthHandlers) Login(c *gin.Context) {
 		return
 	}
 
+	if req.ClientType == "" {
+		req.ClientType = wshub.ClientTypeWeb
+	}
+	if req.ClientType != wshub.ClientTypeWeb && req.ClientType != wshub.ClientTypeDesktop {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid client type"})
+		return
+	}
+
+	ip := c.ClientIP()
+	if err := h.LoginGuard.Check(req.Username, ip); err != nil {
+		var rateLimitErr *services.RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			respondRateLimited(c, rateLimitErr, "Too many failed logins, try again later")
+			return
+		}
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to log in"})
+		return
+	}
+
 	user, err := h.UserService.AuthenticateUser(req.Username, req.Password)
-	if err != nil {
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		if err := h.LoginGuard.RecordFailure(req.Username, ip); err != nil {
+			logrus.WithError(err).Error("Failed to record failed login")
+		}
 		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Invalid credentials"})
 		return
 	}
+	if err != nil && !errors.Is(err, services.ErrAccountRestricted) {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to log in"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Account is suspended or banned"})
+		return
+	}
+
+	twoFactorEnabled, err := h.TwoFactorService.IsEnabled(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to log in"})
+		return
+	}
+	// Failed logins are only cleared once every factor has passed
+	if twoFactorEnabled {
+		challengeToken, expiresIn, err := h.TwoFactorService.StartChallenge(user.ID, req.DeviceName, req.ClientType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to log in"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, responses.TwoFactorChallengeResponse{
+			Message:           "Two-factor code required",
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+			ExpiresIn:         expiresIn,
+		})
+		return
+	}
+
+	if err := h.LoginGuard.RecordSuccess(req.Username); err != nil {
+		logrus.WithError(err).Error("Failed to clear failed logins")
+	}
+	h.startSession(c, user, req.DeviceName, req.ClientType)
+}
+
+// LoginTwoFactor completes a login that requires a two-factor code
+// LoginTwoFactor godoc
+// @Summary      Complete a two-factor login
+// @Description  Exchanges the challenge token returned by /auth/login and a code from the authenticator app, or an unused recovery code, for a session. The challenge expires after 5 minutes or 5 wrong codes. Wrong codes count as failed logins of the account and the IP address.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body LoginTwoFactorRequest true "Challenge token and code"
+// @Success      200  {object}  responses.AuthResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      429  {object}  responses.RateLimitResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/login/2fa [post]
+func (h *AuthHandlers) LoginTwoFactor(c *gin.Context) {
+	var req LoginTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	user, err := h.TwoFactorService.ChallengeUser(req.ChallengeToken)
+	if errors.Is(err, services.ErrInvalidLoginChallenge) {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Invalid or expired challenge token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to log in"})
+		return
+	}
+
+	ip := c.ClientIP()
+	if err := h.LoginGuard.Check(user.LoginName, ip); err != nil {
+		var rateLimitErr *services.RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			respondRateLimited(c, rateLimitErr, "Too many failed logins, try again later")
+			return
+		}
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to log in"})
+		return
+	}
+
+	challenge, err := h.TwoFactorService.CompleteChallenge(req.ChallengeToken, req.Code)
+	if errors.Is(err, services.ErrInvalidLoginChallenge) {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Invalid or expired challenge token"})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		if err := h.LoginGuard.RecordFailure(user.LoginName, ip); err != nil {
+			logrus.WithError(err).Error("Failed to record failed login")
+		}
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Invalid two-factor code"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to log in"})
+		return
+	}
+
+	if err := h.LoginGuard.RecordSuccess(user.LoginName); err != nil {
+		logrus.WithError(err).Error("Failed to clear failed logins")
+	}
+
+	if challenge.User.IsRestricted(time.Now()) {
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Account is suspended or banned"})
+		return
+	}
+
+	h.startSession(c, &challenge.User, challenge.DeviceName, challenge.ClientType)
+}
 
-	token, err := h.UserService.Auth.JWTManager.GenerateToken(user.ID)
+// startSession starts a session for a user who has logged in and writes the tokens
+func (h *AuthHandlers) startSession(c *gin.Context, user *models.User, deviceName, clientType string) {
+	tokens, err := h.SessionService.CreateSession(user.ID, services.SessionInfo{
+		DeviceName: deviceName,
+		ClientType: clientType,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, responses.AuthResponse{
-		Message: "Login successful",
-		User:    *user,
-		Token:   token,
+		Message:      "Login successful",
+		User:         *user,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		SessionID:    tokens.SessionID,
 	})
 }
 
+// Refresh exchanges a refresh token for a new access token
+// Refresh godoc
+// @Summary      Refresh access token
+// @Description  Exchanges a refresh token for a new access token. The refresh token is rotated: the response carries a new one and the old one stops working. Reusing an old refresh token revokes the whole session.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RefreshRequest true "Refresh token"
+// @Success      200  {object}  responses.TokenResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/refresh [post]
+func (h *AuthHandlers) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	tokens, err := h.SessionService.Refresh(req.RefreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Invalid or expired refresh token"})
+		return
+	}
+	if errors.Is(err, services.ErrAccountRestricted) {
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Account is suspended or banned"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.TokenResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+		SessionID:    tokens.SessionID,
+	})
+}
+
+// Logout revokes the caller's current session
+// Logout godoc
+// @Summary      Log out
+// @Description  Revokes the session of the access token, invalidating its refresh token and closing its WebSocket connections
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/logout [post]
+func (h *AuthHandlers) Logout(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	sessionID, hasSession := middleware.SessionID(c)
+	if !ok || !hasSession {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	err := h.SessionService.RevokeSession(userID, sessionID)
+	if err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Logged out"})
+}
+
+// GetSessions lists the caller's active sessions
+// GetSessions godoc
+// @Summary      List sessions
+// @Description  Returns the caller's active sessions, most recently used first
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.SessionsResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/sessions [get]
+func (h *AuthHandlers) GetSessions(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	sessions, err := h.SessionService.GetActiveSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to get sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.SessionsResponse{Sessions: sessions})
+}
+
+// RevokeSession revokes one of the caller's sessions
+// RevokeSession godoc
+// @Summary      Revoke a session
+// @Description  Revokes one of the caller's sessions and closes its WebSocket connections
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "Session ID"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/sessions/{id} [delete]
+func (h *AuthHandlers) RevokeSession(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid session ID"})
+		return
+	}
+
+	err = h.SessionService.RevokeSession(userID, sessionID)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Session revoked"})
+}
+
 // Register creates a new user account
 // Register godoc
 // @Summary      Register a new user
-// @Description  Creates a new user account
+// @Description  Creates a new user account and emails a code to verify the email address. The password must satisfy the password policy: a minimum and maximum length, not a common password, and not the username or email address.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -96,10 +429,251 @@ func (h *AuthHandlers) Register(c *gin.Context) {
 	}
 
 	user, err := h.UserService.CreateUser(userReq)
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: policyErr.Reason})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to create user"})
 		return
 	}
 
+	// The account is usable without the email, which can be resent later
+	if err := h.VerificationService.SendCode(user.ID); err != nil {
+		logrus.WithError(err).WithField("user_id", user.ID).Warn("Failed to send verification email")
+	}
+
 	c.JSON(http.StatusCreated, responses.UserResponse{User: *user})
 }
+
+// VerifyEmail confirms the caller's email address with the emailed code
+// VerifyEmail godoc
+// @Summary      Verify email address
+// @Description  Confirms the caller's email address with the code sent at registration. A code expires after 24 hours or 5 wrong guesses.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body VerifyEmailRequest true "Verification code"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      410  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/verify-email [post]
+func (h *AuthHandlers) VerifyEmail(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	err := h.VerificationService.Verify(userID, req.Code)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, responses.MessageResponse{Message: "Email address verified"})
+	case errors.Is(err, services.ErrInvalidVerificationCode):
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid verification code"})
+	case errors.Is(err, services.ErrVerificationExpired):
+		c.JSON(http.StatusGone, responses.ErrorResponse{Error: "Verification code expired, request a new one"})
+	case errors.Is(err, services.ErrAlreadyVerified):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Email address is already verified"})
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to verify email address"})
+	}
+}
+
+// ResendVerification emails the caller a new verification code
+// ResendVerification godoc
+// @Summary      Resend verification email
+// @Description  Emails the caller a new verification code, replacing the previous one. Allowed once per minute.
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      429  {object}  responses.RateLimitResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/verify-email/resend [post]
+func (h *AuthHandlers) ResendVerification(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	err := h.VerificationService.SendCode(userID)
+	var rateLimitErr *services.RateLimitError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, responses.MessageResponse{Message: "Verification email sent"})
+	case errors.As(err, &rateLimitErr):
+		respondRateLimited(c, rateLimitErr, "Please wait before requesting another email")
+	case errors.Is(err, services.ErrAlreadyVerified):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Email address is already verified"})
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to send verification email"})
+	}
+}
+
+// ForgotPassword emails a password reset token
+// ForgotPassword godoc
+// @Summary      Forgot password
+// @Description  Emails a single-use password reset token valid for one hour to the account with the email address. The response is the same whether or not such an account exists.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body ForgotPasswordRequest true "Email address"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/password/forgot [post]
+func (h *AuthHandlers) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	if err := h.PasswordService.RequestReset(req.Email); err != nil {
+		logrus.WithError(err).Error("Failed to request password reset")
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to request password reset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "If an account uses this email address, a reset link has been sent to it"})
+}
+
+// ResetPassword sets a new password with a reset token
+// ResetPassword godoc
+// @Summary      Reset password
+// @Description  Sets a new password with an emailed reset token and signs the account out everywhere
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body ResetPasswordRequest true "Reset token and new password"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/password/reset [post]
+func (h *AuthHandlers) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	err := h.PasswordService.ResetPassword(req.Token, req.NewPassword)
+	if errors.Is(err, services.ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid or expired reset token"})
+		return
+	}
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: policyErr.Reason})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Password reset, sign in with the new password"})
+}
+
+// ChangePassword replaces the caller's password
+// ChangePassword godoc
+// @Summary      Change password
+// @Description  Replaces the caller's password after checking the current one. Every other session is signed out; device keys stay valid. Wrong current passwords count as failed logins of the account.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body ChangePasswordRequest true "Current and new password"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      429  {object}  responses.RateLimitResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/password/change [post]
+func (h *AuthHandlers) ChangePassword(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	sessionID, hasSession := middleware.SessionID(c)
+	if !ok || !hasSession {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	if !allowReauthentication(c, h.LoginGuard, userID, "Failed to change password") {
+		return
+	}
+
+	err := h.PasswordService.ChangePassword(userID, sessionID, req.CurrentPassword, req.NewPassword)
+	if errors.Is(err, services.ErrInvalidPassword) {
+		recordReauthenticationFailure(c, h.LoginGuard, userID)
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Current password is incorrect"})
+		return
+	}
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: policyErr.Reason})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to change password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Password changed, other sessions were signed out"})
+}
+
+// allowReauthentication checks whether a signed-in user may give their password
+// or a two-factor code again. It writes the error response and returns false if not.
+func allowReauthentication(c *gin.Context, loginGuard *services.LoginGuard, userID uuid.UUID, message string) bool {
+	err := loginGuard.CheckUser(userID, c.ClientIP())
+	if err == nil {
+		return true
+	}
+	var rateLimitErr *services.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		respondRateLimited(c, rateLimitErr, "Too many failed attempts, try again later")
+	} else {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: message})
+	}
+	return false
+}
+
+// recordReauthenticationFailure counts a wrong password or two-factor code
+// from a signed-in user as a failed login of their account
+func recordReauthenticationFailure(c *gin.Context, loginGuard *services.LoginGuard, userID uuid.UUID) {
+	if err := loginGuard.RecordUserFailure(userID, c.ClientIP()); err != nil {
+		logrus.WithError(err).Error("Failed to record failed login")
+	}
+}
+
+// respondRateLimited writes a 429 response telling the client when to retry
+func respondRateLimited(c *gin.Context, err *services.RateLimitError, message string) {
+	retryAfter := int(math.Ceil(err.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, responses.RateLimitResponse{
+		Error:      responses.ErrorCodeRateLimitExceeded,
+		Message:    message,
+		RetryAfter: retryAfter,
+	})
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+)
+
+type BlockHandlers struct {
+	Service *services.BlockService
+}
+
+func NewBlockHandlers(service *services.BlockService) *BlockHandlers {
+	return &BlockHandlers{Service: service}
+}
+
+// BlockUserRequest is the body of a block request
+type BlockUserRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+	Reason string    `json:"reason"`
+}
+
+// GetBlocks godoc
+// @Summary      List my blocks
+// @Description  Retrieves the users the authenticated user has blocked
+// @Tags         blocks
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.BlocksResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /blocks [get]
+func (h *BlockHandlers) GetBlocks(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	blocks, err := h.Service.GetBlocks(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch blocks"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.BlocksResponse{Blocks: blocks})
+}
+
+// BlockUser godoc
+// @Summary      Block a user
+// @Description  Stops a user from sending commands to or seeing the authenticated user, and cancels their unfinished commands to them
+// @Tags         blocks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        block body BlockUserRequest true "User to block"
+// @Success      200  {object}  responses.BlockResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /blocks [post]
+func (h *BlockHandlers) BlockUser(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req BlockUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	block, err := h.Service.BlockUser(userID, req.UserID, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrCannotBlockSelf):
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "You cannot block yourself"})
+		case errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to block user"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, responses.BlockResponse{Block: *block})
+}
+
+// UnblockUser godoc
+// @Summary      Unblock a user
+// @Description  Removes a block so the user can send commands to the authenticated user again
+// @Tags         blocks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        user_id path string true "Blocked user ID"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /blocks/{user_id} [delete]
+func (h *BlockHandlers) UnblockUser(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	blockedID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	if err := h.Service.UnblockUser(userID, blockedID); err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to unblock user"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "User unblocked"})
+}
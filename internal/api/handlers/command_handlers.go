@@ -1,49 +1,101 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
 	"github.com/thecontrolapp/controlme-go/internal/services"
 )
 
 type CommandHandlers struct {
-	Service *services.CommandService
+	Service         *services.CommandService
+	DeliveryService *services.DeliveryService
 }
 
-func NewCommandHandlers(service *services.CommandService) *CommandHandlers {
-	return &CommandHandlers{Service: service}
+func NewCommandHandlers(service *services.CommandService, deliveryService *services.DeliveryService) *CommandHandlers {
+	return &CommandHandlers{Service: service, DeliveryService: deliveryService}
 }
 
-// GetPendingCommands gets pending commands for a user
-// GetPendingCommands godoc
-// @Summary      Get pending commands for a user
-// @Description  Retrieves pending commands for a given user
+// CreateCommand godoc
+// @Summary      Create a command
+// @Description  Stores a command from the authenticated user and delivers it live to online recipients. Without a receiver the command is broadcast to subscribers of its tags. A receiver blocking one of the tags answers 403 with the command_blocked error code.
 // @Tags         commands
 // @Accept       json
 // @Produce      json
-// @Param        user_id query string true "User ID"
-// @Success      200  {object}  responses.CommandsResponse
+// @Security     BearerAuth
+// @Param        command body services.CreateCommandRequest true "Command data"
+// @Success      201  {object}  responses.CommandResponse
 // @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
 // @Failure      500  {object}  responses.ErrorResponse
-// @Router       /commands/pending [get]
-func (h *CommandHandlers) GetPendingCommands(c *gin.Context) {
-	// TODO: Get user ID from JWT token
-	userIDStr := c.Query("user_id")
-	if userIDStr == "" {
-		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "user_id required"})
+// @Router       /commands [post]
+func (h *CommandHandlers) CreateCommand(c *gin.Context) {
+	senderID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req services.CreateCommandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
 		return
 	}
 
-	userID, err := uuid.Parse(userIDStr)
+	command, err := h.Service.CreateCommand(senderID, req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid user ID"})
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Receiver not found"})
+		case errors.Is(err, services.ErrAccountRestricted):
+			c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Account is suspended or banned"})
+		case errors.Is(err, services.ErrEmailNotVerified):
+			c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Verify your email address before sending commands"})
+		case errors.Is(err, services.ErrCommandBlocked):
+			c.JSON(http.StatusForbidden, responses.CodedErrorResponse{
+				Error:   responses.ErrorCodeCommandBlocked,
+				Message: "Receiver does not accept commands with these tags",
+			})
+		case errors.Is(err, services.ErrNoInstructions), errors.Is(err, services.ErrInvalidInstruction),
+			errors.Is(err, services.ErrUnknownTag), errors.Is(err, services.ErrUnknownFile), errors.Is(err, services.ErrBroadcastWithoutTags):
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to create command"})
+		}
 		return
 	}
 
-	commands, err := h.Service.GetPendingCommands(userID)
+	h.DeliveryService.Deliver(command)
+
+	c.JSON(http.StatusCreated, responses.CommandResponse{Command: *command})
+}
+
+// GetPendingCommands gets pending commands for the caller
+// GetPendingCommands godoc
+// @Summary      Get my pending commands
+// @Description  Retrieves the pending commands of the authenticated user and marks them delivered, so they can be completed and are not returned again
+// @Tags         commands
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.CommandsResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /commands/pending [get]
+func (h *CommandHandlers) GetPendingCommands(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	commands, err := h.Service.ReceivePendingCommands(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch commands"})
 		return
@@ -55,21 +107,23 @@ func (h *CommandHandlers) GetPendingCommands(c *gin.Context) {
 // CompleteCommand marks a command as completed
 // CompleteCommand godoc
 // @Summary      Mark a command as completed
-// @Description  Marks a specific command as completed
+// @Description  Marks a command addressed to the authenticated user as completed
 // @Tags         commands
 // @Accept       json
 // @Produce      json
+// @Security     BearerAuth
 // @Param        command_id query string true "Command ID"
-// @Param        user_id query string true "User ID"
 // @Success      200  {object}  responses.MessageResponse
 // @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
 // @Failure      500  {object}  responses.ErrorResponse
 // @Router       /commands/complete [post]
 func (h *CommandHandlers) CompleteCommand(c *gin.Context) {
-	// TODO: Get user ID from JWT token
-	userIDStr := c.Query("user_id")
-	if userIDStr == "" {
-		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "user_id required"})
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
 		return
 	}
 
@@ -79,12 +133,6 @@ func (h *CommandHandlers) CompleteCommand(c *gin.Context) {
 		return
 	}
 
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid user ID"})
-		return
-	}
-
 	commandID, err := uuid.Parse(commandIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid command ID"})
@@ -92,10 +140,177 @@ func (h *CommandHandlers) CompleteCommand(c *gin.Context) {
 	}
 
 	err = h.Service.CompleteCommand(commandID, userID)
-	if err != nil {
+	var transitionErr *services.InvalidTransitionError
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrCommandNotFound):
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Command not found"})
+		return
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: transitionErr.Error()})
+		return
+	default:
 		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to complete command"})
 		return
 	}
 
 	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Command completed successfully"})
 }
+
+// GetAwaitingApproval godoc
+// @Summary      List commands awaiting my approval
+// @Description  Retrieves the commands held because the authenticated user requires approval for one of their tags
+// @Tags         commands
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.CommandsResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /commands/approvals [get]
+func (h *CommandHandlers) GetAwaitingApproval(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	commands, err := h.Service.GetAwaitingApproval(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch commands"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.CommandsResponse{Commands: commands})
+}
+
+// ApproveCommand godoc
+// @Summary      Approve a command
+// @Description  Releases a command awaiting the authenticated user's approval and delivers it
+// @Tags         commands
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "Command ID"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /commands/{id}/approve [post]
+func (h *CommandHandlers) ApproveCommand(c *gin.Context) {
+	userID, commandID, ok := h.commandTarget(c)
+	if !ok {
+		return
+	}
+
+	if !h.respondApprovalError(c, h.Service.ApproveCommand(commandID, userID), "Failed to approve command") {
+		return
+	}
+
+	command, err := h.Service.GetCommandByID(commandID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to load command"})
+		return
+	}
+	h.DeliveryService.DeliverTo(userID, command)
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Command approved"})
+}
+
+// DeclineCommand godoc
+// @Summary      Decline a command
+// @Description  Refuses a command awaiting the authenticated user's approval
+// @Tags         commands
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "Command ID"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /commands/{id}/decline [post]
+func (h *CommandHandlers) DeclineCommand(c *gin.Context) {
+	userID, commandID, ok := h.commandTarget(c)
+	if !ok {
+		return
+	}
+
+	if !h.respondApprovalError(c, h.Service.DeclineCommand(commandID, userID), "Failed to decline command") {
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Command declined"})
+}
+
+// CancelCommand godoc
+// @Summary      Cancel a command
+// @Description  Withdraws a command the authenticated user sent from every recipient that has not finished it
+// @Tags         commands
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "Command ID"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /commands/{id}/cancel [post]
+func (h *CommandHandlers) CancelCommand(c *gin.Context) {
+	userID, commandID, ok := h.commandTarget(c)
+	if !ok {
+		return
+	}
+
+	err := h.Service.CancelSentCommand(commandID, userID)
+	var transitionErr *services.InvalidTransitionError
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrCommandNotFound):
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Command not found"})
+		return
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Command is already finished"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to cancel command"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Command cancelled"})
+}
+
+// commandTarget reads the authenticated user and the command ID of a request on one command
+func (h *CommandHandlers) commandTarget(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	commandID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid command ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, commandID, true
+}
+
+// respondApprovalError writes the response for a failed approval or decline.
+// It reports whether err was nil.
+func (h *CommandHandlers) respondApprovalError(c *gin.Context, err error, message string) bool {
+	var transitionErr *services.InvalidTransitionError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrCommandNotFound):
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Command not found"})
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Command is not awaiting approval"})
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: message})
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+)
+
+type DeviceHandlers struct {
+	Service *services.DeviceService
+}
+
+func NewDeviceHandlers(service *services.DeviceService) *DeviceHandlers {
+	return &DeviceHandlers{Service: service}
+}
+
+// StartPairingRequest is the body of a device's pairing request
+type StartPairingRequest struct {
+	DeviceName string `json:"device_name" binding:"max=100"`
+}
+
+// ClaimPairingRequest is the body of a device's poll for its pairing
+type ClaimPairingRequest struct {
+	DeviceCode string `json:"device_code" binding:"required"`
+}
+
+// ApprovePairingRequest is the body of a pairing approval
+type ApprovePairingRequest struct {
+	UserCode string `json:"user_code" binding:"required"`
+	Name     string `json:"name" binding:"max=100"` // Overrides the name the device sent
+	Scope    string `json:"scope"`                  // receive (default) or send
+}
+
+// DenyPairingRequest is the body of a pairing denial
+type DenyPairingRequest struct {
+	UserCode string `json:"user_code" binding:"required"`
+}
+
+// StartPairing godoc
+// @Summary      Start device pairing
+// @Description  Called by a desktop client to get a user code to show and a device code to poll with. A signed-in user then approves the user code.
+// @Tags         devices
+// @Accept       json
+// @Produce      json
+// @Param        request body StartPairingRequest false "Device details"
+// @Success      201  {object}  responses.PairingResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /devices/pair [post]
+func (h *DeviceHandlers) StartPairing(c *gin.Context) {
+	var req StartPairingRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+			return
+		}
+	}
+
+	codes, err := h.Service.StartPairing(req.DeviceName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to start pairing"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, responses.PairingResponse{
+		DeviceCode: codes.DeviceCode,
+		UserCode:   codes.UserCode,
+		ExpiresIn:  codes.ExpiresIn,
+		Interval:   codes.Interval,
+	})
+}
+
+// ClaimPairing godoc
+// @Summary      Poll device pairing
+// @Description  Called by a desktop client with its device code. Returns 202 while the pairing awaits approval and the device key once it is approved. The key is only returned once.
+// @Tags         devices
+// @Accept       json
+// @Produce      json
+// @Param        request body ClaimPairingRequest true "Device code"
+// @Success      200  {object}  responses.DeviceKeyResponse
+// @Success      202  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      410  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /devices/pair/token [post]
+func (h *DeviceHandlers) ClaimPairing(c *gin.Context) {
+	var req ClaimPairingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	issued, err := h.Service.ClaimPairing(req.DeviceCode)
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrPairingPending):
+		c.JSON(http.StatusAccepted, responses.MessageResponse{Message: "Waiting for approval"})
+		return
+	case errors.Is(err, services.ErrPairingDenied):
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Pairing was denied"})
+		return
+	case errors.Is(err, services.ErrPairingExpired):
+		c.JSON(http.StatusGone, responses.ErrorResponse{Error: "Pairing code expired"})
+		return
+	case errors.Is(err, services.ErrPairingNotFound):
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Pairing not found"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to complete pairing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.DeviceKeyResponse{
+		Device: issued.Credential,
+		Key:    issued.Key,
+	})
+}
+
+// ApprovePairing godoc
+// @Summary      Approve device pairing
+// @Description  Pairs the desktop client showing the user code with the authenticated user's account
+// @Tags         devices
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body ApprovePairingRequest true "User code and device scope"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /devices/pair/approve [post]
+func (h *DeviceHandlers) ApprovePairing(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req ApprovePairingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	err := h.Service.ApprovePairing(userID, req.UserCode, req.Name, req.Scope)
+	if !h.respondPairingError(c, err) {
+		c.JSON(http.StatusOK, responses.MessageResponse{Message: "Device approved"})
+	}
+}
+
+// DenyPairing godoc
+// @Summary      Deny device pairing
+// @Description  Refuses the pairing of the desktop client showing the user code
+// @Tags         devices
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body DenyPairingRequest true "User code"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /devices/pair/deny [post]
+func (h *DeviceHandlers) DenyPairing(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req DenyPairingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	err := h.Service.DenyPairing(userID, req.UserCode)
+	if !h.respondPairingError(c, err) {
+		c.JSON(http.StatusOK, responses.MessageResponse{Message: "Device denied"})
+	}
+}
+
+// respondPairingError writes the response for a failed approval or denial and reports whether it did
+func (h *DeviceHandlers) respondPairingError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrInvalidDeviceScope):
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Scope must be receive or send"})
+	case errors.Is(err, services.ErrPairingNotFound):
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Unknown or expired pairing code"})
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to answer pairing"})
+	}
+	return true
+}
+
+// GetDevices godoc
+// @Summary      List my devices
+// @Description  Retrieves the authenticated user's paired devices that have not been revoked
+// @Tags         devices
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.DevicesResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /devices [get]
+func (h *DeviceHandlers) GetDevices(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	devices, err := h.Service.GetDevices(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch devices"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.DevicesResponse{Devices: devices})
+}
+
+// RevokeDevice godoc
+// @Summary      Revoke a device
+// @Description  Revokes a paired device's key and closes its WebSocket connections
+// @Tags         devices
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "Device ID"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /devices/{id} [delete]
+func (h *DeviceHandlers) RevokeDevice(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid device ID"})
+		return
+	}
+
+	err = h.Service.RevokeDevice(userID, deviceID)
+	if errors.Is(err, services.ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Device not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to revoke device"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Device revoked"})
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+)
+
+// Room for multipart headers on top of the largest accepted file
+const multipartOverhead = 1 << 20
+
+type FileHandlers struct {
+	Service *services.FileService
+}
+
+func NewFileHandlers(service *services.FileService) *FileHandlers {
+	return &FileHandlers{Service: service}
+}
+
+// Upload godoc
+// @Summary      Upload a file
+// @Description  Stores a file for use in download-file instructions. Files are stored once per SHA-256 hash; uploading content that is already stored only records the new file name. Files matching the blocklist are refused with 403 and their uploader is held for review. New files are quarantined with scan_status pending until every configured scanner passes them.
+// @Tags         files
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Param        file formData file true "File to upload"
+// @Success      201  {object}  responses.FileResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      413  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /files/upload [post]
+func (h *FileHandlers) Upload(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.Service.MaxSize()+multipartOverhead)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Expected a multipart form"})
+		return
+	}
+
+	// Stream the file part straight to storage instead of buffering the form
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Missing file field"})
+			return
+		}
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		metadata, fileName, err := h.Service.Upload(userID, part.FileName(), part)
+		part.Close()
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, responses.FileResponse{
+			File:        *metadata,
+			FileName:    fileName.Name,
+			DownloadURL: "/api/v1/files/download/" + metadata.Hash + "/" + url.PathEscape(fileName.Name),
+		})
+		return
+	}
+}
+
+// respondUploadError writes the response for a failed upload
+func respondUploadError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrFileTooLarge), errors.As(err, &maxBytesErr):
+		c.JSON(http.StatusRequestEntityTooLarge, responses.ErrorResponse{Error: "File too large"})
+	case errors.Is(err, services.ErrInvalidFileName):
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid file name"})
+	case errors.Is(err, services.ErrFileBlocked):
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "File is not allowed"})
+	case errors.Is(err, services.ErrAccountRestricted):
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Account is suspended or banned"})
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to upload file"})
+	}
+}
+
+// Download godoc
+// @Summary      Download a file
+// @Description  Returns the content of a stored file as an attachment named filename. Range requests are supported. Files that have not passed scanning yet return 409, files a scanner flagged return 403. Desktop clients may authenticate with their device key.
+// @Tags         files
+// @Produce      octet-stream
+// @Security     BearerAuth
+// @Param        hash path string true "SHA-256 hash of the content"
+// @Param        filename path string true "Name to save the file as"
+// @Success      200  {file}    file
+// @Success      206  {file}    file
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /files/download/{hash}/{filename} [get]
+func (h *FileHandlers) Download(c *gin.Context) {
+	hash := c.Param("hash")
+	fileName := c.Param("filename")
+
+	metadata, file, err := h.Service.Open(hash)
+	if errors.Is(err, services.ErrFileNotFound) {
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "File not found"})
+		return
+	}
+	if errors.Is(err, services.ErrFileQuarantined) {
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "File is awaiting a security scan, try again later"})
+		return
+	}
+	if errors.Is(err, services.ErrFileInfected) {
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "File failed a security scan"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to download file"})
+		return
+	}
+	defer file.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": fileName})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", disposition)
+	c.Header("Content-Type", metadata.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	// The content of a hash never changes
+	c.Header("ETag", `"`+metadata.Hash+`"`)
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(c.Writer, c.Request, fileName, metadata.CreatedAt, file)
+}
+
+// ReloadBlocklist godoc
+// @Summary      Reload the upload blocklist
+// @Description  Reads the blocklist file again without restarting the server, as sending the server SIGHUP does. If the file cannot be read the previous entries stay in use. Requires blocklist:manage.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.BlocklistResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /admin/blocklist/reload [post]
+func (h *FileHandlers) ReloadBlocklist(c *gin.Context) {
+	count, err := h.Service.ReloadBlocklist()
+	if errors.Is(err, services.ErrBlocklistDisabled) {
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "No blocklist is configured"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to reload blocklist: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, responses.BlocklistResponse{Entries: count})
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+)
+
+type InstructionHandlers struct{}
+
+func NewInstructionHandlers() *InstructionHandlers {
+	return &InstructionHandlers{}
+}
+
+// GetInstructionSchema godoc
+// @Summary      Get the instruction JSON Schema
+// @Description  Returns a JSON Schema describing every registered instruction type and its content
+// @Tags         instructions
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Router       /instructions/schema [get]
+func (h *InstructionHandlers) GetInstructionSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, models.InstructionSchema())
+}
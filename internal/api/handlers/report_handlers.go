@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+)
+
+// Paging defaults for the report review queue
+const (
+	defaultReportPageSize = 20
+	maxReportPageSize     = 100
+)
+
+type ReportHandlers struct {
+	Service *services.ReportService
+}
+
+func NewReportHandlers(service *services.ReportService) *ReportHandlers {
+	return &ReportHandlers{Service: service}
+}
+
+// CreateReport godoc
+// @Summary      Report a user
+// @Description  Files a report against another user, optionally pointing at a command they sent you as evidence
+// @Tags         reports
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        report body services.CreateReportRequest true "Report"
+// @Success      201  {object}  responses.ReportResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /reports [post]
+func (h *ReportHandlers) CreateReport(c *gin.Context) {
+	reporterID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req services.CreateReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	report, err := h.Service.CreateReport(reporterID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrCannotReportSelf), errors.Is(err, services.ErrInvalidEvidence):
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: err.Error()})
+		case errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to create report"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, responses.ReportResponse{Report: *report})
+}
+
+// ListReports godoc
+// @Summary      List reports
+// @Description  Retrieves the report review queue, oldest first. Requires reports:review.
+// @Tags         moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        status query string false "Report status (pending, resolved); all when empty"
+// @Param        page query int false "Page number, starting at 1"
+// @Param        page_size query int false "Reports per page (max 100)"
+// @Success      200  {object}  responses.ReportsResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /admin/reports [get]
+func (h *ReportHandlers) ListReports(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != models.ReportStatusPending && status != models.ReportStatusResolved {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid status"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultReportPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxReportPageSize {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid page_size"})
+		return
+	}
+
+	reports, total, err := h.Service.ListReports(status, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch reports"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.ReportsResponse{
+		Reports:  reports,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
+
+// GetReport godoc
+// @Summary      Get a report
+// @Description  Retrieves a report with its evidence command. Requires reports:review.
+// @Tags         moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "Report ID"
+// @Success      200  {object}  responses.ReportResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /admin/reports/{id} [get]
+func (h *ReportHandlers) GetReport(c *gin.Context) {
+	reportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid report ID"})
+		return
+	}
+
+	report, err := h.Service.GetReport(reportID)
+	if err != nil {
+		if errors.Is(err, services.ErrReportNotFound) {
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Report not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch report"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.ReportResponse{Report: *report})
+}
+
+// ResolveReport godoc
+// @Summary      Resolve a report
+// @Description  Dismisses a pending report or warns, suspends or bans the reported user. The resolution is recorded with the moderator, note and time. Requires reports:resolve.
+// @Tags         moderation
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "Report ID"
+// @Param        resolution body services.ResolveReportRequest true "Resolution"
+// @Success      200  {object}  responses.ReportResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /admin/reports/{id}/resolve [post]
+func (h *ReportHandlers) ResolveReport(c *gin.Context) {
+	moderatorID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	reportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid report ID"})
+		return
+	}
+
+	var req services.ResolveReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	report, err := h.Service.ResolveReport(reportID, moderatorID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidResolution):
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Resolution must be dismiss, warn, suspend (with suspend_hours) or ban"})
+		case errors.Is(err, services.ErrReportNotFound):
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Report not found"})
+		case errors.Is(err, services.ErrReportAlreadyResolved):
+			c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Report is already resolved"})
+		default:
+			c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to resolve report"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, responses.ReportResponse{Report: *report})
+}
+
+// ReleaseReviewHold godoc
+// @Summary      Release a user held for review
+// @Description  Lifts the hold placed on a user whose upload matched the blocklist, after a moderator has reviewed the match in the audit log. The release is recorded in the audit log. Requires reports:resolve.
+// @Tags         moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User ID"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /admin/users/{id}/release-hold [post]
+func (h *ReportHandlers) ReleaseReviewHold(c *gin.Context) {
+	moderatorID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	err = h.Service.ReleaseReviewHold(userID, moderatorID)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, responses.MessageResponse{Message: "Review hold released"})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "User not found"})
+	case errors.Is(err, services.ErrNoReviewHold):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "User is not held for review"})
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to release review hold"})
+	}
+}
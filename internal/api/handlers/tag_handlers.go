@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+)
+
+type TagHandlers struct {
+	Service *services.TagService
+}
+
+func NewTagHandlers(service *services.TagService) *TagHandlers {
+	return &TagHandlers{Service: service}
+}
+
+// GetTags godoc
+// @Summary      List tags
+// @Description  Retrieves every content tag
+// @Tags         tags
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.TagsResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /tags [get]
+func (h *TagHandlers) GetTags(c *gin.Context) {
+	tags, err := h.Service.GetAllTags()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch tags"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.TagsResponse{Tags: tags})
+}
+
+// GetSubscriptions godoc
+// @Summary      List my tag subscriptions
+// @Description  Retrieves the tags the authenticated user receives broadcasts for
+// @Tags         tags
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.TagSubscriptionsResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /tags/subscriptions [get]
+func (h *TagHandlers) GetSubscriptions(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	subscriptions, err := h.Service.GetSubscriptions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch subscriptions"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.TagSubscriptionsResponse{Subscriptions: subscriptions})
+}
+
+// Subscribe godoc
+// @Summary      Subscribe to a tag
+// @Description  Receive broadcast commands carrying the tag
+// @Tags         tags
+// @Produce      json
+// @Security     BearerAuth
+// @Param        name path string true "Tag name"
+// @Success      200  {object}  responses.TagSubscriptionResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /tags/{name}/subscription [post]
+func (h *TagHandlers) Subscribe(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	subscription, err := h.Service.Subscribe(userID, c.Param("name"))
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownTag) {
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Tag not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to subscribe"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.TagSubscriptionResponse{Subscription: *subscription})
+}
+
+// Unsubscribe godoc
+// @Summary      Unsubscribe from a tag
+// @Description  Stop receiving broadcast commands carrying the tag
+// @Tags         tags
+// @Produce      json
+// @Security     BearerAuth
+// @Param        name path string true "Tag name"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /tags/{name}/subscription [delete]
+func (h *TagHandlers) Unsubscribe(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	if err := h.Service.Unsubscribe(userID, c.Param("name")); err != nil {
+		if errors.Is(err, services.ErrUnknownTag) {
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Tag not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to unsubscribe"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Unsubscribed successfully"})
+}
+
+// SetTagPreferenceRequest is the body of a tag preference update
+type SetTagPreferenceRequest struct {
+	Preference string `json:"preference" binding:"required" enums:"allow,block,require_approval"`
+}
+
+// GetPreferences godoc
+// @Summary      List my tag preferences
+// @Description  Retrieves how the authenticated user wants commands carrying each tag handled. Tags without a preference are allowed.
+// @Tags         tags
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.TagPreferencesResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /tags/preferences [get]
+func (h *TagHandlers) GetPreferences(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	preferences, err := h.Service.GetPreferences(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.TagPreferencesResponse{Preferences: preferences})
+}
+
+// SetPreference godoc
+// @Summary      Set a tag preference
+// @Description  Allow, block or require approval for commands carrying the tag
+// @Tags         tags
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        name path string true "Tag name"
+// @Param        preference body SetTagPreferenceRequest true "Preference"
+// @Success      200  {object}  responses.TagPreferenceResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /tags/{name}/preference [put]
+func (h *TagHandlers) SetPreference(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req SetTagPreferenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	preference, err := h.Service.SetPreference(userID, c.Param("name"), req.Preference)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidTagPreference):
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Preference must be allow, block or require_approval"})
+		case errors.Is(err, services.ErrUnknownTag):
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Tag not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to set preference"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, responses.TagPreferenceResponse{Preference: *preference})
+}
+
+// ClearPreference godoc
+// @Summary      Clear a tag preference
+// @Description  Removes the preference for the tag, so commands carrying it are allowed again
+// @Tags         tags
+// @Produce      json
+// @Security     BearerAuth
+// @Param        name path string true "Tag name"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /tags/{name}/preference [delete]
+func (h *TagHandlers) ClearPreference(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	if err := h.Service.ClearPreference(userID, c.Param("name")); err != nil {
+		if errors.Is(err, services.ErrUnknownTag) {
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Tag not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to clear preference"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Preference cleared"})
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+)
+
+type TwoFactorHandlers struct {
+	Service    *services.TwoFactorService
+	LoginGuard *services.LoginGuard
+}
+
+func NewTwoFactorHandlers(service *services.TwoFactorService, loginGuard *services.LoginGuard) *TwoFactorHandlers {
+	return &TwoFactorHandlers{Service: service, LoginGuard: loginGuard}
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"` // Current code from the authenticator app
+}
+
+// GetStatus godoc
+// @Summary      Get two-factor status
+// @Description  Returns whether the caller has two-factor authentication enabled and how many recovery codes are left
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.TwoFactorStatusResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/2fa [get]
+func (h *TwoFactorHandlers) GetStatus(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	status, err := h.Service.GetStatus(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to get two-factor status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.TwoFactorStatusResponse{
+		Enabled:                status.Enabled,
+		RecoveryCodesRemaining: status.RecoveryCodesRemaining,
+	})
+}
+
+// Setup godoc
+// @Summary      Set up two-factor authentication
+// @Description  Generates a new TOTP secret and its otpauth:// provisioning URI, to be shown as a QR code. Two-factor authentication is not enabled until a code is confirmed at /auth/2fa/enable. Calling it again replaces the secret of an unfinished setup.
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  responses.TwoFactorSetupResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/2fa/setup [post]
+func (h *TwoFactorHandlers) Setup(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	setup, err := h.Service.Setup(userID)
+	if errors.Is(err, services.ErrTwoFactorEnabled) {
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Two-factor authentication is already enabled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to set up two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.TwoFactorSetupResponse{
+		Secret:          setup.Secret,
+		ProvisioningURI: setup.ProvisioningURI,
+	})
+}
+
+// Enable godoc
+// @Summary      Enable two-factor authentication
+// @Description  Confirms the secret from /auth/2fa/setup with a code from the authenticator app and enables two-factor authentication. The response carries the recovery codes, which are only shown once.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body TwoFactorCodeRequest true "Authenticator code"
+// @Success      200  {object}  responses.RecoveryCodesResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/2fa/enable [post]
+func (h *TwoFactorHandlers) Enable(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	codes, err := h.Service.Enable(userID, req.Code)
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.RecoveryCodesResponse{
+		Message:       "Two-factor authentication enabled",
+		RecoveryCodes: codes,
+	})
+}
+
+// Disable godoc
+// @Summary      Disable two-factor authentication
+// @Description  Disables two-factor authentication and deletes the recovery codes. Requires a current code from the authenticator app; recovery codes are not accepted. Wrong codes count as failed logins of the account.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body TwoFactorCodeRequest true "Authenticator code"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      429  {object}  responses.RateLimitResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/2fa/disable [post]
+func (h *TwoFactorHandlers) Disable(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	if !allowReauthentication(c, h.LoginGuard, userID, "Failed to disable two-factor authentication") {
+		return
+	}
+
+	if err := h.Service.Disable(userID, req.Code); err != nil {
+		if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			recordReauthenticationFailure(c, h.LoginGuard, userID)
+		}
+		respondTwoFactorError(c, err, "Failed to disable two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary      Regenerate recovery codes
+// @Description  Replaces the caller's recovery codes with new ones, which are only shown once. Requires a current code from the authenticator app. Wrong codes count as failed logins of the account.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body TwoFactorCodeRequest true "Authenticator code"
+// @Success      200  {object}  responses.RecoveryCodesResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      429  {object}  responses.RateLimitResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /auth/2fa/recovery-codes [post]
+func (h *TwoFactorHandlers) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	if !allowReauthentication(c, h.LoginGuard, userID, "Failed to regenerate recovery codes") {
+		return
+	}
+
+	codes, err := h.Service.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			recordReauthenticationFailure(c, h.LoginGuard, userID)
+		}
+		respondTwoFactorError(c, err, "Failed to regenerate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, responses.RecoveryCodesResponse{
+		Message:       "Recovery codes regenerated, the old ones no longer work",
+		RecoveryCodes: codes,
+	})
+}
+
+// respondTwoFactorError writes the response for an error from changing two-factor settings
+func respondTwoFactorError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Invalid two-factor code"})
+	case errors.Is(err, services.ErrTwoFactorEnabled):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Two-factor authentication is already enabled"})
+	case errors.Is(err, services.ErrTwoFactorNotSetUp):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Two-factor authentication has not been set up"})
+	case errors.Is(err, services.ErrTwoFactorNotEnabled):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Two-factor authentication is not enabled"})
+	default:
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: message})
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+)
+
+// tus protocol version and extensions the upload routes implement
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration"
+)
+
+// statusChecksumMismatch is the tus status for a file that does not match its hash
+const statusChecksumMismatch = 460
+
+// UploadHandlers implement resumable uploads with the tus 1.0 protocol
+// (https://tus.io/protocols/resumable-upload), core and creation extension
+type UploadHandlers struct {
+	Service *services.UploadService
+}
+
+func NewUploadHandlers(service *services.UploadService) *UploadHandlers {
+	return &UploadHandlers{Service: service}
+}
+
+// Options godoc
+// @Summary      Discover resumable upload support
+// @Description  Returns the tus versions, extensions and maximum size the server supports in the Tus-Version, Tus-Extension and Tus-Max-Size headers.
+// @Tags         files
+// @Success      204
+// @Router       /files/uploads [options]
+func (h *UploadHandlers) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.Service.MaxSize(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// Create godoc
+// @Summary      Start a resumable upload
+// @Description  Creates a tus upload of Upload-Length bytes and returns its URL in the Location header. Upload-Metadata must contain the base64 encoded filename and sha256 (hex SHA-256 of the whole file); a finished upload with another hash is discarded. Unfinished uploads expire at Upload-Expires.
+// @Tags         files
+// @Security     BearerAuth
+// @Param        Tus-Resumable header string true "tus version, 1.0.0"
+// @Param        Upload-Length header int true "Size of the file in bytes"
+// @Param        Upload-Metadata header string true "filename <base64>,sha256 <base64>"
+// @Success      201
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      412  {object}  responses.ErrorResponse
+// @Failure      413  {object}  responses.ErrorResponse
+// @Failure      429  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /files/uploads [post]
+func (h *UploadHandlers) Create(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Upload-Defer-Length is not supported"})
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid Upload-Length"})
+		return
+	}
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid Upload-Metadata"})
+		return
+	}
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+
+	upload, err := h.Service.Create(userID, fileName, length, metadata["sha256"])
+	if err != nil {
+		respondTusError(c, err)
+		return
+	}
+
+	c.Header("Location", "/api/v1/files/uploads/"+upload.ID.String())
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusCreated)
+}
+
+// Head godoc
+// @Summary      Get the offset of a resumable upload
+// @Description  Returns how many bytes of a tus upload the server has in Upload-Offset, so the client can resume from there.
+// @Tags         files
+// @Security     BearerAuth
+// @Param        id path string true "Upload ID"
+// @Param        Tus-Resumable header string true "tus version, 1.0.0"
+// @Success      200
+// @Failure      401
+// @Failure      404
+// @Failure      412
+// @Router       /files/uploads/{id} [head]
+func (h *UploadHandlers) Head(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	uploadID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	upload, err := h.Service.Get(userID, uploadID)
+	if errors.Is(err, services.ErrUploadNotFound) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Upload-Length", strconv.FormatInt(upload.UploadLength, 10))
+	c.Header("Cache-Control", "no-store")
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusOK)
+}
+
+// Patch godoc
+// @Summary      Send a chunk of a resumable upload
+// @Description  Appends the body to a tus upload at Upload-Offset, which must be the upload's current offset. The new offset is returned in Upload-Offset. Once the last byte has arrived the file is checked against its sha256 and stored like a regular upload; the download URL is /files/download/{sha256}/{filename}.
+// @Tags         files
+// @Accept       application/offset+octet-stream
+// @Security     BearerAuth
+// @Param        id path string true "Upload ID"
+// @Param        Tus-Resumable header string true "tus version, 1.0.0"
+// @Param        Upload-Offset header int true "Offset the chunk starts at"
+// @Success      204
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      412  {object}  responses.ErrorResponse
+// @Failure      413  {object}  responses.ErrorResponse
+// @Failure      415  {object}  responses.ErrorResponse
+// @Failure      423  {object}  responses.ErrorResponse
+// @Failure      460  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /files/uploads/{id} [patch]
+func (h *UploadHandlers) Patch(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	userID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+	uploadID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Upload not found"})
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, responses.ErrorResponse{Error: "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid Upload-Offset"})
+		return
+	}
+
+	upload, err := h.Service.Append(userID, uploadID, offset, c.Request.Body, c.Request.ContentLength)
+	if upload != nil {
+		setUploadHeaders(c, upload)
+	}
+	if err != nil {
+		respondTusError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkTusResumable sets the Tus-Resumable response header and reports
+// whether the request uses the supported tus version. Otherwise it responds
+// with 412.
+func checkTusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, responses.ErrorResponse{Error: "Unsupported tus version"})
+		return false
+	}
+	return true
+}
+
+// setUploadHeaders sets the offset and, for unfinished uploads, the expiry of an upload
+func setUploadHeaders(c *gin.Context, upload *models.FileUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.UploadOffset, 10))
+	if !upload.Completed {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma-separated
+// pairs of a key and an optional base64 encoded value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, errors.New("malformed metadata pair")
+		}
+	}
+	return metadata, nil
+}
+
+// respondTusError writes the response for a failed upload request
+func respondTusError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "Upload not found"})
+	case errors.Is(err, services.ErrUploadOffsetMismatch):
+		c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Upload-Offset does not match the upload"})
+	case errors.Is(err, services.ErrUploadBusy):
+		c.JSON(http.StatusLocked, responses.ErrorResponse{Error: "Another chunk of this upload is being received"})
+	case errors.Is(err, services.ErrUploadHashMismatch):
+		c.JSON(statusChecksumMismatch, responses.ErrorResponse{Error: "Uploaded file does not match its sha256, upload discarded"})
+	case errors.Is(err, services.ErrInvalidUploadHash):
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Upload-Metadata must contain the sha256 of the file"})
+	case errors.Is(err, services.ErrInvalidUploadLength):
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid Upload-Length"})
+	case errors.Is(err, services.ErrUploadChunkTooLong):
+		c.JSON(http.StatusRequestEntityTooLarge, responses.ErrorResponse{Error: "Chunk is longer than the rest of the upload"})
+	case errors.Is(err, services.ErrTooManyUploads):
+		c.JSON(http.StatusTooManyRequests, responses.ErrorResponse{Error: "Too many unfinished uploads, finish one or let it expire first"})
+	default:
+		respondUploadError(c, err)
+	}
+}
@@ -1,76 +1,124 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/models"
 	"github.com/thecontrolapp/controlme-go/internal/services"
 )
 
 type UserHandlers struct {
-	Service *services.UserService
+	Service    *services.UserService
+	LoginGuard *services.LoginGuard
 }
 
-func NewUserHandlers(service *services.UserService) *UserHandlers {
-	return &UserHandlers{Service: service}
+func NewUserHandlers(service *services.UserService, loginGuard *services.LoginGuard) *UserHandlers {
+	return &UserHandlers{Service: service, LoginGuard: loginGuard}
 }
 
 // UserHandler provides modern RESTful user endpoints
 // GetUsers godoc
 // @Summary      Get all users
-// @Description  Retrieves a list of all users
+// @Description  Retrieves a list of all users, except those who have blocked the caller. Requires users:read.
+// @Description  Callers with reports:review also get each user's moderation state.
 // @Tags         users
 // @Accept       json
 // @Produce      json
+// @Security     BearerAuth
 // @Success      200  {object}  responses.UsersResponse
+// @Success      200  {object}  responses.ModeratedUsersResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
 // @Failure      500  {object}  responses.ErrorResponse
 // @Router       /users [get]
 func (h *UserHandlers) GetUsers(c *gin.Context) {
-	users, err := h.Service.GetAllUsers()
+	viewerID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	users, err := h.Service.GetAllUsers(viewerID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to fetch users"})
 		return
 	}
+	if h.isModerator(viewerID) {
+		moderated := make([]responses.ModeratedUser, len(users))
+		for i, user := range users {
+			moderated[i] = responses.NewModeratedUser(user)
+		}
+		c.JSON(http.StatusOK, responses.ModeratedUsersResponse{Users: moderated})
+		return
+	}
 	c.JSON(http.StatusOK, responses.UsersResponse{Users: users})
 }
 
 // GetUserByID godoc
 // @Summary      Get a user by ID
-// @Description  Retrieves a user by their ID
+// @Description  Retrieves a user by their ID. Users who have blocked the caller are not found. Requires users:read.
+// @Description  Callers with reports:review also get the user's moderation state.
 // @Tags         users
 // @Accept       json
 // @Produce      json
+// @Security     BearerAuth
 // @Param        id path string true "User ID"
 // @Success      200  {object}  responses.UserResponse
+// @Success      200  {object}  responses.ModeratedUserResponse
 // @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
 // @Failure      404  {object}  responses.ErrorResponse
 // @Router       /users/{id} [get]
 func (h *UserHandlers) GetUserByID(c *gin.Context) {
+	viewerID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
 	id := c.Param("id")
 	userID, err := uuid.Parse(id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid user ID"})
 		return
 	}
-	user, err := h.Service.GetUserByID(userID)
+	user, err := h.Service.GetVisibleUser(userID, viewerID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "User not found"})
 		return
 	}
+	if h.isModerator(viewerID) {
+		c.JSON(http.StatusOK, responses.ModeratedUserResponse{User: responses.NewModeratedUser(*user)})
+		return
+	}
 	c.JSON(http.StatusOK, responses.UserResponse{User: *user})
 }
 
+// isModerator reports whether the caller may see users' moderation state
+func (h *UserHandlers) isModerator(userID uuid.UUID) bool {
+	role, err := h.Service.GetUserRole(userID)
+	return err == nil && models.HasPermission(role, models.PermissionReportsReview)
+}
+
 // CreateUser godoc
 // @Summary      Create a new user
-// @Description  Creates a new user
+// @Description  Creates a new user directly, bypassing registration. Requires users:create.
 // @Tags         users
 // @Accept       json
 // @Produce      json
+// @Security     BearerAuth
 // @Param        user body services.CreateUserRequest true "User data"
 // @Success      201  {object}  responses.UserResponse
 // @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
 // @Failure      500  {object}  responses.ErrorResponse
 // @Router       /users [post]
 func (h *UserHandlers) CreateUser(c *gin.Context) {
@@ -80,9 +128,105 @@ func (h *UserHandlers) CreateUser(c *gin.Context) {
 		return
 	}
 	user, err := h.Service.CreateUser(req)
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: policyErr.Reason})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to create user"})
 		return
 	}
 	c.JSON(http.StatusCreated, responses.UserResponse{User: *user})
 }
+
+// SetUserRoleRequest is the body of a role change
+type SetUserRoleRequest struct {
+	Role string `json:"role" binding:"required" enums:"user,moderator,admin"`
+}
+
+// SetUserRole godoc
+// @Summary      Change a user's role
+// @Description  Sets the role of a user. The last admin cannot be demoted. Requires users:manage_roles.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User ID"
+// @Param        role body SetUserRoleRequest true "New role"
+// @Success      200  {object}  responses.UserResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      409  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /admin/users/{id}/role [put]
+func (h *UserHandlers) SetUserRole(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	var req SetUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	user, err := h.Service.SetUserRole(userID, req.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRole):
+			c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Role must be user, moderator or admin"})
+		case errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "User not found"})
+		case errors.Is(err, services.ErrLastAdmin):
+			c.JSON(http.StatusConflict, responses.ErrorResponse{Error: "Cannot demote the last admin"})
+		default:
+			c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to change role"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, responses.UserResponse{User: *user})
+}
+
+// UnlockUser godoc
+// @Summary      Unlock a user's login
+// @Description  Clears the failed logins that locked a user's account. The unlock is recorded in the audit log. Requires users:unlock.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User ID"
+// @Success      200  {object}  responses.MessageResponse
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /admin/users/{id}/unlock [post]
+func (h *UserHandlers) UnlockUser(c *gin.Context) {
+	actorID, ok := middleware.UserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Authentication required"})
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	err = h.LoginGuard.Unlock(userID, actorID)
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, responses.ErrorResponse{Error: "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, responses.ErrorResponse{Error: "Failed to unlock user"})
+		return
+	}
+	c.JSON(http.StatusOK, responses.MessageResponse{Message: "User unlocked"})
+}
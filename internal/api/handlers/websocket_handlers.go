@@ -1,36 +1,246 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/services"
 	wshub "github.com/thecontrolapp/controlme-go/internal/websocket"
 )
 
 type WebSocketHandlers struct {
-	Hub *wshub.Hub
+	Upgrader        gorillaws.Upgrader
+	Hub             *wshub.Hub
+	JWTManager      *auth.JWTManager
+	SessionService  *services.SessionService
+	DeviceService   *services.DeviceService
+	CommandService  *services.CommandService
+	DeliveryService *services.DeliveryService
 }
 
-func NewWebSocketHandlers(hub *wshub.Hub) *WebSocketHandlers {
+// NewWebSocketHandlers creates the WebSocket handlers. Browsers may only
+// upgrade from the API's own host or from one of allowedOrigins, "*" for any.
+func NewWebSocketHandlers(hub *wshub.Hub, jwtManager *auth.JWTManager, sessionService *services.SessionService, deviceService *services.DeviceService, commandService *services.CommandService, deliveryService *services.DeliveryService, allowedOrigins []string) *WebSocketHandlers {
 	return &WebSocketHandlers{
-		Hub: hub,
+		Upgrader: gorillaws.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     originChecker(allowedOrigins),
+		},
+		Hub:             hub,
+		JWTManager:      jwtManager,
+		SessionService:  sessionService,
+		DeviceService:   deviceService,
+		CommandService:  commandService,
+		DeliveryService: deliveryService,
 	}
 }
 
-// HandleClientWebSocket handles WebSocket connections for desktop clients
-func (h *WebSocketHandlers) HandleClientWebSocket(c *gin.Context) {
-	// TODO: Implement full WebSocket client handling
-	c.JSON(http.StatusNotImplemented, gin.H{
-		"message": "WebSocket client endpoint not yet implemented",
-		"type":    "desktop",
-	})
+// originChecker allows upgrades without an Origin header, which desktop
+// clients do not send, from the request's own host and from the allowed
+// origins. Browsers always send the header, so other pages cannot open a
+// connection with a token they obtained.
+func originChecker(allowedOrigins []string) func(r *http.Request) bool {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowed["*"] || allowed[strings.ToLower(origin)] {
+			return true
+		}
+		parsed, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(parsed.Host, r.Host)
+	}
+}
+
+// RegisterMessageHandlers wires the inbound WebSocket message types to their handlers
+func (h *WebSocketHandlers) RegisterMessageHandlers() {
+	h.Hub.HandleFunc(wshub.MessageTypeSendCommand, h.HandleSendCommand)
+	h.Hub.HandleFunc(wshub.MessageTypeCommandStatus, h.HandleCommandStatus)
+	h.Hub.OnUserConnected(h.DeliveryService.DeliverPending)
+}
+
+// HandleWebSocket upgrades an authenticated request to a WebSocket connection
+// HandleWebSocket godoc
+// @Summary      Open a WebSocket connection
+// @Description  Upgrades to the WebSocket protocol. The JWT or device key is read from the Authorization header or the token query parameter. Device keys always connect as a desktop client.
+// @Tags         websocket
+// @Param        token        query string false "JWT token or device key (alternative to the Authorization header)"
+// @Param        client_type  query string false "Client type: web or desktop" default(web)
+// @Success      101
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Router       /ws [get]
+func (h *WebSocketHandlers) HandleWebSocket(c *gin.Context) {
+	token := bearerToken(c)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Missing token"})
+		return
+	}
+
+	if strings.HasPrefix(token, auth.DeviceKeyPrefix) {
+		h.connectDevice(c, token)
+		return
+	}
+
+	claims, err := h.JWTManager.ValidateToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Invalid or expired token"})
+		return
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Invalid or expired token"})
+		return
+	}
+
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil || !h.SessionService.IsActive(userID, sessionID) {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Session expired or revoked"})
+		return
+	}
+
+	clientType := c.DefaultQuery("client_type", wshub.ClientTypeWeb)
+	if clientType != wshub.ClientTypeWeb && clientType != wshub.ClientTypeDesktop {
+		c.JSON(http.StatusBadRequest, responses.ErrorResponse{Error: "Invalid client type"})
+		return
+	}
+
+	conn, err := h.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written an HTTP error response
+		logrus.WithError(err).Warn("WebSocket upgrade failed")
+		return
+	}
+
+	client := wshub.NewClient(h.Hub, conn, userID, sessionID, clientType)
+	client.Register()
 }
 
-// HandleWebWebSocket handles WebSocket connections for web clients
-func (h *WebSocketHandlers) HandleWebWebSocket(c *gin.Context) {
-	// TODO: Implement full WebSocket web handling
-	c.JSON(http.StatusNotImplemented, gin.H{
-		"message": "WebSocket web endpoint not yet implemented",
-		"type":    "web",
+// connectDevice upgrades a request authenticated with a device key
+func (h *WebSocketHandlers) connectDevice(c *gin.Context, key string) {
+	device, err := h.DeviceService.Authenticate(key)
+	if errors.Is(err, services.ErrAccountRestricted) {
+		c.JSON(http.StatusForbidden, responses.ErrorResponse{Error: "Account is suspended or banned"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, responses.ErrorResponse{Error: "Invalid or revoked device key"})
+		return
+	}
+
+	conn, err := h.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written an HTTP error response
+		logrus.WithError(err).Warn("WebSocket upgrade failed")
+		return
+	}
+
+	client := wshub.NewDeviceClient(h.Hub, conn, device.UserID, device.ID, device.CanSend())
+	client.Register()
+}
+
+// HandleSendCommand creates a command from the connected user and delivers it
+func (h *WebSocketHandlers) HandleSendCommand(client *wshub.Client, message wshub.IncomingMessage) {
+	if !client.CanSend() {
+		client.Send(wshub.NewErrorMessage(wshub.ErrorCodeInsufficientScope, "This device is only allowed to receive commands"))
+		return
+	}
+
+	var req services.CreateCommandRequest
+	if err := json.Unmarshal(message.Data, &req); err != nil {
+		client.Send(wshub.NewErrorMessage(wshub.ErrorCodeInvalidRequest, "Malformed send_command data"))
+		return
+	}
+
+	command, err := h.CommandService.CreateCommand(client.UserID(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			client.Send(wshub.NewErrorMessage(wshub.ErrorCodeUserNotFound, "Target user '"+req.Receiver+"' does not exist"))
+		case errors.Is(err, services.ErrAccountRestricted):
+			client.Send(wshub.NewErrorMessage(wshub.ErrorCodeAccountRestricted, "Your account is suspended or banned"))
+		case errors.Is(err, services.ErrEmailNotVerified):
+			client.Send(wshub.NewErrorMessage(wshub.ErrorCodeEmailNotVerified, "Verify your email address before sending commands"))
+		case errors.Is(err, services.ErrCommandBlocked):
+			client.Send(wshub.NewErrorMessage(wshub.ErrorCodeCommandBlocked, "User '"+req.Receiver+"' does not accept commands with these tags"))
+		case errors.Is(err, services.ErrNoInstructions), errors.Is(err, services.ErrInvalidInstruction),
+			errors.Is(err, services.ErrUnknownTag), errors.Is(err, services.ErrUnknownFile), errors.Is(err, services.ErrBroadcastWithoutTags):
+			client.Send(wshub.NewErrorMessage(wshub.ErrorCodeInvalidRequest, err.Error()))
+		default:
+			client.Send(wshub.NewErrorMessage(wshub.ErrorCodeCommandFailed, "Failed to create command"))
+		}
+		return
+	}
+
+	h.DeliveryService.Deliver(command)
+
+	client.Send(wshub.Message{
+		Type:      wshub.MessageTypeCommandCreated,
+		ID:        message.ID,
+		Timestamp: time.Now(),
+		Data: CommandStatusData{
+			CommandID: command.ID,
+			Status:    command.Status,
+		},
 	})
 }
+
+// CommandStatusData is the data of "command_status" and "command_created" messages
+type CommandStatusData struct {
+	CommandID uuid.UUID `json:"command_id"`
+	Status    string    `json:"status"`
+}
+
+// HandleCommandStatus applies a status update sent by the receiver of a command
+func (h *WebSocketHandlers) HandleCommandStatus(client *wshub.Client, message wshub.IncomingMessage) {
+	var req CommandStatusData
+	if err := json.Unmarshal(message.Data, &req); err != nil || req.CommandID == uuid.Nil {
+		client.Send(wshub.NewErrorMessage(wshub.ErrorCodeInvalidRequest, "command_id and status are required"))
+		return
+	}
+
+	// "received" is the protocol's name for confirming delivery
+	status := req.Status
+	if status == "received" {
+		status = models.CommandStatusDelivered
+	}
+
+	err := h.CommandService.UpdateCommandStatus(req.CommandID, client.UserID(), status)
+	var transitionErr *services.InvalidTransitionError
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrInvalidCommandStatus):
+		client.Send(wshub.NewErrorMessage(wshub.ErrorCodeInvalidRequest, "Unsupported status: "+req.Status))
+	case errors.Is(err, services.ErrCommandNotFound):
+		client.Send(wshub.NewErrorMessage(wshub.ErrorCodeCommandNotFound, "Command "+req.CommandID.String()+" not found"))
+	case errors.As(err, &transitionErr):
+		client.Send(wshub.NewErrorMessage(wshub.ErrorCodeInvalidTransition, transitionErr.Error()))
+	default:
+		client.Send(wshub.NewErrorMessage(wshub.ErrorCodeCommandFailed, "Failed to update command "+req.CommandID.String()))
+	}
+}
+
+// bearerToken extracts the token from the Authorization header or the token query parameter
+func bearerToken(c *gin.Context) string {
+	if token, ok := middleware.BearerToken(c); ok {
+		return token
+	}
+	return c.Query("token")
+}
@@ -1,12 +1,54 @@
 package responses
 
-import "github.com/thecontrolapp/controlme-go/internal/models"
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+)
 
 // AuthResponse represents the response for authentication endpoints
 type AuthResponse struct {
-	Message string      `json:"message" example:"Login successful"`
-	User    models.User `json:"user"`
-	Token   string      `json:"token,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	Message      string      `json:"message" example:"Login successful"`
+	User         models.User `json:"user"`
+	Token        string      `json:"token,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string      `json:"refresh_token,omitempty" example:"q3J8m0c2Zk9yT1lQd0FhV0xVbXh6N2R0YkF4Rk5xS1E"`
+	ExpiresIn    int         `json:"expires_in,omitempty" example:"900"` // Access token lifetime in seconds
+	SessionID    uuid.UUID   `json:"session_id,omitzero"`
+}
+
+// TokenResponse represents a refreshed access token and its rotated refresh token
+type TokenResponse struct {
+	Token        string    `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string    `json:"refresh_token" example:"q3J8m0c2Zk9yT1lQd0FhV0xVbXh6N2R0YkF4Rk5xS1E"`
+	ExpiresIn    int       `json:"expires_in" example:"900"` // Access token lifetime in seconds
+	SessionID    uuid.UUID `json:"session_id"`
+}
+
+// TwoFactorChallengeResponse represents a login that needs a two-factor code to complete
+type TwoFactorChallengeResponse struct {
+	Message           string `json:"message" example:"Two-factor code required"`
+	TwoFactorRequired bool   `json:"two_factor_required" example:"true"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in" example:"300"` // Seconds until the challenge token expires
+}
+
+// TwoFactorSetupResponse represents a new TOTP secret to add to an authenticator app
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	ProvisioningURI string `json:"provisioning_uri" example:"otpauth://totp/ControlMe:alice?algorithm=SHA1&digits=6&issuer=ControlMe&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
+
+// RecoveryCodesResponse represents newly issued recovery codes, which are only returned once
+type RecoveryCodesResponse struct {
+	Message       string   `json:"message" example:"Two-factor authentication enabled"`
+	RecoveryCodes []string `json:"recovery_codes" example:"k7m2p-x9q4r"`
+}
+
+// TwoFactorStatusResponse represents whether two-factor authentication is enabled
+type TwoFactorStatusResponse struct {
+	Enabled                bool  `json:"enabled"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining" example:"10"`
 }
 
 // UserResponse represents a single user response
@@ -19,11 +61,128 @@ type UsersResponse struct {
 	Users []models.User `json:"users"`
 }
 
+// ModeratedUser is a user as moderators see it, with its moderation state
+type ModeratedUser struct {
+	models.User
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	BannedAt       *time.Time `json:"banned_at,omitempty"`
+	ReviewHoldAt   *time.Time `json:"review_hold_at,omitempty"`
+}
+
+// NewModeratedUser returns the moderator view of a user
+func NewModeratedUser(user models.User) ModeratedUser {
+	return ModeratedUser{
+		User:           user,
+		SuspendedUntil: user.SuspendedUntil,
+		BannedAt:       user.BannedAt,
+		ReviewHoldAt:   user.ReviewHoldAt,
+	}
+}
+
+// ModeratedUserResponse represents a single user response for moderators
+type ModeratedUserResponse struct {
+	User ModeratedUser `json:"user"`
+}
+
+// ModeratedUsersResponse represents a list of users response for moderators
+type ModeratedUsersResponse struct {
+	Users []ModeratedUser `json:"users"`
+}
+
+// CommandResponse represents a single command response
+type CommandResponse struct {
+	Command models.Command `json:"command"`
+}
+
 // CommandsResponse represents a list of commands response
 type CommandsResponse struct {
 	Commands []models.Command `json:"commands"`
 }
 
+// TagsResponse represents a list of tags response
+type TagsResponse struct {
+	Tags []models.Tag `json:"tags"`
+}
+
+// TagSubscriptionResponse represents a single tag subscription response
+type TagSubscriptionResponse struct {
+	Subscription models.TagSubscription `json:"subscription"`
+}
+
+// TagSubscriptionsResponse represents a list of tag subscriptions response
+type TagSubscriptionsResponse struct {
+	Subscriptions []models.TagSubscription `json:"subscriptions"`
+}
+
+// TagPreferenceResponse represents a single tag preference response
+type TagPreferenceResponse struct {
+	Preference models.UserTagPreference `json:"preference"`
+}
+
+// TagPreferencesResponse represents a list of tag preferences response
+type TagPreferencesResponse struct {
+	Preferences []models.UserTagPreference `json:"preferences"`
+}
+
+// BlockResponse represents a single block response
+type BlockResponse struct {
+	Block models.Block `json:"block"`
+}
+
+// BlocksResponse represents a list of blocks response
+type BlocksResponse struct {
+	Blocks []models.Block `json:"blocks"`
+}
+
+// ReportResponse represents a single report response
+type ReportResponse struct {
+	Report models.Report `json:"report"`
+}
+
+// ReportsResponse represents one page of reports
+type ReportsResponse struct {
+	Reports  []models.Report `json:"reports"`
+	Total    int64           `json:"total"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"page_size"`
+}
+
+// SessionsResponse represents a list of sessions response
+type SessionsResponse struct {
+	Sessions []models.Session `json:"sessions"`
+}
+
+// PairingResponse represents the codes of a device pairing that was started
+type PairingResponse struct {
+	DeviceCode string `json:"device_code"`
+	UserCode   string `json:"user_code" example:"BCDF-GHJK"`
+	ExpiresIn  int    `json:"expires_in" example:"600"` // Seconds until the codes expire
+	Interval   int    `json:"interval" example:"5"`     // Seconds to wait between polls
+}
+
+// DeviceKeyResponse represents a newly paired device and its key, which is only returned once
+type DeviceKeyResponse struct {
+	Device models.DeviceCredential `json:"device"`
+	Key    string                  `json:"device_key" example:"cmdk_q3J8m0c2Zk9yT1lQd0FhV0xVbXh6N2R0YkF4Rk5xS1E"`
+}
+
+// DevicesResponse represents a list of devices response
+type DevicesResponse struct {
+	Devices []models.DeviceCredential `json:"devices"`
+}
+
+// FileResponse represents an uploaded file and where to download it
+type FileResponse struct {
+	File        models.FileMetadata `json:"file"`
+	FileName    string              `json:"file_name" example:"clip.mp4"`
+	DownloadURL string              `json:"download_url" example:"/api/v1/files/download/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08/clip.mp4"`
+}
+
+// BlocklistResponse represents a reloaded upload blocklist
+type BlocklistResponse struct {
+	Entries int `json:"entries" example:"1200"`
+}
+
 // MessageResponse represents a simple message response
 type MessageResponse struct {
 	Message string `json:"message" example:"Operation completed successfully"`
@@ -34,6 +193,34 @@ type ErrorResponse struct {
 	Error string `json:"error" example:"Invalid request"`
 }
 
+// ErrorCodeRateLimitExceeded is the error of a RateLimitResponse
+const ErrorCodeRateLimitExceeded = "rate_limit_exceeded"
+
+// ErrorCodeCommandBlocked is the error of a CodedErrorResponse for a command
+// the receiver's tag preferences block
+const ErrorCodeCommandBlocked = "command_blocked"
+
+// CodedErrorResponse represents an error clients tell apart by its code, see docs/api/errors.md
+type CodedErrorResponse struct {
+	Error   string `json:"error" example:"command_blocked"`
+	Message string `json:"message" example:"Receiver does not accept commands with these tags"`
+}
+
+// RateLimitResponse represents a request refused until retry_after seconds have passed
+type RateLimitResponse struct {
+	Error      string `json:"error" example:"rate_limit_exceeded"`
+	Message    string `json:"message" example:"Too many failed logins, try again later"`
+	RetryAfter int    `json:"retry_after" example:"30"` // Seconds until the request may be retried
+}
+
+// AuditLogsResponse represents one page of audit log entries
+type AuditLogsResponse struct {
+	Entries  []models.AuditLog `json:"entries"`
+	Total    int64             `json:"total"`
+	Page     int               `json:"page"`
+	PageSize int               `json:"page_size"`
+}
+
 // HealthResponse represents the health check response
 type HealthResponse struct {
 	Status  string `json:"status" example:"ok"`
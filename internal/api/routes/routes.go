@@ -1,33 +1,152 @@
 package routes
 
 import (
+	"context"
+	"expvar"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/thecontrolapp/controlme-go/internal/api/handlers"
 	"github.com/thecontrolapp/controlme-go/internal/api/responses"
 	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/blocklist"
 	"github.com/thecontrolapp/controlme-go/internal/config"
+	"github.com/thecontrolapp/controlme-go/internal/mailer"
+	"github.com/thecontrolapp/controlme-go/internal/middleware"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/scanner"
 	"github.com/thecontrolapp/controlme-go/internal/services"
+	"github.com/thecontrolapp/controlme-go/internal/storage"
 	"github.com/thecontrolapp/controlme-go/internal/websocket"
 	"gorm.io/gorm"
 )
 
-// SetupRoutes configures all the routes for the application
-func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *websocket.Hub, cfg *config.Config) {
+// Job is a periodic background task of the services behind the routes. It
+// runs until its context is cancelled.
+type Job func(ctx context.Context)
+
+// SetupRoutes configures all the routes for the application and returns the
+// background jobs of their services, which the caller runs. It fails if
+// the CORS or password settings are invalid, the file store cannot be
+// created or the blocklist cannot be read.
+func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *websocket.Hub, mail mailer.Mailer, cfg *config.Config) ([]Job, error) {
+	var jobs []Job
+
+	// CORS must come before the routes, so it also answers preflights for them
+	if cfg.CORS.Enabled {
+		corsMiddleware, err := middleware.CORS(cfg.CORS)
+		if err != nil {
+			return nil, err
+		}
+		router.Use(corsMiddleware)
+	}
+
 	// Initialize services
-	jwtExpiration := time.Duration(cfg.Auth.JWTExpiration) * time.Hour
-	authService := auth.NewAuthService(cfg.Auth.JWTSecret, jwtExpiration)
+	passwordManager, err := auth.NewPasswordManager(auth.HashParams{
+		Algorithm:         cfg.Password.Algorithm,
+		Argon2Memory:      cfg.Password.Argon2Memory,
+		Argon2Iterations:  cfg.Password.Argon2Iterations,
+		Argon2Parallelism: cfg.Password.Argon2Parallelism,
+		BcryptCost:        cfg.Password.BcryptCost,
+	})
+	if err != nil {
+		return nil, err
+	}
+	passwordPolicy, err := auth.NewPasswordPolicy(cfg.Password.MinLength, cfg.Password.MaxLength, cfg.Password.CommonPasswordsFile)
+	if err != nil {
+		return nil, err
+	}
+	fileStore, err := storage.NewStore(cfg.Storage.Directory)
+	if err != nil {
+		return nil, err
+	}
+	jwtExpiration := time.Duration(cfg.Auth.JWTExpiration) * time.Second
+	authService := auth.NewAuthService(cfg.Auth.JWTSecret, jwtExpiration, passwordManager, passwordPolicy)
 	userService := services.NewUserService(db, authService)
-	commandService := services.NewCommandService(db)
+	commandService := services.NewCommandService(db, cfg.Auth.RequireVerifiedEmail)
+	if cfg.Retention.CommandExpiry > 0 && cfg.Retention.Interval > 0 {
+		jobs = append(jobs, func(ctx context.Context) {
+			commandService.ExpireStaleCommands(ctx, cfg.Retention.CommandExpiry, cfg.Retention.Interval)
+		})
+	}
+	deliveryService := services.NewDeliveryService(commandService, hub)
+	tagService := services.NewTagService(db)
+	blockService := services.NewBlockService(db, commandService)
+	refreshExpiration := time.Duration(cfg.Auth.JWTRefreshExpiration) * time.Second
+	sessionService := services.NewSessionService(db, authService.JWTManager, refreshExpiration, hub)
+	deviceService := services.NewDeviceService(db, hub)
+	verificationService := services.NewVerificationService(db, mail, cfg.Mail.VerificationURL)
+	auditService := services.NewAuditService(db)
+	loginGuard := services.NewLoginGuard(db, auditService)
+	passwordService := services.NewPasswordService(db, authService, mail, sessionService, deviceService, cfg.Mail.PasswordResetURL)
+	twoFactorService := services.NewTwoFactorService(db, auditService, cfg.Auth.TOTPIssuer)
+	reportService := services.NewReportService(db, hub, auditService)
+	var uploadBlocklist *blocklist.List
+	if cfg.Scanning.BlocklistFile != "" {
+		uploadBlocklist, err = blocklist.Load(cfg.Scanning.BlocklistFile, cfg.Scanning.BlocklistMaxDistance)
+		if err != nil {
+			return nil, err
+		}
+		go uploadBlocklist.ReloadOn(syscall.SIGHUP)
+	}
+	scanners := scanner.FromConfig(cfg.Scanning)
+	fileService := services.NewFileService(db, fileStore, cfg.Storage.MaxUploadSize, scanners, uploadBlocklist, auditService, hub)
+	if len(scanners) > 0 && cfg.Scanning.RetryInterval > 0 {
+		jobs = append(jobs, func(ctx context.Context) {
+			fileService.RetryPendingScans(ctx, cfg.Scanning.RetryInterval)
+		})
+	}
+	uploadService := services.NewUploadService(db, fileStore, fileService, cfg.Storage.UploadExpiration, cfg.Storage.MaxOpenUploads)
+	if cfg.Storage.UploadCleanupInterval > 0 {
+		jobs = append(jobs, func(ctx context.Context) {
+			uploadService.ExpireUploads(ctx, cfg.Storage.UploadCleanupInterval)
+		})
+	}
+	if cfg.Retention.Enabled && cfg.Retention.Window > 0 && cfg.Retention.Interval > 0 {
+		retentionService := services.NewRetentionService(db, fileService, cfg.Retention.Window, cfg.Retention.TagWindows, cfg.Retention.DryRun)
+		jobs = append(jobs, func(ctx context.Context) {
+			retentionService.Run(ctx, cfg.Retention.Interval)
+		})
+	}
 
 	// Initialize handlers
-	userHandlers := handlers.NewUserHandlers(userService)
-	authHandlers := handlers.NewAuthHandlers(userService)
-	commandHandlers := handlers.NewCommandHandlers(commandService)
-	wsHandlers := handlers.NewWebSocketHandlers(hub)
+	userHandlers := handlers.NewUserHandlers(userService, loginGuard)
+	authHandlers := handlers.NewAuthHandlers(userService, sessionService, verificationService, passwordService, loginGuard, twoFactorService)
+	commandHandlers := handlers.NewCommandHandlers(commandService, deliveryService)
+	instructionHandlers := handlers.NewInstructionHandlers()
+	tagHandlers := handlers.NewTagHandlers(tagService)
+	blockHandlers := handlers.NewBlockHandlers(blockService)
+	reportHandlers := handlers.NewReportHandlers(reportService)
+	deviceHandlers := handlers.NewDeviceHandlers(deviceService)
+	auditHandlers := handlers.NewAuditHandlers(auditService)
+	twoFactorHandlers := handlers.NewTwoFactorHandlers(twoFactorService, loginGuard)
+	fileHandlers := handlers.NewFileHandlers(fileService)
+	uploadHandlers := handlers.NewUploadHandlers(uploadService)
+	// Without CORS, browsers may only open WebSockets from the API's own host
+	var wsOrigins []string
+	if cfg.CORS.Enabled {
+		wsOrigins = cfg.CORS.AllowedOrigins
+	}
+	wsHandlers := handlers.NewWebSocketHandlers(hub, authService.JWTManager, sessionService, deviceService, commandService, deliveryService, wsOrigins)
+	wsHandlers.RegisterMessageHandlers()
+
+	// requirePermission guards a route with a permission of the caller's role
+	requirePermission := func(permission string) gin.HandlerFunc {
+		return middleware.RequirePermission(userService.GetUserRole, permission)
+	}
+
+	// deviceKeyOwner lets desktop clients use their device key on the routes they share with users
+	deviceKeyOwner := func(key string) (uuid.UUID, bool) {
+		credential, err := deviceService.Authenticate(key)
+		if err != nil {
+			return uuid.Nil, false
+		}
+		return credential.UserID, true
+	}
 
 	// Health check endpoint
 	// Health godoc
@@ -45,6 +164,11 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *websocket.Hub, cfg *confi
 		})
 	})
 
+	// Metrics, such as the bytes reclaimed by file retention, in expvar's JSON format
+	if cfg.Monitoring.Enabled {
+		router.GET(cfg.Monitoring.MetricsPath, gin.WrapH(expvar.Handler()))
+	}
+
 	// Add Swagger route
 	// The URL points to the auto-generated swagger.json file.
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler,
@@ -58,23 +182,135 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *websocket.Hub, cfg *confi
 		auth := v1.Group("/auth")
 		{
 			auth.POST("/login", authHandlers.Login)
+			auth.POST("/login/2fa", authHandlers.LoginTwoFactor)
 			auth.POST("/register", authHandlers.Register)
+			auth.POST("/refresh", authHandlers.Refresh)
+			auth.POST("/password/forgot", authHandlers.ForgotPassword)
+			auth.POST("/password/reset", authHandlers.ResetPassword)
+		}
+
+		// Device pairing routes polled by desktop clients before they have a key
+		pairing := v1.Group("/devices/pair")
+		{
+			pairing.POST("", deviceHandlers.StartPairing)
+			pairing.POST("/token", deviceHandlers.ClaimPairing)
+		}
+
+		// Instruction routes
+		v1.GET("/instructions/schema", instructionHandlers.GetInstructionSchema)
+
+		// File downloads, which desktop clients make with their device key
+		v1.GET("/files/download/:hash/:filename", middleware.JWTOrDeviceAuth(authService, sessionService.IsActive, deviceKeyOwner), fileHandlers.Download)
+
+		// tus discovery, which clients make before authenticating
+		v1.OPTIONS("/files/uploads", uploadHandlers.Options)
+
+		// Everything below requires a valid JWT; handlers read the caller from the context
+		protected := v1.Group("", middleware.JWTAuth(authService, sessionService.IsActive))
+
+		// Routes of the signed-in account
+		account := protected.Group("/auth")
+		{
+			account.POST("/logout", authHandlers.Logout)
+			account.GET("/sessions", authHandlers.GetSessions)
+			account.DELETE("/sessions/:id", authHandlers.RevokeSession)
+			account.POST("/verify-email", authHandlers.VerifyEmail)
+			account.POST("/verify-email/resend", authHandlers.ResendVerification)
+			account.POST("/password/change", authHandlers.ChangePassword)
+			account.GET("/2fa", twoFactorHandlers.GetStatus)
+			account.POST("/2fa/setup", twoFactorHandlers.Setup)
+			account.POST("/2fa/enable", twoFactorHandlers.Enable)
+			account.POST("/2fa/disable", twoFactorHandlers.Disable)
+			account.POST("/2fa/recovery-codes", twoFactorHandlers.RegenerateRecoveryCodes)
+		}
+
+		// Device routes
+		devices := protected.Group("/devices")
+		{
+			devices.GET("", deviceHandlers.GetDevices)
+			devices.POST("/pair/approve", deviceHandlers.ApprovePairing)
+			devices.POST("/pair/deny", deviceHandlers.DenyPairing)
+			devices.DELETE("/:id", deviceHandlers.RevokeDevice)
 		}
 
 		// Command routes
-		commands := v1.Group("/commands")
+		commands := protected.Group("/commands")
 		{
+			commands.POST("", commandHandlers.CreateCommand)
 			commands.GET("/pending", commandHandlers.GetPendingCommands)
 			commands.POST("/complete", commandHandlers.CompleteCommand)
+			commands.GET("/approvals", commandHandlers.GetAwaitingApproval)
+			commands.POST("/:id/approve", commandHandlers.ApproveCommand)
+			commands.POST("/:id/decline", commandHandlers.DeclineCommand)
+			commands.POST("/:id/cancel", commandHandlers.CancelCommand)
+		}
+
+		// Tag routes
+		tags := protected.Group("/tags")
+		{
+			tags.GET("", tagHandlers.GetTags)
+			tags.GET("/subscriptions", tagHandlers.GetSubscriptions)
+			tags.POST("/:name/subscription", tagHandlers.Subscribe)
+			tags.DELETE("/:name/subscription", tagHandlers.Unsubscribe)
+			tags.GET("/preferences", tagHandlers.GetPreferences)
+			tags.PUT("/:name/preference", tagHandlers.SetPreference)
+			tags.DELETE("/:name/preference", tagHandlers.ClearPreference)
+		}
+
+		// Block routes
+		blocks := protected.Group("/blocks")
+		{
+			blocks.GET("", blockHandlers.GetBlocks)
+			blocks.POST("", blockHandlers.BlockUser)
+			blocks.DELETE("/:user_id", blockHandlers.UnblockUser)
+		}
+
+		// File routes
+		protected.POST("/files/upload", fileHandlers.Upload)
+
+		// Resumable upload routes (tus)
+		uploads := protected.Group("/files/uploads")
+		{
+			uploads.POST("", uploadHandlers.Create)
+			uploads.HEAD("/:id", uploadHandlers.Head)
+			uploads.PATCH("/:id", uploadHandlers.Patch)
+		}
+
+		// Report routes
+		protected.POST("/reports", reportHandlers.CreateReport)
+
+		// Admin routes
+		admin := protected.Group("/admin")
+		{
+			adminReports := admin.Group("/reports", requirePermission(models.PermissionReportsReview))
+			{
+				adminReports.GET("", reportHandlers.ListReports)
+				adminReports.GET("/:id", reportHandlers.GetReport)
+				adminReports.POST("/:id/resolve", requirePermission(models.PermissionReportsResolve), reportHandlers.ResolveReport)
+			}
+
+			adminUsers := admin.Group("/users")
+			{
+				adminUsers.PUT("/:id/role", requirePermission(models.PermissionUsersManageRoles), userHandlers.SetUserRole)
+				adminUsers.POST("/:id/unlock", requirePermission(models.PermissionUsersUnlock), userHandlers.UnlockUser)
+				adminUsers.POST("/:id/release-hold", requirePermission(models.PermissionReportsResolve), reportHandlers.ReleaseReviewHold)
+			}
+
+			admin.GET("/audit-logs", requirePermission(models.PermissionAuditRead), auditHandlers.ListAuditLogs)
+			admin.POST("/blocklist/reload", requirePermission(models.PermissionBlocklistManage), fileHandlers.ReloadBlocklist)
 		}
 
 		// User routes
-		v1.GET("/users", userHandlers.GetUsers)
-		v1.GET("/users/:id", userHandlers.GetUserByID)
-		v1.POST("/users", userHandlers.CreateUser)
+		users := protected.Group("/users")
+		{
+			users.GET("", requirePermission(models.PermissionUsersRead), userHandlers.GetUsers)
+			users.GET("/:id", requirePermission(models.PermissionUsersRead), userHandlers.GetUserByID)
+			users.POST("", requirePermission(models.PermissionUsersCreate), userHandlers.CreateUser)
+		}
 	}
 
-	// WebSocket routes
-	router.GET("/ws/client", wsHandlers.HandleClientWebSocket)
-	router.GET("/ws/web", wsHandlers.HandleWebWebSocket)
+	// WebSocket route, which authenticates the upgrade request itself
+	router.GET("/api/ws", wsHandlers.HandleWebSocket)
+
+	return jobs, nil
 }
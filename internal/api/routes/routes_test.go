@@ -0,0 +1,326 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/thecontrolapp/controlme-go/internal/api/responses"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/config"
+	"github.com/thecontrolapp/controlme-go/internal/mailer"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/services"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+	"github.com/thecontrolapp/controlme-go/internal/websocket"
+)
+
+const testJWTSecret = "test-secret"
+
+// testConfig returns settings that keep password hashing cheap
+func testConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		Auth: config.Auth{JWTSecret: testJWTSecret, JWTExpiration: 900, JWTRefreshExpiration: 3600},
+		Password: config.Password{
+			MinLength:         10,
+			MaxLength:         128,
+			Algorithm:         "argon2id",
+			Argon2Memory:      1024,
+			Argon2Iterations:  1,
+			Argon2Parallelism: 1,
+		},
+		Storage: config.Storage{Directory: t.TempDir(), MaxUploadSize: 1 << 20},
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t)
+	hub := websocket.NewHub(config.WebSocket{})
+	router := gin.New()
+	if _, err := SetupRoutes(router, db, hub, mailer.NewMemoryMailer(), testConfig(t)); err != nil {
+		t.Fatalf("SetupRoutes: %v", err)
+	}
+
+	sessions := services.NewSessionService(db, auth.NewJWTManager(testJWTSecret, 15*time.Minute), time.Hour, hub)
+	tokens := make(map[string]string)
+	for _, role := range []string{models.RoleUser, models.RoleModerator, models.RoleAdmin} {
+		user := models.User{
+			ScreenName: role,
+			LoginName:  role,
+			Email:      role + "@example.com",
+			Password:   "unused",
+			Role:       role,
+		}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("failed to create %s: %v", role, err)
+		}
+		pair, err := sessions.CreateSession(user.ID, services.SessionInfo{ClientType: websocket.ClientTypeWeb})
+		if err != nil {
+			t.Fatalf("failed to create session of %s: %v", role, err)
+		}
+		tokens[role] = pair.AccessToken
+	}
+
+	target := uuid.NewString()
+	routes := []struct {
+		method, path string
+		allowed      []string // Roles that get past RequirePermission
+	}{
+		{"GET", "/api/v1/admin/reports", []string{models.RoleModerator, models.RoleAdmin}},
+		{"GET", "/api/v1/admin/reports/" + target, []string{models.RoleModerator, models.RoleAdmin}},
+		{"POST", "/api/v1/admin/reports/" + target + "/resolve", []string{models.RoleModerator, models.RoleAdmin}},
+		{"PUT", "/api/v1/admin/users/" + target + "/role", []string{models.RoleAdmin}},
+		{"POST", "/api/v1/admin/users/" + target + "/unlock", []string{models.RoleModerator, models.RoleAdmin}},
+		{"POST", "/api/v1/admin/users/" + target + "/release-hold", []string{models.RoleModerator, models.RoleAdmin}},
+		{"GET", "/api/v1/admin/audit-logs", []string{models.RoleModerator, models.RoleAdmin}},
+		{"POST", "/api/v1/admin/blocklist/reload", []string{models.RoleAdmin}},
+		{"POST", "/api/v1/users", []string{models.RoleAdmin}},
+		{"GET", "/api/v1/users", []string{models.RoleUser, models.RoleModerator, models.RoleAdmin}},
+	}
+
+	for _, route := range routes {
+		for role, token := range tokens {
+			t.Run(role+" "+route.method+" "+route.path, func(t *testing.T) {
+				req := httptest.NewRequest(route.method, route.path, strings.NewReader("{}"))
+				req.Header.Set("Authorization", "Bearer "+token)
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				allowed := false
+				for _, r := range route.allowed {
+					allowed = allowed || r == role
+				}
+				switch {
+				case w.Code == http.StatusUnauthorized:
+					t.Fatalf("got 401, the token was not accepted: %s", w.Body)
+				case allowed && w.Code == http.StatusForbidden:
+					t.Errorf("got 403 for an allowed role: %s", w.Body)
+				case !allowed && w.Code != http.StatusForbidden:
+					t.Errorf("got %d, want 403", w.Code)
+				}
+			})
+		}
+	}
+
+	t.Run("without a token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/admin/audit-logs", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("got %d, want 401", w.Code)
+		}
+	})
+}
+
+func TestCORSPreflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := testConfig(t)
+	cfg.CORS = config.CORS{
+		Enabled:          true,
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PATCH", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+	router := gin.New()
+	if _, err := SetupRoutes(router, testdb.Open(t), websocket.NewHub(config.WebSocket{}), mailer.NewMemoryMailer(), cfg); err != nil {
+		t.Fatalf("SetupRoutes: %v", err)
+	}
+
+	preflight := func(origin, method, path, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		method, path string
+		headers      string
+	}{
+		{"POST", "/api/v1/files/uploads", "Authorization, Tus-Resumable, Upload-Length, Upload-Metadata"},
+		{"PATCH", "/api/v1/files/uploads/" + uuid.NewString(), "Authorization, Content-Type, Tus-Resumable, Upload-Offset"},
+		{"HEAD", "/api/v1/files/uploads/" + uuid.NewString(), "Authorization, Tus-Resumable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := preflight("https://app.example.com", tt.method, tt.path, tt.headers)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("got %d, want 204: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+				t.Errorf("Access-Control-Allow-Origin = %q", got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, tt.method) {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %s", got, tt.method)
+			}
+			allowed := w.Header().Get("Access-Control-Allow-Headers")
+			for _, header := range strings.Split(tt.headers, ", ") {
+				if !strings.Contains(allowed, header) {
+					t.Errorf("Access-Control-Allow-Headers = %q, want %s", allowed, header)
+				}
+			}
+			if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+			}
+		})
+	}
+
+	t.Run("exposed headers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/health", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		exposed := w.Header().Get("Access-Control-Expose-Headers")
+		for _, header := range []string{"Location", "Upload-Offset", "Upload-Length", "Upload-Expires", "Tus-Resumable"} {
+			if !strings.Contains(exposed, header) {
+				t.Errorf("Access-Control-Expose-Headers = %q, want %s", exposed, header)
+			}
+		}
+	})
+
+	t.Run("other origin", func(t *testing.T) {
+		w := preflight("https://evil.example.com", "POST", "/api/v1/files/uploads", "Tus-Resumable")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("got %d, want 403", w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+		}
+	})
+}
+
+func TestCreateCommandBlocked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testdb.Open(t)
+	hub := websocket.NewHub(config.WebSocket{})
+	router := gin.New()
+	if _, err := SetupRoutes(router, db, hub, mailer.NewMemoryMailer(), testConfig(t)); err != nil {
+		t.Fatalf("SetupRoutes: %v", err)
+	}
+
+	users := make(map[string]models.User)
+	for _, name := range []string{"sender", "receiver"} {
+		user := models.User{ScreenName: name, LoginName: name, Email: name + "@example.com", Password: "unused", Role: models.RoleUser}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		users[name] = user
+	}
+	tag := models.Tag{Name: "general"}
+	if err := db.Create(&tag).Error; err != nil {
+		t.Fatalf("failed to create tag: %v", err)
+	}
+	preference := models.UserTagPreference{UserID: users["receiver"].ID, TagID: tag.ID, Preference: models.TagPreferenceBlock}
+	if err := db.Create(&preference).Error; err != nil {
+		t.Fatalf("failed to create preference: %v", err)
+	}
+
+	sessions := services.NewSessionService(db, auth.NewJWTManager(testJWTSecret, 15*time.Minute), time.Hour, hub)
+	pair, err := sessions.CreateSession(users["sender"].ID, services.SessionInfo{ClientType: websocket.ClientTypeWeb})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	body := `{"instructions": [{"type": "popup-msg", "content": {"body": "hello"}}], "receiver": "receiver", "tags": ["general"]}`
+	req := httptest.NewRequest("POST", "/api/v1/commands", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403: %s", w.Code, w.Body)
+	}
+	var response responses.CodedErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Error != responses.ErrorCodeCommandBlocked || response.Message == "" {
+		t.Errorf("response = %+v, want error %s with a message", response, responses.ErrorCodeCommandBlocked)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// dial opens a WebSocket as a new user from the origin and returns the response status
+	dial := func(t *testing.T, cors bool, origin string) int {
+		db := testdb.Open(t)
+		hub := websocket.NewHub(config.WebSocket{})
+		go hub.Run()
+		cfg := testConfig(t)
+		cfg.CORS = config.CORS{
+			Enabled:        cors,
+			AllowedOrigins: []string{"https://app.example.com"},
+			AllowedMethods: []string{"GET", "POST"},
+		}
+		router := gin.New()
+		if _, err := SetupRoutes(router, db, hub, mailer.NewMemoryMailer(), cfg); err != nil {
+			t.Fatalf("SetupRoutes: %v", err)
+		}
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		user := models.User{ScreenName: "alice", LoginName: "alice", Email: "alice@example.com", Password: "unused", Role: models.RoleUser}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		sessions := services.NewSessionService(db, auth.NewJWTManager(testJWTSecret, 15*time.Minute), time.Hour, hub)
+		pair, err := sessions.CreateSession(user.ID, services.SessionInfo{ClientType: websocket.ClientTypeWeb})
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+
+		header := http.Header{"Authorization": {"Bearer " + pair.AccessToken}}
+		if origin == "same host" {
+			origin = server.URL
+		}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/ws", header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name   string
+		cors   bool
+		origin string
+		want   int
+	}{
+		{"no origin", true, "", http.StatusSwitchingProtocols},
+		{"allowed origin", true, "https://app.example.com", http.StatusSwitchingProtocols},
+		{"same host", true, "same host", http.StatusSwitchingProtocols},
+		{"other origin", true, "https://evil.example.com", http.StatusForbidden},
+		{"allowed host on another scheme", true, "http://app.example.com", http.StatusForbidden},
+		{"no origin without CORS", false, "", http.StatusSwitchingProtocols},
+		{"same host without CORS", false, "same host", http.StatusSwitchingProtocols},
+		{"listed origin without CORS", false, "https://app.example.com", http.StatusForbidden},
+		{"other origin without CORS", false, "https://evil.example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dial(t, tt.cors, tt.origin); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
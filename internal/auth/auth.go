@@ -1,12 +1,15 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // JWTManager handles JWT token operations
@@ -23,10 +26,16 @@ func NewJWTManager(secretKey string, duration time.Duration) *JWTManager {
 	}
 }
 
-// GenerateToken generates a new JWT token for a user
-func (jm *JWTManager) GenerateToken(userID uuid.UUID) (string, error) {
+// Duration returns how long generated tokens are valid
+func (jm *JWTManager) Duration() time.Duration {
+	return jm.duration
+}
+
+// GenerateToken generates a new access token for a user's session
+func (jm *JWTManager) GenerateToken(userID, sessionID uuid.UUID) (string, error) {
 	claims := Claims{
-		UserID: userID.String(),
+		UserID:    userID.String(),
+		SessionID: sessionID.String(),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(jm.duration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -57,61 +66,62 @@ func (jm *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 	return nil, errors.New("invalid token")
 }
 
-// PasswordManager handles password hashing and verification
-type PasswordManager struct{}
-
-// NewPasswordManager creates a new password manager
-func NewPasswordManager() *PasswordManager {
-	return &PasswordManager{}
-}
-
-// HashPassword creates a bcrypt hash from a password
-func (pm *PasswordManager) HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hash), nil
-}
-
-// VerifyPassword verifies a password against a hash
-func (pm *PasswordManager) VerifyPassword(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-}
-
 // AuthService combines all authentication functionality
 type AuthService struct {
 	JWTManager      *JWTManager
 	PasswordManager *PasswordManager
+	PasswordPolicy  *PasswordPolicy
 }
 
-var jwtSecret string
-
 // NewAuthService creates a new authentication service
-func NewAuthService(secret string, jwtExpiration time.Duration) *AuthService {
-	jwtSecret = secret
+func NewAuthService(secret string, jwtExpiration time.Duration, passwordManager *PasswordManager, passwordPolicy *PasswordPolicy) *AuthService {
 	return &AuthService{
 		JWTManager:      NewJWTManager(secret, jwtExpiration),
-		PasswordManager: NewPasswordManager(),
+		PasswordManager: passwordManager,
+		PasswordPolicy:  passwordPolicy,
 	}
 }
 
 type Claims struct {
-	UserID string `json:"user_id"`
+	UserID    string `json:"user_id"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// ParseJWT parses a JWT token and returns claims
-func ParseJWT(tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
-	})
+// DeviceKeyPrefix starts every device key, so they can be told apart from JWTs
+const DeviceKeyPrefix = "cmdk_"
+
+// NewToken generates a random opaque token, such as a refresh token, and the hash to store for it
+func NewToken() (token, hash string, err error) {
+	token, err = randomToken()
 	if err != nil {
-		return nil, err
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// NewDeviceKey generates a random device key and the hash to store for it
+func NewDeviceKey() (key, hash string, err error) {
+	key, err = randomToken()
+	if err != nil {
+		return "", "", err
 	}
-	claims, ok := token.Claims.(*Claims)
-	if !ok || !token.Valid {
-		return nil, errors.New("invalid token claims")
+	key = DeviceKeyPrefix + key
+	return key, HashToken(key), nil
+}
+
+// HashToken returns the SHA-256 hex digest under which a random token is stored.
+// The tokens carry 256 bits of entropy, so a fast hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns 32 random bytes encoded as URL-safe base64
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
 	}
-	return claims, nil
+	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ErrPasswordMismatch is returned when a password does not match a hash
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// ErrUnknownHashFormat is returned for a stored hash that no supported algorithm produced
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// HashParams selects how new password hashes are made
+type HashParams struct {
+	Algorithm         string // argon2id or bcrypt
+	Argon2Memory      uint32 // KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	BcryptCost        int
+}
+
+// DefaultHashParams returns argon2id with the second recommended option of
+// RFC 9106, scaled down to 64 MiB of memory
+func DefaultHashParams() HashParams {
+	return HashParams{
+		Algorithm:         HashArgon2id,
+		Argon2Memory:      64 * 1024,
+		Argon2Iterations:  3,
+		Argon2Parallelism: 2,
+		BcryptCost:        bcrypt.DefaultCost,
+	}
+}
+
+// PasswordManager handles password hashing and verification. It verifies
+// both bcrypt and argon2id hashes, so stored hashes can be upgraded one login
+// at a time.
+type PasswordManager struct {
+	params HashParams
+}
+
+// NewPasswordManager creates a new password manager that hashes new passwords with params
+func NewPasswordManager(params HashParams) (*PasswordManager, error) {
+	switch params.Algorithm {
+	case HashArgon2id:
+		if params.Argon2Memory == 0 || params.Argon2Iterations == 0 || params.Argon2Parallelism == 0 {
+			return nil, errors.New("argon2id memory, iterations and parallelism must be positive")
+		}
+	case HashBcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", params.Algorithm)
+	}
+	return &PasswordManager{params: params}, nil
+}
+
+// HashPassword hashes a password with the configured algorithm
+func (pm *PasswordManager) HashPassword(password string) (string, error) {
+	if pm.params.Algorithm == HashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), pm.params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, pm.params.Argon2Iterations, pm.params.Argon2Memory, pm.params.Argon2Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		pm.params.Argon2Memory,
+		pm.params.Argon2Iterations,
+		pm.params.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword verifies a password against a bcrypt or argon2id hash
+func (pm *PasswordManager) VerifyPassword(password, hash string) error {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+
+	stored, err := parseArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(password), stored.salt, stored.iterations, stored.memory, stored.parallelism, uint32(len(stored.key)))
+	if subtle.ConstantTimeCompare(key, stored.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether a hash was made with another algorithm or
+// other parameters than new hashes are, so it should be replaced the next
+// time the password is known
+func (pm *PasswordManager) NeedsRehash(hash string) bool {
+	if pm.params.Algorithm == HashBcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != pm.params.BcryptCost
+	}
+
+	stored, err := parseArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return stored.memory != pm.params.Argon2Memory ||
+		stored.iterations != pm.params.Argon2Iterations ||
+		stored.parallelism != pm.params.Argon2Parallelism ||
+		len(stored.key) != argon2KeyLength
+}
+
+// argon2Hash is a decoded argon2id hash in the PHC string format
+type argon2Hash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// parseArgon2Hash decodes $argon2id$v=19$m=...,t=...,p=...$salt$key
+func parseArgon2Hash(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var h argon2Hash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.parallelism); err != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHashFormat
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, ErrUnknownHashFormat
+	}
+	return &h, nil
+}
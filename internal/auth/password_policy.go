@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:embed common_passwords.txt
+var builtinCommonPasswords []byte
+
+// PasswordPolicyError is returned for a password the policy does not allow.
+// Reason explains why and is meant to be shown to the user.
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password rejected: " + e.Reason
+}
+
+// PasswordPolicy decides which new passwords are acceptable
+type PasswordPolicy struct {
+	minLength int
+	maxLength int
+	common    map[string]struct{}
+}
+
+// NewPasswordPolicy creates a password policy. Passwords on the built-in
+// common password list are refused, as are those in commonPasswordsFile if
+// it is set, one per line.
+func NewPasswordPolicy(minLength, maxLength int, commonPasswordsFile string) (*PasswordPolicy, error) {
+	if minLength < 1 || maxLength < minLength {
+		return nil, fmt.Errorf("invalid password length limits %d to %d", minLength, maxLength)
+	}
+
+	policy := &PasswordPolicy{
+		minLength: minLength,
+		maxLength: maxLength,
+		common:    make(map[string]struct{}),
+	}
+	if err := policy.addCommon(bytes.NewReader(builtinCommonPasswords)); err != nil {
+		return nil, err
+	}
+
+	if commonPasswordsFile != "" {
+		file, err := os.Open(commonPasswordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open common password list: %w", err)
+		}
+		defer file.Close()
+
+		if err := policy.addCommon(file); err != nil {
+			return nil, fmt.Errorf("failed to read common password list: %w", err)
+		}
+	}
+	return policy, nil
+}
+
+// addCommon adds one password per line, skipping blank lines and # comments
+func (pp *PasswordPolicy) addCommon(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pp.common[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Check returns a *PasswordPolicyError if the password is too short or too
+// long, is a common password, or is the user's login name or email address
+func (pp *PasswordPolicy) Check(password, loginName, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < pp.minLength {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("Password must be at least %d characters", pp.minLength)}
+	}
+	if length > pp.maxLength {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("Password must be at most %d characters", pp.maxLength)}
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := pp.common[lower]; ok {
+		return &PasswordPolicyError{Reason: "Password is too common"}
+	}
+	if (loginName != "" && lower == strings.ToLower(loginName)) || (email != "" && lower == strings.ToLower(email)) {
+		return &PasswordPolicyError{Reason: "Password must not be your username or email address"}
+	}
+	return nil
+}
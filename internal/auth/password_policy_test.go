@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "common.txt")
+	if err := os.WriteFile(list, []byte("# Site specific\n\nControlMe2026\n"), 0o600); err != nil {
+		t.Fatalf("failed to write list: %v", err)
+	}
+	policy, err := NewPasswordPolicy(8, 20, list)
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		reason   string // Empty if the password is allowed
+	}{
+		{"acceptable", "violet-anchor-29", ""},
+		{"minimum length", "vi0let!x", ""},
+		{"maximum length", strings.Repeat("v", 20), ""},
+		{"too short", "vi0let!", "at least 8"},
+		{"too long", strings.Repeat("v", 21), "at most 20"},
+		{"length counts characters, not bytes", strings.Repeat("é", 8), ""},
+		{"built-in common password", "qwertyuiop", "too common"},
+		{"common password in another case", "QwertyUiop", "too common"},
+		{"common password from the file", "controlme2026", "too common"},
+		{"login name", "Alice-the-user", "username or email"},
+		{"email address", "alice@example.com", "username or email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, "alice-the-user", "Alice@Example.com")
+			if tt.reason == "" {
+				if err != nil {
+					t.Errorf("Check() error = %v", err)
+				}
+				return
+			}
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Check() error = %v, want a PasswordPolicyError", err)
+			}
+			if !strings.Contains(policyErr.Reason, tt.reason) {
+				t.Errorf("Reason = %q, want it to mention %q", policyErr.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestNewPasswordPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		file     string
+	}{
+		{"no minimum", 0, 64, ""},
+		{"maximum below minimum", 12, 8, ""},
+		{"missing list", 8, 64, filepath.Join(t.TempDir(), "missing.txt")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPasswordPolicy(tt.min, tt.max, tt.file); err == nil {
+				t.Error("NewPasswordPolicy() error = nil")
+			}
+		})
+	}
+}
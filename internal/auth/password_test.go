@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// legacyBcryptHash is "correct horse battery staple" hashed with bcrypt cost
+// 10, as stored before passwords were hashed with argon2id
+const legacyBcryptHash = "$2a$10$cl2FcQup.lJ21rcp6WEcP.WeKu4Ra/bswDMjQnZyEehEpM0zTpHpC"
+
+// testHashParams are cheap argon2id parameters so the tests run quickly
+func testHashParams() HashParams {
+	return HashParams{
+		Algorithm:         HashArgon2id,
+		Argon2Memory:      1024,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+		BcryptCost:        bcrypt.MinCost,
+	}
+}
+
+func newTestPasswordManager(t *testing.T, params HashParams) *PasswordManager {
+	t.Helper()
+	pm, err := NewPasswordManager(params)
+	if err != nil {
+		t.Fatalf("NewPasswordManager() error = %v", err)
+	}
+	return pm
+}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	params := testHashParams()
+	pm := newTestPasswordManager(t, params)
+
+	hash, err := pm.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	prefix := fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$", argon2.Version)
+	if !strings.HasPrefix(hash, prefix) {
+		t.Errorf("HashPassword() = %q, want prefix %q", hash, prefix)
+	}
+
+	parsed, err := parseArgon2Hash(hash)
+	if err != nil {
+		t.Fatalf("parseArgon2Hash() error = %v", err)
+	}
+	if parsed.memory != 1024 || parsed.iterations != 1 || parsed.parallelism != 1 ||
+		len(parsed.salt) != argon2SaltLength || len(parsed.key) != argon2KeyLength {
+		t.Errorf("parseArgon2Hash() = %+v", parsed)
+	}
+
+	if err := pm.VerifyPassword("correct horse battery staple", hash); err != nil {
+		t.Errorf("VerifyPassword() error = %v", err)
+	}
+	if err := pm.VerifyPassword("wrong horse battery staple", hash); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("VerifyPassword() with a wrong password error = %v, want ErrPasswordMismatch", err)
+	}
+
+	again, err := pm.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if again == hash {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+func TestParseArgon2HashRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"other algorithm", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5"},
+		{"other version", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5"},
+		{"missing parameters", "$argon2id$v=19$m=1024$c2FsdHNhbHRzYWx0$a2V5"},
+		{"bad salt", "$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5"},
+		{"empty key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$"},
+		{"too few parts", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0"},
+	}
+	pm := newTestPasswordManager(t, testHashParams())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseArgon2Hash(tt.hash); !errors.Is(err, ErrUnknownHashFormat) {
+				t.Errorf("parseArgon2Hash() error = %v, want ErrUnknownHashFormat", err)
+			}
+			if !pm.NeedsRehash(tt.hash) {
+				t.Error("NeedsRehash() = false for a malformed hash")
+			}
+		})
+	}
+}
+
+func TestLegacyBcryptHash(t *testing.T) {
+	pm := newTestPasswordManager(t, testHashParams())
+
+	if err := pm.VerifyPassword("correct horse battery staple", legacyBcryptHash); err != nil {
+		t.Fatalf("VerifyPassword() with a bcrypt hash error = %v", err)
+	}
+	if err := pm.VerifyPassword("wrong horse battery staple", legacyBcryptHash); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("VerifyPassword() with a wrong password error = %v, want ErrPasswordMismatch", err)
+	}
+	if !pm.NeedsRehash(legacyBcryptHash) {
+		t.Fatal("NeedsRehash() = false for a bcrypt hash under argon2id")
+	}
+
+	// Rehashing on login replaces the bcrypt hash with one that needs no rehash
+	rehashed, err := pm.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if pm.NeedsRehash(rehashed) {
+		t.Error("NeedsRehash() = true for a fresh hash")
+	}
+	if err := pm.VerifyPassword("correct horse battery staple", rehashed); err != nil {
+		t.Errorf("VerifyPassword() with the new hash error = %v", err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weak := newTestPasswordManager(t, testHashParams())
+	weakHash, err := weak.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+
+	stronger := func(change func(*HashParams)) HashParams {
+		params := testHashParams()
+		change(&params)
+		return params
+	}
+	tests := []struct {
+		name   string
+		params HashParams
+		hash   string
+		want   bool
+	}{
+		{"same argon2id parameters", testHashParams(), weakHash, false},
+		{"more memory", stronger(func(p *HashParams) { p.Argon2Memory = 2048 }), weakHash, true},
+		{"more iterations", stronger(func(p *HashParams) { p.Argon2Iterations = 2 }), weakHash, true},
+		{"more parallelism", stronger(func(p *HashParams) { p.Argon2Parallelism = 2 }), weakHash, true},
+		{"bcrypt under argon2id", testHashParams(), legacyBcryptHash, true},
+		{"argon2id under bcrypt", stronger(func(p *HashParams) { p.Algorithm = HashBcrypt; p.BcryptCost = 10 }), weakHash, true},
+		{"same bcrypt cost", stronger(func(p *HashParams) { p.Algorithm = HashBcrypt; p.BcryptCost = 10 }), legacyBcryptHash, false},
+		{"higher bcrypt cost", stronger(func(p *HashParams) { p.Algorithm = HashBcrypt; p.BcryptCost = 12 }), legacyBcryptHash, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := newTestPasswordManager(t, tt.params)
+			if got := pm.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPasswordManagerRejectsInvalidParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*HashParams)
+	}{
+		{"unknown algorithm", func(p *HashParams) { p.Algorithm = "md5" }},
+		{"no memory", func(p *HashParams) { p.Argon2Memory = 0 }},
+		{"no iterations", func(p *HashParams) { p.Argon2Iterations = 0 }},
+		{"no parallelism", func(p *HashParams) { p.Argon2Parallelism = 0 }},
+		{"bcrypt cost too low", func(p *HashParams) { p.Algorithm = HashBcrypt; p.BcryptCost = bcrypt.MinCost - 1 }},
+		{"bcrypt cost too high", func(p *HashParams) { p.Algorithm = HashBcrypt; p.BcryptCost = bcrypt.MaxCost + 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := testHashParams()
+			tt.change(&params)
+			if _, err := NewPasswordManager(params); err == nil {
+				t.Error("NewPasswordManager() accepted invalid parameters")
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, base32 encoded
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	// The RFC lists eight-digit codes; six-digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, now)
+		if !ok || step != TOTPStep(now) {
+			t.Errorf("ValidateTOTP(%s at %d) = %d, %v, want step %d", tt.code, tt.unix, step, ok, TOTPStep(now))
+		}
+	}
+}
+
+func TestTOTPSkewWindow(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %v", err)
+	}
+	now := time.Unix(1234567890, 0)
+	current := TOTPStep(now)
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		code := totpCode(key, current+offset)
+		step, ok := ValidateTOTP(rfc6238Secret, code, now)
+		want := offset >= -totpSkew && offset <= totpSkew
+		if ok != want {
+			t.Errorf("code of step %+d valid = %v, want %v", offset, ok, want)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code of step %+d reported step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestTOTPRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"short code", rfc6238Secret, "28708"},
+		{"long code", rfc6238Secret, "2870820"},
+		{"invalid secret", "not base32!", "287082"},
+		{"other secret", totpEncoding.EncodeToString([]byte("another secret value")), "287082"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, now); ok {
+				t.Errorf("ValidateTOTP() accepted %q", tt.code)
+			}
+		})
+	}
+
+	if _, ok := ValidateTOTP(strings.ToLower(rfc6238Secret), "287082", now); !ok {
+		t.Error("ValidateTOTP() refused a lowercase secret")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	code, err := NewRecoveryCode()
+	if err != nil {
+		t.Fatalf("NewRecoveryCode() error = %v", err)
+	}
+	if len(code) != 11 || code[5] != '-' || code != strings.ToLower(code) {
+		t.Errorf("NewRecoveryCode() = %q, want xxxxx-xxxxx in lowercase", code)
+	}
+
+	for _, typed := range []string{code, strings.ToUpper(code), strings.ReplaceAll(code, "-", ""), code[:5] + " " + code[6:]} {
+		if got := NormalizeRecoveryCode(typed); got != code {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", typed, got, code)
+		}
+	}
+}
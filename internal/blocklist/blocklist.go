@@ -0,0 +1,224 @@
+package blocklist
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for the formats that are hashed
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of blocklist entries
+const (
+	KindSHA256 = "sha256" // Exact content hash
+	KindPHash  = "phash"  // Perceptual hash, see PHash
+	KindDHash  = "dhash"  // Difference hash, see DHash
+)
+
+// Images with more pixels are not decoded for perceptual matching
+const maxImagePixels = 50_000_000
+
+// Match describes the blocklist entry a file matched
+type Match struct {
+	Kind     string
+	Hash     string // Hash of the entry, in hex
+	Label    string // Free text from the list, such as the source of the entry
+	Distance int    // Bits that differ from a perceptual entry, 0 for exact matches
+}
+
+type entry struct {
+	hash  uint64
+	label string
+}
+
+// entries is one loaded version of the list file
+type entries struct {
+	sha256 map[string]string // Hex hash to label
+	phash  []entry
+	dhash  []entry
+}
+
+// List matches files against a blocklist file of known prohibited content.
+// Each line of the file is an entry of the form
+//
+//	<sha256|phash|dhash> <hex hash> [label]
+//
+// Blank lines and lines starting with # are ignored. SHA-256 entries match
+// identical content; perceptual entries match images whose hash is within
+// maxDistance bits. The file can be reloaded while the server runs.
+type List struct {
+	path        string
+	maxDistance int
+
+	mu      sync.RWMutex
+	entries *entries
+}
+
+// Load reads the blocklist file at path
+func Load(path string, maxDistance int) (*List, error) {
+	l := &List{path: path, maxDistance: maxDistance}
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload reads the list file again and returns how many entries it has. On
+// error the entries loaded before are kept.
+func (l *List) Reload() (int, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open blocklist: %w", err)
+	}
+	defer file.Close()
+
+	loaded, err := parse(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read blocklist %s: %w", l.path, err)
+	}
+
+	l.mu.Lock()
+	l.entries = loaded
+	l.mu.Unlock()
+	return loaded.count(), nil
+}
+
+// ReloadOn reloads the list whenever the process receives one of the
+// signals, such as SIGHUP. It never returns.
+func (l *List) ReloadOn(signals ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	for range c {
+		count, err := l.Reload()
+		if err != nil {
+			logrus.WithError(err).Error("Failed to reload blocklist, keeping the previous entries")
+			continue
+		}
+		logrus.WithField("entries", count).Info("Blocklist reloaded")
+	}
+}
+
+// MatchSHA256 reports whether the hex SHA-256 hash of a file is on the list
+func (l *List) MatchSHA256(hash string) (Match, bool) {
+	l.mu.RLock()
+	label, ok := l.entries.sha256[strings.ToLower(hash)]
+	l.mu.RUnlock()
+	if !ok {
+		return Match{}, false
+	}
+	return Match{Kind: KindSHA256, Hash: hash, Label: label}, true
+}
+
+// MatchImage decodes an image and reports whether it is perceptually close
+// to an entry on the list. Content that is not a supported image, or is too
+// large to decode, never matches.
+func (l *List) MatchImage(r io.ReadSeeker) (Match, bool, error) {
+	l.mu.RLock()
+	loaded := l.entries
+	l.mu.RUnlock()
+	if len(loaded.phash) == 0 && len(loaded.dhash) == 0 {
+		return Match{}, false, nil
+	}
+
+	config, _, err := image.DecodeConfig(r)
+	if err != nil || config.Width*config.Height > maxImagePixels {
+		return Match{}, false, nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Match{}, false, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return Match{}, false, nil
+	}
+
+	if len(loaded.phash) > 0 {
+		if match, ok := l.closest(KindPHash, PHash(img), loaded.phash); ok {
+			return match, true, nil
+		}
+	}
+	if len(loaded.dhash) > 0 {
+		if match, ok := l.closest(KindDHash, DHash(img), loaded.dhash); ok {
+			return match, true, nil
+		}
+	}
+	return Match{}, false, nil
+}
+
+// closest returns the nearest entry within the maximum distance of hash
+func (l *List) closest(kind string, hash uint64, list []entry) (Match, bool) {
+	best := -1
+	var match Match
+	for _, e := range list {
+		distance := bits.OnesCount64(hash ^ e.hash)
+		if distance <= l.maxDistance && (best < 0 || distance < best) {
+			best = distance
+			match = Match{Kind: kind, Hash: FormatHash(e.hash), Label: e.label, Distance: distance}
+		}
+	}
+	return match, best >= 0
+}
+
+// FormatHash formats a perceptual hash the way list entries write it
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parse reads list entries, failing on the first malformed line
+func parse(r io.Reader) (*entries, error) {
+	loaded := &entries{sha256: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a kind and a hash", line)
+		}
+		kind, hash := strings.ToLower(fields[0]), strings.ToLower(fields[1])
+		label := strings.Join(fields[2:], " ")
+
+		switch kind {
+		case KindSHA256:
+			if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+				return nil, fmt.Errorf("line %d: invalid SHA-256 hash %q", line, hash)
+			}
+			loaded.sha256[hash] = label
+		case KindPHash, KindDHash:
+			value, err := strconv.ParseUint(hash, 16, 64)
+			if err != nil || len(hash) != 16 {
+				return nil, fmt.Errorf("line %d: invalid %s %q", line, kind, hash)
+			}
+			if kind == KindPHash {
+				loaded.phash = append(loaded.phash, entry{hash: value, label: label})
+			} else {
+				loaded.dhash = append(loaded.dhash, entry{hash: value, label: label})
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// count returns the number of entries
+func (e *entries) count() int {
+	return len(e.sha256) + len(e.phash) + len(e.dhash)
+}
@@ -0,0 +1,190 @@
+package blocklist
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+// writeList writes a list file to a temporary directory and returns its path
+func writeList(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatalf("failed to write blocklist: %v", err)
+	}
+	return path
+}
+
+// encodePNG returns img as PNG content
+func encodePNG(t *testing.T, img image.Image) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		count   int
+		wantErr string
+	}{
+		{"empty", "", 0, ""},
+		{"comments and blank lines", "# known content\n\n   \n  # indented comment", 0, ""},
+		{"every kind", "sha256 " + helloSHA256 + "\nphash 0123456789abcdef\ndhash FEDCBA9876543210 from a report", 3, ""},
+		{"upper case kind", "SHA256 " + helloSHA256, 1, ""},
+		{"missing hash", "# header\nsha256", 0, "line 2: expected a kind and a hash"},
+		{"unknown kind", "md5 d41d8cd98f00b204e9800998ecf8427e", 0, `line 1: unknown kind "md5"`},
+		{"short sha256", "sha256 2cf24dba", 0, "line 1: invalid SHA-256 hash"},
+		{"sha256 not hex", "sha256 " + strings.Repeat("zz", 32), 0, "line 1: invalid SHA-256 hash"},
+		{"short phash", "phash 0123", 0, "line 1: invalid phash"},
+		{"long dhash", "dhash 0123456789abcdef00", 0, "line 1: invalid dhash"},
+		{"phash not hex", "phash 0123456789abcdeg", 0, "line 1: invalid phash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded, err := parse(strings.NewReader(tt.list))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if loaded.count() != tt.count {
+				t.Errorf("parse() loaded %d entries, want %d", loaded.count(), tt.count)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.txt"), 0); err == nil {
+		t.Fatal("Load() of a missing file succeeded")
+	}
+}
+
+func TestMatchSHA256(t *testing.T) {
+	list, err := Load(writeList(t, "sha256 "+strings.ToUpper(helloSHA256)+" reported by a user"), 0)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for _, hash := range []string{helloSHA256, strings.ToUpper(helloSHA256)} {
+		match, ok := list.MatchSHA256(hash)
+		if !ok {
+			t.Fatalf("MatchSHA256(%s) did not match", hash)
+		}
+		if match.Kind != KindSHA256 || match.Label != "reported by a user" || match.Distance != 0 {
+			t.Errorf("MatchSHA256(%s) = %+v", hash, match)
+		}
+	}
+	if _, ok := list.MatchSHA256(strings.Repeat("0", 64)); ok {
+		t.Error("MatchSHA256() matched a hash that is not listed")
+	}
+}
+
+func TestReloadKeepsEntriesOnError(t *testing.T) {
+	path := writeList(t, "sha256 "+helloSHA256)
+	list, err := Load(path, 0)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("sha256 "+helloSHA256+"\nbogus"), 0o600); err != nil {
+		t.Fatalf("failed to write blocklist: %v", err)
+	}
+	if _, err := list.Reload(); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Reload() error = %v, want one naming line 2", err)
+	}
+	if _, ok := list.MatchSHA256(helloSHA256); !ok {
+		t.Error("failed reload dropped the previous entries")
+	}
+
+	if err := os.WriteFile(path, []byte("phash 0123456789abcdef\ndhash 0123456789abcdef"), 0o600); err != nil {
+		t.Fatalf("failed to write blocklist: %v", err)
+	}
+	if count, err := list.Reload(); err != nil || count != 2 {
+		t.Fatalf("Reload() = %d, %v, want 2", count, err)
+	}
+	if _, ok := list.MatchSHA256(helloSHA256); ok {
+		t.Error("removed entry still matches after reload")
+	}
+}
+
+func TestMatchImage(t *testing.T) {
+	listed := picture(256, 256, rings)
+	hash := PHash(listed)
+	// Three bits away from the listed hash
+	nearby := hash ^ 0b1011
+	scaled := picture(301, 257, rings)
+	unrelated := PHash(picture(256, 256, blobs))
+
+	tests := []struct {
+		name        string
+		entries     []string
+		maxDistance int
+		img         image.Image
+		want        Match
+		wantMatch   bool
+	}{
+		{"same image", []string{"phash " + FormatHash(hash) + " known"}, 0, listed,
+			Match{Kind: KindPHash, Hash: FormatHash(hash), Label: "known"}, true},
+		{"scaled copy", []string{"phash " + FormatHash(hash)}, 4, scaled,
+			Match{Kind: KindPHash, Hash: FormatHash(hash), Distance: distance(hash, PHash(scaled))}, true},
+		{"within the distance", []string{"phash " + FormatHash(nearby)}, 3, listed,
+			Match{Kind: KindPHash, Hash: FormatHash(nearby), Distance: 3}, true},
+		{"beyond the distance", []string{"phash " + FormatHash(nearby)}, 2, listed, Match{}, false},
+		{"closest entry wins", []string{"phash " + FormatHash(nearby) + " far", "phash " + FormatHash(hash) + " near"}, 4, listed,
+			Match{Kind: KindPHash, Hash: FormatHash(hash), Label: "near"}, true},
+		{"different image", []string{"phash " + FormatHash(unrelated)}, 8, listed, Match{}, false},
+		{"dhash entry", []string{"dhash " + FormatHash(DHash(listed))}, 0, listed,
+			Match{Kind: KindDHash, Hash: FormatHash(DHash(listed))}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := Load(writeList(t, tt.entries...), tt.maxDistance)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			match, ok, err := list.MatchImage(encodePNG(t, tt.img))
+			if err != nil {
+				t.Fatalf("MatchImage() error = %v", err)
+			}
+			if ok != tt.wantMatch {
+				t.Fatalf("MatchImage() matched = %v, want %v", ok, tt.wantMatch)
+			}
+			if match != tt.want {
+				t.Errorf("MatchImage() = %+v, want %+v", match, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchImageIgnoresOtherContent(t *testing.T) {
+	list, err := Load(writeList(t, "phash 0000000000000000"), 64)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if _, ok, err := list.MatchImage(strings.NewReader("not an image")); ok || err != nil {
+		t.Errorf("MatchImage() of text = %v, %v, want no match", ok, err)
+	}
+
+	// The header is read before the pixels, so a huge image is never decoded
+	huge := image.NewGray(image.Rect(0, 0, 10000, 5001))
+	if _, ok, err := list.MatchImage(encodePNG(t, huge)); ok || err != nil {
+		t.Errorf("MatchImage() of a huge image = %v, %v, want no match", ok, err)
+	}
+}
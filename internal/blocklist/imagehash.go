@@ -0,0 +1,113 @@
+package blocklist
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Side of the grayscale image the DCT of a pHash is taken over
+const phashSize = 32
+
+// DHash returns the 64 bit difference hash of an image: each bit tells
+// whether a pixel of a 9x8 grayscale thumbnail is brighter than its right
+// neighbour. It survives scaling and recompression but not cropping.
+func DHash(img image.Image) uint64 {
+	pixels := grayscale(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// PHash returns the 64 bit perceptual hash of an image: each bit tells
+// whether one of the 8x8 lowest frequencies of the DCT of a 32x32 grayscale
+// thumbnail is above their median. It also survives small colour and
+// contrast changes.
+func PHash(img image.Image) uint64 {
+	pixels := grayscale(img, phashSize, phashSize)
+
+	// Separable 2D DCT-II, keeping only the lowest 8x8 frequencies
+	cosines := make([]float64, 8*phashSize)
+	for u := 0; u < 8; u++ {
+		for x := 0; x < phashSize; x++ {
+			cosines[u*phashSize+x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * phashSize))
+		}
+	}
+	rows := make([]float64, phashSize*8)
+	for y := 0; y < phashSize; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < phashSize; x++ {
+				sum += pixels[y*phashSize+x] * cosines[u*phashSize+x]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	coefficients := make([]float64, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < phashSize; y++ {
+				sum += rows[y*8+u] * cosines[v*phashSize+y]
+			}
+			coefficients[v*8+u] = sum
+		}
+	}
+
+	// The DC term is the average brightness and would skew the median
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for _, c := range coefficients {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// grayscale shrinks an image to width x height by averaging the luma of
+// the source pixels that fall in each target pixel
+func grayscale(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	sums := make([]float64, width*height)
+	counts := make([]int, width*height)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		ty := (y - bounds.Min.Y) * height / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			tx := (x - bounds.Min.X) * width / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			sums[ty*width+tx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[ty*width+tx]++
+		}
+	}
+
+	// Images smaller than the thumbnail leave some cells empty; repeat the
+	// source pixel that covers them instead
+	for ty := 0; ty < height; ty++ {
+		for tx := 0; tx < width; tx++ {
+			i := ty*width + tx
+			if counts[i] > 0 {
+				sums[i] /= float64(counts[i])
+				continue
+			}
+			x := bounds.Min.X + tx*bounds.Dx()/width
+			y := bounds.Min.Y + ty*bounds.Dy()/height
+			r, g, b, _ := img.At(x, y).RGBA()
+			sums[i] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+	return sums
+}
@@ -0,0 +1,109 @@
+package blocklist
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+// Test pictures, as brightness from 0 to 1 at a point of the unit square
+var (
+	blobs = func(x, y float64) float64 {
+		return 0.7*math.Exp(-20*(math.Pow(x-0.3, 2)+math.Pow(y-0.35, 2))) + 0.5*math.Exp(-30*(math.Pow(x-0.75, 2)+math.Pow(y-0.7, 2)))
+	}
+	rings   = func(x, y float64) float64 { return (1 + math.Sin(10*math.Hypot(x-0.35, y-0.6))) / 2 }
+	stripes = func(x, y float64) float64 { return (2 + math.Cos(9*x+4*y) + math.Sin(5*y*y-3*x)) / 4 }
+)
+
+// picture draws brightness over a width x height grayscale image
+func picture(width, height int, brightness func(x, y float64) float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := brightness((float64(x)+0.5)/float64(width), (float64(y)+0.5)/float64(height))
+			img.SetGray(x, y, color.Gray{Y: uint8(math.Round(255 * v))})
+		}
+	}
+	return img
+}
+
+func distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func TestDHashGradients(t *testing.T) {
+	tests := []struct {
+		name       string
+		brightness func(x, y float64) float64
+		want       uint64
+	}{
+		{"darker to the right", func(x, _ float64) float64 { return 1 - x }, math.MaxUint64},
+		{"brighter to the right", func(x, _ float64) float64 { return x }, 0},
+		{"flat", func(float64, float64) float64 { return 0.5 }, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DHash(picture(90, 80, tt.brightness)); got != tt.want {
+				t.Errorf("DHash() = %s, want %s", FormatHash(got), FormatHash(tt.want))
+			}
+		})
+	}
+}
+
+func TestHashesSurviveScaling(t *testing.T) {
+	hashes := []struct {
+		name string
+		hash func(image.Image) uint64
+	}{
+		{"dhash", DHash},
+		{"phash", PHash},
+	}
+	pictures := []struct {
+		name       string
+		brightness func(x, y float64) float64
+	}{
+		{"blobs", blobs},
+		{"rings", rings},
+		{"stripes", stripes},
+	}
+	for _, h := range hashes {
+		for _, p := range pictures {
+			t.Run(h.name+"/"+p.name, func(t *testing.T) {
+				original := h.hash(picture(256, 256, p.brightness))
+				for _, size := range []image.Point{{128, 128}, {301, 257}, {64, 48}} {
+					scaled := h.hash(picture(size.X, size.Y, p.brightness))
+					if d := distance(original, scaled); d > 4 {
+						t.Errorf("%dx%d copy is %d bits away", size.X, size.Y, d)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestHashesTellPicturesApart(t *testing.T) {
+	pictures := map[string]image.Image{
+		"blobs":   picture(128, 128, blobs),
+		"rings":   picture(128, 128, rings),
+		"stripes": picture(128, 128, stripes),
+	}
+	for _, pair := range [][2]string{{"blobs", "rings"}, {"blobs", "stripes"}, {"rings", "stripes"}} {
+		a, b := pictures[pair[0]], pictures[pair[1]]
+		if d := distance(PHash(a), PHash(b)); d < 16 {
+			t.Errorf("phash of %s and %s are %d bits apart", pair[0], pair[1], d)
+		}
+		if d := distance(DHash(a), DHash(b)); d < 16 {
+			t.Errorf("dhash of %s and %s are %d bits apart", pair[0], pair[1], d)
+		}
+	}
+}
+
+func TestPHashIgnoresBrightness(t *testing.T) {
+	original := PHash(picture(128, 128, rings))
+	brighter := PHash(picture(128, 128, func(x, y float64) float64 { return 0.2 + 0.8*rings(x, y) }))
+	if d := distance(original, brighter); d > 4 {
+		t.Errorf("brighter copy is %d bits away", d)
+	}
+}
@@ -2,15 +2,24 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Environment string   `mapstructure:"environment"`
-	Server      Server   `mapstructure:"server"`
-	Database    Database `mapstructure:"database"`
-	Auth        Auth     `mapstructure:"auth"`
+	Environment string     `mapstructure:"environment"`
+	Server      Server     `mapstructure:"server"`
+	Database    Database   `mapstructure:"database"`
+	Auth        Auth       `mapstructure:"auth"`
+	WebSocket   WebSocket  `mapstructure:"websocket"`
+	CORS        CORS       `mapstructure:"cors"`
+	Mail        Mail       `mapstructure:"mail"`
+	Password    Password   `mapstructure:"password"`
+	Storage     Storage    `mapstructure:"storage"`
+	Scanning    Scanning   `mapstructure:"scanning"`
+	Retention   Retention  `mapstructure:"retention"`
+	Monitoring  Monitoring `mapstructure:"monitoring"`
 }
 
 type Server struct {
@@ -28,8 +37,78 @@ type Database struct {
 }
 
 type Auth struct {
-	JWTSecret     string `mapstructure:"jwt_secret"`
-	JWTExpiration int    `mapstructure:"jwt_expiration"`
+	JWTSecret            string `mapstructure:"jwt_secret"`
+	JWTExpiration        int    `mapstructure:"jwt_expiration"`         // Access token lifetime in seconds
+	JWTRefreshExpiration int    `mapstructure:"jwt_refresh_expiration"` // Refresh token lifetime in seconds
+	RequireVerifiedEmail bool   `mapstructure:"require_verified_email"` // Only verified users may send commands
+	TOTPIssuer           string `mapstructure:"totp_issuer"`            // Account issuer shown in authenticator apps
+}
+
+type Password struct {
+	MinLength           int    `mapstructure:"min_length"`
+	MaxLength           int    `mapstructure:"max_length"`
+	CommonPasswordsFile string `mapstructure:"common_passwords_file"` // Refused passwords, one per line, added to the built-in list
+	Algorithm           string `mapstructure:"algorithm"`             // argon2id or bcrypt, used for new hashes
+	Argon2Memory        uint32 `mapstructure:"argon2_memory"`         // KiB
+	Argon2Iterations    uint32 `mapstructure:"argon2_iterations"`
+	Argon2Parallelism   uint8  `mapstructure:"argon2_parallelism"`
+	BcryptCost          int    `mapstructure:"bcrypt_cost"`
+}
+
+type Storage struct {
+	Directory             string        `mapstructure:"directory"`               // Root of the content-addressed file store
+	MaxUploadSize         int64         `mapstructure:"max_upload_size"`         // Bytes
+	UploadExpiration      time.Duration `mapstructure:"upload_expiration"`       // How long an unfinished resumable upload is kept after its last chunk
+	UploadCleanupInterval time.Duration `mapstructure:"upload_cleanup_interval"` // How often expired uploads are deleted
+	MaxOpenUploads        int           `mapstructure:"max_open_uploads"`        // Unfinished resumable uploads per user, 0 for no limit
+}
+
+type Scanning struct {
+	ClamdAddress         string        `mapstructure:"clamd_address"`          // host:port of a ClamAV daemon, empty to disable
+	ClamdTimeout         time.Duration `mapstructure:"clamd_timeout"`          // Limit for scanning one file
+	RetryInterval        time.Duration `mapstructure:"retry_interval"`         // How often files still pending are scanned again
+	BlocklistFile        string        `mapstructure:"blocklist_file"`         // Hashes of prohibited content, empty to disable
+	BlocklistMaxDistance int           `mapstructure:"blocklist_max_distance"` // Differing bits at which a perceptual hash still matches
+}
+
+type Retention struct {
+	Enabled       bool                     `mapstructure:"enabled"`
+	Window        time.Duration            `mapstructure:"window"`         // How long files and the commands referring to them are kept
+	TagWindows    map[string]time.Duration `mapstructure:"tag_windows"`    // Windows for commands with these tags, overriding Window
+	Interval      time.Duration            `mapstructure:"interval"`       // How often the sweeper runs
+	DryRun        bool                     `mapstructure:"dry_run"`        // Only log and count what would be deleted
+	CommandExpiry time.Duration            `mapstructure:"command_expiry"` // How long a command may stay unfinished before it expires, 0 to never expire
+}
+
+type CORS struct {
+	Enabled          bool     `mapstructure:"enabled"`
+	AllowedOrigins   []string `mapstructure:"allowed_origins"` // Browser origins allowed to call the API, "*" for any
+	AllowedMethods   []string `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string `mapstructure:"allowed_headers"` // Request headers besides the tus ones, which are always allowed
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+	MaxAge           int      `mapstructure:"max_age"` // How long browsers may cache a preflight, in seconds
+}
+
+type Monitoring struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	MetricsPath string `mapstructure:"metrics_path"`
+}
+
+type Mail struct {
+	Driver           string `mapstructure:"driver"` // smtp, file or memory
+	From             string `mapstructure:"from"`
+	SMTPHost         string `mapstructure:"smtp_host"`
+	SMTPPort         int    `mapstructure:"smtp_port"`
+	SMTPUsername     string `mapstructure:"smtp_username"`
+	SMTPPassword     string `mapstructure:"smtp_password"`
+	Directory        string `mapstructure:"directory"`          // Where the file driver writes messages
+	VerificationURL  string `mapstructure:"verification_url"`   // Page that confirms a code from a link, optional
+	PasswordResetURL string `mapstructure:"password_reset_url"` // Page that accepts a reset token from a link, optional
+}
+
+type WebSocket struct {
+	HeartbeatInterval   time.Duration `mapstructure:"heartbeat_interval"`
+	MaxMissedHeartbeats int           `mapstructure:"max_missed_heartbeats"`
 }
 
 func Load() (*Config, error) {
@@ -59,7 +138,42 @@ func Load() (*Config, error) {
 	viper.SetDefault("database.username", "postgres")
 	viper.SetDefault("database.password", "postgres")
 	viper.SetDefault("database.sslmode", "disable")
-	viper.SetDefault("auth.jwt_expiration", 86400) // 24 hours
+	viper.SetDefault("auth.jwt_expiration", 900)            // 15 minutes
+	viper.SetDefault("auth.jwt_refresh_expiration", 604800) // 7 days
+	viper.SetDefault("auth.require_verified_email", false)
+	viper.SetDefault("auth.totp_issuer", "ControlMe")
+	viper.SetDefault("password.min_length", 10)
+	viper.SetDefault("password.max_length", 128)
+	viper.SetDefault("password.algorithm", "argon2id")
+	viper.SetDefault("password.argon2_memory", 65536) // 64 MiB
+	viper.SetDefault("password.argon2_iterations", 3)
+	viper.SetDefault("password.argon2_parallelism", 2)
+	viper.SetDefault("password.bcrypt_cost", 10)
+	viper.SetDefault("storage.directory", "/storage/files")
+	viper.SetDefault("storage.max_upload_size", 100<<20) // 100 MiB
+	viper.SetDefault("storage.upload_expiration", "24h")
+	viper.SetDefault("storage.upload_cleanup_interval", "1h")
+	viper.SetDefault("storage.max_open_uploads", 10)
+	viper.SetDefault("scanning.clamd_timeout", "2m")
+	viper.SetDefault("scanning.retry_interval", "1m")
+	viper.SetDefault("scanning.blocklist_max_distance", 8)
+	viper.SetDefault("retention.enabled", true)
+	viper.SetDefault("retention.window", "336h") // 2 weeks
+	viper.SetDefault("retention.interval", "1h")
+	viper.SetDefault("retention.command_expiry", "336h")
+	viper.SetDefault("monitoring.metrics_path", "/metrics")
+	viper.SetDefault("mail.driver", "memory")
+	viper.SetDefault("mail.from", "ControlMe <no-reply@controlme.io>")
+	viper.SetDefault("mail.smtp_port", 587)
+	viper.SetDefault("mail.directory", "mail")
+	viper.SetDefault("websocket.heartbeat_interval", "30s")
+	viper.SetDefault("websocket.max_missed_heartbeats", 3)
+	viper.SetDefault("cors.enabled", true)
+	viper.SetDefault("cors.allowed_origins", []string{"http://localhost:3000", "http://localhost:5173", "https://app.controlme.io"})
+	viper.SetDefault("cors.allowed_methods", []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
+	viper.SetDefault("cors.allowed_headers", []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"})
+	viper.SetDefault("cors.allow_credentials", true)
+	viper.SetDefault("cors.max_age", 43200) // 12 hours
 
 	// Read environment variables
 	viper.AutomaticEnv()
@@ -71,6 +185,9 @@ func Load() (*Config, error) {
 	viper.BindEnv("database.username", "DB_USER")
 	viper.BindEnv("database.password", "DB_PASSWORD")
 	viper.BindEnv("auth.jwt_secret", "JWT_SECRET")
+	viper.BindEnv("mail.smtp_password", "SMTP_PASSWORD")
+	viper.BindEnv("scanning.clamd_address", "CLAMD_ADDRESS")
+	viper.BindEnv("scanning.blocklist_file", "BLOCKLIST_FILE")
 
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
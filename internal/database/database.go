@@ -101,12 +101,88 @@ func runMigrations(db *gorm.DB) error {
 		return err
 	}
 	
+	if err := migrateWithFallback(db, &models.TagSubscription{}, "TagSubscription"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.UserTagPreference{}, "UserTagPreference"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.Session{}, "Session"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.AuditLog{}, "AuditLog"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.LoginThrottle{}, "LoginThrottle"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.PasswordReset{}, "PasswordReset"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.DevicePairing{}, "DevicePairing"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.DeviceCredential{}, "DeviceCredential"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.TwoFactor{}, "TwoFactor"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.RecoveryCode{}, "RecoveryCode"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.LoginChallenge{}, "LoginChallenge"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.FileMetadata{}, "FileMetadata"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.FileName{}, "FileName"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.FileUpload{}, "FileUpload"); err != nil {
+		return err
+	}
+	
 	// Now migrate models with foreign key dependencies
 	if err := migrateCommandTable(db); err != nil {
 		return fmt.Errorf("failed to migrate Command model: %w", err)
 	}
 	log.Println("✓ Command table migrated successfully")
 	
+	if err := migrateWithFallback(db, &models.CommandEvent{}, "CommandEvent"); err != nil {
+		return err
+	}
+	
+	if err := migrateWithFallback(db, &models.CommandAssignment{}, "CommandAssignment"); err != nil {
+		return err
+	}
+	
+	if err := backfillCommandAssignments(db); err != nil {
+		return fmt.Errorf("failed to backfill command assignments: %w", err)
+	}
+	
+	if err := migrateWithFallback(db, &models.CommandFile{}, "CommandFile"); err != nil {
+		return err
+	}
+	
+	if err := backfillCommandFiles(db); err != nil {
+		return fmt.Errorf("failed to backfill command files: %w", err)
+	}
+	
 	if err := migrateWithFallback(db, &models.Block{}, "Block"); err != nil {
 		return err
 	}
@@ -115,10 +191,68 @@ func runMigrations(db *gorm.DB) error {
 		return err
 	}
 	
+	if err := seedDefaultTags(db); err != nil {
+		return fmt.Errorf("failed to seed default tags: %w", err)
+	}
+	
 	log.Println("✅ Database migration completed successfully.")
 	return nil
 }
 
+// defaultTags are the content categories every installation starts with
+var defaultTags = []models.Tag{
+	{Name: "general", Description: "Safe-for-work content"},
+	{Name: "censored", Description: "Blurred/censored adult content"},
+	{Name: "adult", Description: "Explicit content"},
+	{Name: "chastity", Description: "Chastity-related content"},
+	{Name: "feet", Description: "Foot-related content"},
+	{Name: "extreme", Description: "Intense content"},
+	{Name: "roleplay", Description: "Roleplay scenarios"},
+	{Name: "humiliation", Description: "Humiliation-based content"},
+	{Name: "public", Description: "Public setting commands"},
+	{Name: "private", Description: "Private/intimate commands"},
+	{Name: "announcements", Description: "System announcements"},
+}
+
+// seedDefaultTags creates any default tag that does not exist yet
+func seedDefaultTags(db *gorm.DB) error {
+	for _, tag := range defaultTags {
+		tag := tag
+		if err := db.Where("name = ?", tag.Name).FirstOrCreate(&tag).Error; err != nil {
+			return err
+		}
+	}
+	log.Println("✓ Default tags seeded")
+	return nil
+}
+
+// backfillCommandAssignments gives direct commands created before the
+// command_assignments table existed an assignment for their receiver
+func backfillCommandAssignments(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO command_assignments (id, command_id, user_id, status, created_at, updated_at)
+		SELECT uuid_generate_v4(), c.id, c.receiver_id, c.status, c.created_at, c.updated_at
+		FROM commands c
+		WHERE c.receiver_id IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM command_assignments a WHERE a.command_id = c.id)`).Error
+}
+
+// backfillCommandFiles records the stored files that download-file
+// instructions of commands created before the command_files table existed
+// refer to
+func backfillCommandFiles(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO command_files (id, command_id, file_hash)
+		SELECT uuid_generate_v4(), refs.command_id, refs.file_hash
+		FROM (
+			SELECT DISTINCT c.id AS command_id, i->'content'->>'file_hash' AS file_hash
+			FROM commands c, jsonb_array_elements(c.instructions::jsonb) AS i
+			WHERE c.instructions LIKE '%download-file%' AND i->>'type' = 'download-file'
+		) refs
+		WHERE refs.file_hash IN (SELECT hash FROM file_metadata)
+		AND NOT EXISTS (SELECT 1 FROM command_files f WHERE f.command_id = refs.command_id AND f.file_hash = refs.file_hash)`).Error
+}
+
 // migrateWithFallback attempts GORM AutoMigrate with fallback error handling
 func migrateWithFallback(db *gorm.DB, model interface{}, modelName string) error {
 	if err := db.AutoMigrate(model); err != nil {
@@ -213,6 +347,40 @@ func createTableManually(db *gorm.DB, modelName string) error {
 		return createBlockTableManually(db)
 	case "Report":
 		return createReportTableManually(db)
+	case "CommandEvent":
+		return createCommandEventTableManually(db)
+	case "CommandAssignment":
+		return createCommandAssignmentTableManually(db)
+	case "TagSubscription":
+		return createTagSubscriptionTableManually(db)
+	case "UserTagPreference":
+		return createUserTagPreferenceTableManually(db)
+	case "Session":
+		return createSessionTableManually(db)
+	case "AuditLog":
+		return createAuditLogTableManually(db)
+	case "LoginThrottle":
+		return createLoginThrottleTableManually(db)
+	case "PasswordReset":
+		return createPasswordResetTableManually(db)
+	case "DevicePairing":
+		return createDevicePairingTableManually(db)
+	case "DeviceCredential":
+		return createDeviceCredentialTableManually(db)
+	case "TwoFactor":
+		return createTwoFactorTableManually(db)
+	case "RecoveryCode":
+		return createRecoveryCodeTableManually(db)
+	case "LoginChallenge":
+		return createLoginChallengeTableManually(db)
+	case "FileMetadata":
+		return createFileMetadataTableManually(db)
+	case "FileName":
+		return createFileNameTableManually(db)
+	case "FileUpload":
+		return createFileUploadTableManually(db)
+	case "CommandFile":
+		return createCommandFileTableManually(db)
 	default:
 		return fmt.Errorf("unknown model name: %s", modelName)
 	}
@@ -248,7 +416,12 @@ func createUserTableManually(db *gorm.DB) error {
 			thumbs_up BIGINT DEFAULT 0,
 			created_at TIMESTAMPTZ DEFAULT NOW(),
 			updated_at TIMESTAMPTZ DEFAULT NOW(),
-			login_date TIMESTAMPTZ DEFAULT NOW()
+			login_date TIMESTAMPTZ DEFAULT NOW(),
+			suspended_until TIMESTAMPTZ,
+			banned_at TIMESTAMPTZ,
+			review_hold_at TIMESTAMPTZ,
+			verification_sent_at TIMESTAMPTZ,
+			verification_attempts BIGINT DEFAULT 0
 		)`
 	
 	if err := db.Exec(createTableSQL).Error; err != nil {
@@ -381,11 +554,18 @@ func createReportTableManually(db *gorm.DB) error {
 			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
 			reporter_id UUID NOT NULL,
 			reported_id UUID NOT NULL,
+			command_id UUID,
 			reason TEXT NOT NULL,
 			status VARCHAR(20) DEFAULT 'pending',
 			created_at TIMESTAMPTZ DEFAULT NOW(),
+			resolution VARCHAR(20),
+			moderator_id UUID,
+			moderator_note TEXT,
+			resolved_at TIMESTAMPTZ,
 			CONSTRAINT fk_reports_reporter FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE CASCADE,
-			CONSTRAINT fk_reports_reported FOREIGN KEY (reported_id) REFERENCES users(id) ON DELETE CASCADE
+			CONSTRAINT fk_reports_reported FOREIGN KEY (reported_id) REFERENCES users(id) ON DELETE CASCADE,
+			CONSTRAINT fk_reports_command FOREIGN KEY (command_id) REFERENCES commands(id) ON DELETE SET NULL,
+			CONSTRAINT fk_reports_moderator FOREIGN KEY (moderator_id) REFERENCES users(id) ON DELETE SET NULL
 		)`
 	
 	if err := db.Exec(createTableSQL).Error; err != nil {
@@ -409,3 +589,769 @@ func createReportTableManually(db *gorm.DB) error {
 	log.Println("Reports table created manually with indexes")
 	return nil
 }
+
+// createCommandEventTableManually creates the command_events table manually
+func createCommandEventTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'command_events')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if command_events table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Command events table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating command_events table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE command_events (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			command_id UUID NOT NULL,
+			user_id UUID,
+			from_status VARCHAR(20) NOT NULL,
+			to_status VARCHAR(20) NOT NULL,
+			actor_id UUID,
+			note TEXT,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			CONSTRAINT fk_command_events_command FOREIGN KEY (command_id) REFERENCES commands(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating command_events table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE INDEX IF NOT EXISTS idx_command_events_command_id ON command_events(command_id)",
+		"CREATE INDEX IF NOT EXISTS idx_command_events_user_id ON command_events(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_command_events_created_at ON command_events(created_at)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Command events table created manually with indexes")
+	return nil
+}
+
+// createCommandAssignmentTableManually creates the command_assignments table manually
+func createCommandAssignmentTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'command_assignments')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if command_assignments table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Command assignments table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating command_assignments table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE command_assignments (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			command_id UUID NOT NULL,
+			user_id UUID NOT NULL,
+			status VARCHAR(20) DEFAULT 'pending',
+			delivered_at TIMESTAMPTZ,
+			acknowledged_at TIMESTAMPTZ,
+			completed_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			CONSTRAINT fk_command_assignments_command FOREIGN KEY (command_id) REFERENCES commands(id) ON DELETE CASCADE,
+			CONSTRAINT fk_command_assignments_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating command_assignments table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_command_assignments_unique ON command_assignments(command_id, user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_command_assignments_user_id ON command_assignments(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_command_assignments_status ON command_assignments(status)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Command assignments table created manually with indexes")
+	return nil
+}
+
+// createTagSubscriptionTableManually creates the tag_subscriptions table manually
+func createTagSubscriptionTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'tag_subscriptions')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if tag_subscriptions table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Tag subscriptions table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating tag_subscriptions table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE tag_subscriptions (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			tag_id UUID NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			CONSTRAINT fk_tag_subscriptions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			CONSTRAINT fk_tag_subscriptions_tag FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating tag_subscriptions table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_tag_subscriptions_unique ON tag_subscriptions(user_id, tag_id)",
+		"CREATE INDEX IF NOT EXISTS idx_tag_subscriptions_tag_id ON tag_subscriptions(tag_id)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Tag subscriptions table created manually with indexes")
+	return nil
+}
+
+// createUserTagPreferenceTableManually creates the user_tag_preferences table manually
+func createUserTagPreferenceTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'user_tag_preferences')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if user_tag_preferences table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("User tag preferences table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating user_tag_preferences table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE user_tag_preferences (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			tag_id UUID NOT NULL,
+			preference VARCHAR(20) NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			CONSTRAINT fk_user_tag_preferences_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			CONSTRAINT fk_user_tag_preferences_tag FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating user_tag_preferences table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_user_tag_preferences_unique ON user_tag_preferences(user_id, tag_id)",
+		"CREATE INDEX IF NOT EXISTS idx_user_tag_preferences_tag_id ON user_tag_preferences(tag_id)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("User tag preferences table created manually with indexes")
+	return nil
+}
+
+// createSessionTableManually creates the sessions table manually
+func createSessionTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'sessions')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if sessions table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Sessions table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating sessions table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE sessions (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			refresh_token_hash VARCHAR(64) NOT NULL,
+			previous_token_hash VARCHAR(64),
+			device_name VARCHAR(100),
+			client_type VARCHAR(20),
+			user_agent VARCHAR(255),
+			ip_address VARCHAR(45),
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			last_used_at TIMESTAMPTZ DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ,
+			CONSTRAINT fk_sessions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating sessions table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_token_hash ON sessions(refresh_token_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_sessions_previous_token_hash ON sessions(previous_token_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Sessions table created manually with indexes")
+	return nil
+}
+
+// createDevicePairingTableManually creates the device_pairings table manually
+func createDevicePairingTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'device_pairings')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if device_pairings table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Device pairings table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating device_pairings table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE device_pairings (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			device_code_hash VARCHAR(64) NOT NULL,
+			user_code VARCHAR(9) NOT NULL,
+			device_name VARCHAR(100),
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			user_id UUID,
+			scope VARCHAR(20),
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			CONSTRAINT fk_device_pairings_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating device_pairings table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_device_pairings_device_code_hash ON device_pairings(device_code_hash)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_device_pairings_user_code ON device_pairings(user_code)",
+		"CREATE INDEX IF NOT EXISTS idx_device_pairings_expires_at ON device_pairings(expires_at)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Device pairings table created manually with indexes")
+	return nil
+}
+
+// createDeviceCredentialTableManually creates the device_credentials table manually
+func createDeviceCredentialTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'device_credentials')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if device_credentials table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Device credentials table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating device_credentials table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE device_credentials (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			name VARCHAR(100),
+			key_hash VARCHAR(64) NOT NULL,
+			key_prefix VARCHAR(16),
+			scope VARCHAR(20) NOT NULL DEFAULT 'receive',
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			last_used_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ,
+			CONSTRAINT fk_device_credentials_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating device_credentials table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_device_credentials_key_hash ON device_credentials(key_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_device_credentials_user_id ON device_credentials(user_id)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Device credentials table created manually with indexes")
+	return nil
+}
+
+// createPasswordResetTableManually creates the password_resets table manually
+func createPasswordResetTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'password_resets')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if password_resets table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Password resets table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating password_resets table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE password_resets (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			token_hash VARCHAR(64) NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			used_at TIMESTAMPTZ,
+			CONSTRAINT fk_password_resets_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating password_resets table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_password_resets_token_hash ON password_resets(token_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Password resets table created manually with indexes")
+	return nil
+}
+
+// createAuditLogTableManually creates the audit_logs table manually
+func createAuditLogTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'audit_logs')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if audit_logs table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Audit logs table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating audit_logs table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE audit_logs (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			event VARCHAR(50) NOT NULL,
+			user_id UUID,
+			actor_id UUID,
+			ip_address VARCHAR(45),
+			detail TEXT,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating audit_logs table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE INDEX IF NOT EXISTS idx_audit_logs_event ON audit_logs(event)",
+		"CREATE INDEX IF NOT EXISTS idx_audit_logs_user_id ON audit_logs(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs(created_at)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Audit logs table created manually with indexes")
+	return nil
+}
+
+// createLoginThrottleTableManually creates the login_throttles table manually
+func createLoginThrottleTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'login_throttles')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if login_throttles table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Login throttles table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating login_throttles table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE login_throttles (
+			key VARCHAR(150) PRIMARY KEY,
+			failures BIGINT NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMPTZ,
+			blocked_until TIMESTAMPTZ
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating login_throttles table: %w", err)
+	}
+	
+	log.Println("Login throttles table created manually")
+	return nil
+}
+
+// createTwoFactorTableManually creates the two_factors table manually
+func createTwoFactorTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'two_factors')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if two_factors table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Two-factor table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating two_factors table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE two_factors (
+			user_id UUID PRIMARY KEY,
+			secret VARCHAR(64) NOT NULL,
+			last_used_step BIGINT DEFAULT 0,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			enabled_at TIMESTAMPTZ,
+			CONSTRAINT fk_two_factors_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating two_factors table: %w", err)
+	}
+	
+	log.Println("Two-factor table created manually")
+	return nil
+}
+
+// createRecoveryCodeTableManually creates the recovery_codes table manually
+func createRecoveryCodeTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'recovery_codes')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if recovery_codes table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Recovery codes table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating recovery_codes table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE recovery_codes (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMPTZ,
+			CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating recovery_codes table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Recovery codes table created manually with indexes")
+	return nil
+}
+
+// createLoginChallengeTableManually creates the login_challenges table manually
+func createLoginChallengeTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'login_challenges')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if login_challenges table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Login challenges table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating login_challenges table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE login_challenges (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			token_hash VARCHAR(64) NOT NULL,
+			device_name VARCHAR(100),
+			client_type VARCHAR(20),
+			attempts BIGINT DEFAULT 0,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			CONSTRAINT fk_login_challenges_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating login_challenges table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_login_challenges_token_hash ON login_challenges(token_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_login_challenges_user_id ON login_challenges(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_login_challenges_expires_at ON login_challenges(expires_at)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Login challenges table created manually with indexes")
+	return nil
+}
+
+// createFileMetadataTableManually creates the file_metadata table manually
+func createFileMetadataTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'file_metadata')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if file_metadata table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("File metadata table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating file_metadata table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE file_metadata (
+			hash VARCHAR(64) PRIMARY KEY,
+			size BIGINT NOT NULL,
+			content_type VARCHAR(100),
+			uploaded_by UUID NOT NULL,
+			scan_status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			scan_detail VARCHAR(255),
+			scanned_at TIMESTAMPTZ,
+			scan_attempts INTEGER NOT NULL DEFAULT 0,
+			next_scan_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating file_metadata table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE INDEX IF NOT EXISTS idx_file_metadata_uploaded_by ON file_metadata(uploaded_by)",
+		"CREATE INDEX IF NOT EXISTS idx_file_metadata_scan_status ON file_metadata(scan_status)",
+		"CREATE INDEX IF NOT EXISTS idx_file_metadata_next_scan_at ON file_metadata(next_scan_at)",
+		"CREATE INDEX IF NOT EXISTS idx_file_metadata_last_used_at ON file_metadata(last_used_at)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("File metadata table created manually with indexes")
+	return nil
+}
+
+// createFileNameTableManually creates the file_names table manually
+func createFileNameTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'file_names')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if file_names table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("File names table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating file_names table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE file_names (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			file_hash VARCHAR(64) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			uploaded_by UUID NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			CONSTRAINT fk_file_metadata_names FOREIGN KEY (file_hash) REFERENCES file_metadata(hash) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating file_names table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_file_names_unique ON file_names(file_hash, name, uploaded_by)",
+		"CREATE INDEX IF NOT EXISTS idx_file_names_uploaded_by ON file_names(uploaded_by)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("File names table created manually with indexes")
+	return nil
+}
+
+// createFileUploadTableManually creates the file_uploads table manually
+func createFileUploadTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'file_uploads')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if file_uploads table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("File uploads table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating file_uploads table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE file_uploads (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			file_name VARCHAR(255) NOT NULL,
+			upload_length BIGINT NOT NULL,
+			upload_offset BIGINT NOT NULL DEFAULT 0,
+			sha256 VARCHAR(64) NOT NULL,
+			completed BOOLEAN NOT NULL DEFAULT false,
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating file_uploads table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE INDEX IF NOT EXISTS idx_file_uploads_user_id ON file_uploads(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_file_uploads_expires_at ON file_uploads(expires_at)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("File uploads table created manually with indexes")
+	return nil
+}
+
+// createCommandFileTableManually creates the command_files table manually
+func createCommandFileTableManually(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'command_files')").Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error checking if command_files table exists: %w", err)
+	}
+	
+	if exists {
+		log.Println("Command files table already exists, skipping manual creation")
+		return nil
+	}
+	
+	log.Println("Creating command_files table manually due to GORM migration failure...")
+	
+	createTableSQL := `
+		CREATE TABLE command_files (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			command_id UUID NOT NULL,
+			file_hash VARCHAR(64) NOT NULL,
+			CONSTRAINT fk_command_files_command FOREIGN KEY (command_id) REFERENCES commands(id) ON DELETE CASCADE,
+			CONSTRAINT fk_command_files_file FOREIGN KEY (file_hash) REFERENCES file_metadata(hash) ON DELETE CASCADE
+		)`
+	
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		return fmt.Errorf("error creating command_files table: %w", err)
+	}
+	
+	// Create indexes
+	indexSQL := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_command_files_unique ON command_files(command_id, file_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_command_files_file_hash ON command_files(file_hash)",
+	}
+	
+	for _, sql := range indexSQL {
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("Warning: Failed to create index: %v", err)
+		}
+	}
+	
+	log.Println("Command files table created manually with indexes")
+	return nil
+}
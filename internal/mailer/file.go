@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer writes each message to an .eml file instead of sending it,
+// for local development
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+// NewFileMailer creates a mailer that writes messages to dir
+func NewFileMailer(from, dir string) (*FileMailer, error) {
+	if dir == "" {
+		dir = "mail"
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileMailer{from: from, dir: dir}, nil
+}
+
+// Send writes the message to a new file named after its time of sending
+func (m *FileMailer) Send(message Message) error {
+	if err := checkHeaders(message); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405"), uuid.NewString()[:8])
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, message, now), 0o640)
+}
@@ -0,0 +1,74 @@
+// Package mailer sends transactional email such as verification codes.
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thecontrolapp/controlme-go/internal/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Mail drivers
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverMemory = "memory"
+)
+
+// New creates the mailer selected by the configured driver
+func New(cfg config.Mail) (Mailer, error) {
+	switch cfg.Driver {
+	case DriverSMTP:
+		return NewSMTPMailer(cfg), nil
+	case DriverFile:
+		return NewFileMailer(cfg.From, cfg.Directory)
+	case DriverMemory, "":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %s", cfg.Driver)
+	}
+}
+
+// ErrInvalidHeader is returned for a recipient or subject containing line breaks
+var ErrInvalidHeader = errors.New("invalid mail header")
+
+// format renders a message as an RFC 5322 email
+func format(from string, message Message, date time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validHeader reports whether a header value cannot inject further headers
+func validHeader(value string) bool {
+	return !strings.ContainsAny(value, "\r\n")
+}
+
+// checkHeaders rejects messages whose recipient or subject contain line breaks
+func checkHeaders(message Message) error {
+	if !validHeader(message.To) || !validHeader(message.Subject) {
+		return ErrInvalidHeader
+	}
+	return nil
+}
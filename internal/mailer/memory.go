@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryMailer keeps sent messages in memory and logs them, for tests and
+// local development. It never delivers anything.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryMailer creates an empty in-memory mailer
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send records the message
+func (m *MemoryMailer) Send(message Message) error {
+	if err := checkHeaders(message); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.messages = append(m.messages, message)
+	m.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"to":      message.To,
+		"subject": message.Subject,
+	}).Info("Mail recorded in memory:\n" + message.Body)
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -1,13 +1,18 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/config"
+	"github.com/thecontrolapp/controlme-go/internal/models"
 )
 
 // Logger returns a Gin middleware for logging requests
@@ -42,17 +47,27 @@ func Recovery() gin.HandlerFunc {
 	})
 }
 
-// CORS returns a Gin middleware for handling CORS
-func CORS() gin.HandlerFunc {
-	config := cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:5173", "https://app.controlme.io"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length", "X-Total-Count"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
+// Headers tus clients send and read on resumable uploads
+var (
+	tusRequestHeaders  = []string{"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"}
+	tusResponseHeaders = []string{"Location", "Upload-Offset", "Upload-Length", "Upload-Expires", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size"}
+)
+
+// CORS returns a Gin middleware for handling CORS with the configured
+// origins. It fails if the settings are invalid, such as no origins at all.
+func CORS(cfg config.CORS) (gin.HandlerFunc, error) {
+	corsConfig := cors.Config{
+		AllowOrigins:     cfg.AllowedOrigins,
+		AllowMethods:     cfg.AllowedMethods,
+		AllowHeaders:     append(append([]string(nil), cfg.AllowedHeaders...), tusRequestHeaders...),
+		ExposeHeaders:    append([]string{"Content-Length", "X-Total-Count"}, tusResponseHeaders...),
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           time.Duration(cfg.MaxAge) * time.Second,
+	}
+	if err := corsConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid CORS settings: %w", err)
 	}
-	return cors.New(config)
+	return cors.New(corsConfig), nil
 }
 
 // Security returns a Gin middleware for security headers
@@ -81,34 +96,122 @@ func RateLimiter() gin.HandlerFunc {
 	}
 }
 
-// Auth returns a Gin middleware for JWT authentication
-func Auth() gin.HandlerFunc {
+// BearerToken returns the token of an "Authorization: Bearer <token>" header
+func BearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// SessionCheck reports whether a session of the user is still active
+type SessionCheck func(userID, sessionID uuid.UUID) bool
+
+// JWTAuth is a middleware for JWT authentication. It requires an
+// "Authorization: Bearer <token>" header signed by the auth service whose
+// session is still active, and stores the caller's user and session IDs in
+// the context, see UserID and SessionID.
+func JWTAuth(authService *auth.AuthService, sessionActive SessionCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := BearerToken(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing token"})
+			return
+		}
+		claims, err := authService.JWTManager.ValidateToken(token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		userID, err := uuid.Parse(claims.UserID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		sessionID, err := uuid.Parse(claims.SessionID)
+		if err != nil || !sessionActive(userID, sessionID) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+			return
+		}
+		c.Set(userIDKey, userID)
+		c.Set(sessionIDKey, sessionID)
+		c.Next()
+	}
+}
+
+// DeviceKeyCheck returns the user a device key belongs to, or false if the key is not valid
+type DeviceKeyCheck func(key string) (uuid.UUID, bool)
+
+// JWTOrDeviceAuth is JWTAuth for routes desktop clients also use. A bearer
+// token starting with auth.DeviceKeyPrefix is checked as a device key
+// instead; such requests have a user ID but no session ID in the context.
+func JWTOrDeviceAuth(authService *auth.AuthService, sessionActive SessionCheck, deviceKeyOwner DeviceKeyCheck) gin.HandlerFunc {
+	jwtAuth := JWTAuth(authService, sessionActive)
 	return func(c *gin.Context) {
-		// TODO: Implement JWT validation - for now pass through
-		// authHeader := c.GetHeader("Authorization")
-		// if authHeader == "" {
-		//     c.JSON(401, gin.H{"error": "Authorization header required"})
-		//     c.Abort()
-		//     return
-		// }
+		token, ok := BearerToken(c)
+		if !ok || !strings.HasPrefix(token, auth.DeviceKeyPrefix) {
+			jwtAuth(c)
+			return
+		}
+		userID, ok := deviceKeyOwner(token)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid device key"})
+			return
+		}
+		c.Set(userIDKey, userID)
 		c.Next()
 	}
 }
 
-// JWTAuth is a middleware for JWT authentication
-func JWTAuth() gin.HandlerFunc {
+// RoleLookup returns the role of a user
+type RoleLookup func(userID uuid.UUID) (string, error)
+
+// RequirePermission allows a request only if the authenticated user's role
+// grants the permission. It must run after JWTAuth.
+func RequirePermission(lookup RoleLookup, permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
-		if token == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing token"})
+		userID, ok := UserID(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 			return
 		}
-		claims, err := auth.ParseJWT(token)
+		role, err := lookup(userID)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
 		}
-		c.Set("user_id", claims.UserID)
+		if !models.HasPermission(role, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
 		c.Next()
 	}
 }
+
+// Context keys holding the authenticated caller
+const (
+	userIDKey    = "user_id"
+	sessionIDKey = "session_id"
+)
+
+// UserID returns the authenticated user's ID set by JWTAuth
+func UserID(c *gin.Context) (uuid.UUID, bool) {
+	value, ok := c.Get(userIDKey)
+	if !ok {
+		return uuid.Nil, false
+	}
+	userID, ok := value.(uuid.UUID)
+	return userID, ok
+}
+
+// SessionID returns the authenticated session's ID set by JWTAuth
+func SessionID(c *gin.Context) (uuid.UUID, bool) {
+	value, ok := c.Get(sessionIDKey)
+	if !ok {
+		return uuid.Nil, false
+	}
+	sessionID, ok := value.(uuid.UUID)
+	return sessionID, ok
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Audit log events
+const (
+	AuditEventAccountLocked      = "account_locked"   // Too many failed logins for an account
+	AuditEventIPBlocked          = "ip_blocked"       // Too many failed logins from an IP address
+	AuditEventAccountUnlocked    = "account_unlocked" // An admin cleared an account's failed logins
+	AuditEventTwoFactorEnabled   = "two_factor_enabled"
+	AuditEventTwoFactorDisabled  = "two_factor_disabled"
+	AuditEventBlocklistMatch     = "blocklist_match"      // An upload matched the blocklist and the uploader was held for review
+	AuditEventReviewHoldReleased = "review_hold_released" // A moderator released a user held for review
+)
+
+// AuditLog records a security-relevant event. Entries are only ever inserted.
+type AuditLog struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	Event     string     `gorm:"size:50;not null;index" json:"event"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index" json:"user_id,omitempty"` // User the event is about
+	ActorID   *uuid.UUID `gorm:"type:uuid" json:"actor_id,omitempty"`      // User who caused it, if not the subject
+	IPAddress string     `gorm:"size:45" json:"ip_address,omitempty"`
+	Detail    string     `gorm:"type:text" json:"detail,omitempty"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+}
+
+// BeforeCreate sets the ID before creating an audit log entry
+func (a *AuditLog) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// LoginThrottle counts recent failed logins for an account or an IP address
+type LoginThrottle struct {
+	Key           string     `gorm:"size:150;primary_key" json:"key"` // "account:<login name>" or "ip:<address>"
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`
+}
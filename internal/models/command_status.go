@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Command lifecycle statuses
+const (
+	CommandStatusAwaitingApproval = "awaiting_approval" // Held until the receiver approves it, see TagPreferenceRequireApproval
+	CommandStatusPending          = "pending"           // Stored, not yet received by a client
+	CommandStatusDelivered        = "delivered"         // A receiver's client confirmed receipt
+	CommandStatusAcknowledged     = "acknowledged"      // The receiver accepted the command
+	CommandStatusCompleted        = "completed"         // The receiver carried it out
+	CommandStatusFailed           = "failed"            // The receiver could not carry it out
+	CommandStatusExpired          = "expired"           // Not finished within retention.command_expiry
+	CommandStatusCancelled        = "cancelled"         // Withdrawn by the sender or by moderation
+	CommandStatusDeclined         = "declined"          // The receiver refused a command awaiting approval
+)
+
+// commandTransitions lists the statuses each status may move to
+var commandTransitions = map[string][]string{
+	CommandStatusAwaitingApproval: {CommandStatusPending, CommandStatusDeclined, CommandStatusExpired, CommandStatusCancelled},
+	CommandStatusPending:          {CommandStatusDelivered, CommandStatusExpired, CommandStatusCancelled},
+	CommandStatusDelivered:        {CommandStatusAcknowledged, CommandStatusCompleted, CommandStatusFailed, CommandStatusExpired, CommandStatusCancelled},
+	CommandStatusAcknowledged:     {CommandStatusCompleted, CommandStatusFailed, CommandStatusExpired, CommandStatusCancelled},
+	CommandStatusCompleted:        {},
+	CommandStatusFailed:           {},
+	CommandStatusExpired:          {},
+	CommandStatusCancelled:        {},
+	CommandStatusDeclined:         {},
+}
+
+// CanTransitionCommand reports whether a command may move from one status to another
+func CanTransitionCommand(from, to string) bool {
+	for _, allowed := range commandTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandEvent records a single status transition of a command
+type CommandEvent struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	CommandID  uuid.UUID  `gorm:"type:uuid;not null;index;constraint:OnDelete:CASCADE" json:"command_id"`
+	UserID     *uuid.UUID `gorm:"type:uuid;index" json:"user_id,omitempty"` // Recipient whose assignment changed; nil for the whole command
+	FromStatus string     `gorm:"size:20;not null" json:"from_status"`
+	ToStatus   string     `gorm:"size:20;not null" json:"to_status"`
+	ActorID    *uuid.UUID `gorm:"type:uuid" json:"actor_id,omitempty"` // Nil when the server made the change
+	Note       string     `gorm:"type:text" json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Relationships
+	Command Command `gorm:"foreignKey:CommandID;references:ID" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating a command event
+func (e *CommandEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+func TestCanTransitionCommand(t *testing.T) {
+	statuses := []string{
+		CommandStatusAwaitingApproval, CommandStatusPending, CommandStatusDelivered, CommandStatusAcknowledged,
+		CommandStatusCompleted, CommandStatusFailed, CommandStatusExpired, CommandStatusCancelled, CommandStatusDeclined,
+	}
+	allowed := map[string]map[string]bool{
+		CommandStatusAwaitingApproval: {CommandStatusPending: true, CommandStatusDeclined: true, CommandStatusExpired: true, CommandStatusCancelled: true},
+		CommandStatusPending:          {CommandStatusDelivered: true, CommandStatusExpired: true, CommandStatusCancelled: true},
+		CommandStatusDelivered:        {CommandStatusAcknowledged: true, CommandStatusCompleted: true, CommandStatusFailed: true, CommandStatusExpired: true, CommandStatusCancelled: true},
+		CommandStatusAcknowledged:     {CommandStatusCompleted: true, CommandStatusFailed: true, CommandStatusExpired: true, CommandStatusCancelled: true},
+		// Completed, failed, expired, cancelled and declined are terminal
+	}
+
+	for _, from := range append(statuses, "unknown") {
+		for _, to := range append(statuses, "unknown") {
+			want := allowed[from][to]
+			if got := CanTransitionCommand(from, to); got != want {
+				t.Errorf("CanTransitionCommand(%q, %q) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+
+	if len(commandTransitions) != len(statuses) {
+		t.Errorf("commandTransitions has %d statuses, want %d", len(commandTransitions), len(statuses))
+	}
+}
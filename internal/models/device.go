@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Device pairing statuses
+const (
+	PairingStatusPending  = "pending"
+	PairingStatusApproved = "approved"
+	PairingStatusDenied   = "denied"
+)
+
+// Device credential scopes
+const (
+	// DeviceScopeReceive lets a device connect as a desktop client, receive
+	// commands and report their status
+	DeviceScopeReceive = "receive"
+	// DeviceScopeSend additionally lets a device send commands
+	DeviceScopeSend = "send"
+)
+
+// IsValidDeviceScope reports whether scope is a known device credential scope
+func IsValidDeviceScope(scope string) bool {
+	return scope == DeviceScopeReceive || scope == DeviceScopeSend
+}
+
+// DevicePairing is a pending request from a desktop client to be paired with
+// an account. The client shows the user code and polls with the device code
+// until a signed-in user approves or denies it.
+type DevicePairing struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	DeviceCodeHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	UserCode       string     `gorm:"size:9;not null;uniqueIndex" json:"user_code"`
+	DeviceName     string     `gorm:"size:100" json:"device_name"`
+	Status         string     `gorm:"size:20;not null;default:'pending'" json:"status"`
+	UserID         *uuid.UUID `gorm:"type:uuid;constraint:OnDelete:CASCADE" json:"user_id,omitempty"` // User who approved or denied it
+	Scope          string     `gorm:"size:20" json:"scope,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      time.Time  `gorm:"not null;index" json:"expires_at"`
+}
+
+// BeforeCreate sets the ID before creating a device pairing
+func (p *DevicePairing) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// DeviceCredential is a long-lived key a paired desktop client connects with.
+// Only a hash of the key is stored.
+type DeviceCredential struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index;constraint:OnDelete:CASCADE" json:"user_id"`
+	Name       string     `gorm:"size:100" json:"name"`
+	KeyHash    string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	KeyPrefix  string     `gorm:"size:16" json:"key_prefix"` // Start of the key, to tell keys apart
+	Scope      string     `gorm:"size:20;not null;default:'receive'" json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating a device credential
+func (d *DeviceCredential) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// CanSend reports whether the credential may be used to send commands
+func (d *DeviceCredential) CanSend() bool {
+	return d.Scope == DeviceScopeSend
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// File scan statuses. A file is quarantined until every configured scanner
+// has passed it.
+const (
+	ScanStatusPending  = "pending"  // Not scanned yet, or a scanner could not decide
+	ScanStatusClean    = "clean"    // Every scanner passed the file
+	ScanStatusInfected = "infected" // A scanner found something; the file is never served
+)
+
+// FileMetadata describes a stored file. Identical uploads share one blob and
+// one metadata row, keyed by the SHA-256 hash of the content.
+type FileMetadata struct {
+	Hash         string     `gorm:"primary_key;size:64" json:"hash"`
+	Size         int64      `gorm:"not null" json:"size"`
+	ContentType  string     `gorm:"size:100" json:"content_type"`
+	UploadedBy   uuid.UUID  `gorm:"type:uuid;not null;index" json:"uploaded_by"` // First uploader
+	ScanStatus   string     `gorm:"size:20;not null;default:'pending';index" json:"scan_status"`
+	ScanDetail   string     `gorm:"size:255" json:"-"` // Scanner and signature that flagged the file
+	ScannedAt    *time.Time `json:"scanned_at,omitempty"`
+	ScanAttempts int        `gorm:"not null;default:0" json:"-"` // Scans that ended without a verdict
+	NextScanAt   *time.Time `gorm:"index" json:"-"`              // When a file still pending is scanned again
+	LastUsedAt   *time.Time `gorm:"index" json:"-"`              // Latest upload of the content; retention counts from here
+	CreatedAt    time.Time  `json:"created_at"`
+
+	// Relationships
+	Names []FileName `gorm:"foreignKey:FileHash;references:Hash;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName keeps the table name singular, as "metadata" is already a plural
+func (FileMetadata) TableName() string {
+	return "file_metadata"
+}
+
+// FileName maps a name a user uploaded a file under to its content. A file
+// has one mapping for each distinct name and uploader.
+type FileName struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	FileHash   string    `gorm:"size:64;not null;uniqueIndex:idx_file_names_unique,priority:1" json:"file_hash"`
+	Name       string    `gorm:"size:255;not null;uniqueIndex:idx_file_names_unique,priority:2" json:"name"`
+	UploadedBy uuid.UUID `gorm:"type:uuid;not null;index;uniqueIndex:idx_file_names_unique,priority:3" json:"uploaded_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// BeforeCreate sets the ID before creating a file name
+func (f *FileName) BeforeCreate(tx *gorm.DB) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.New()
+	}
+	return nil
+}
+
+// FileUpload tracks a resumable upload made with the tus protocol. Received
+// bytes are appended to a partial blob on disk and UploadOffset records how
+// many of them are stored.
+type FileUpload struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID       uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	FileName     string    `gorm:"size:255;not null" json:"file_name"`
+	UploadLength int64     `gorm:"not null" json:"upload_length"`
+	UploadOffset int64     `gorm:"not null;default:0" json:"upload_offset"`
+	SHA256       string    `gorm:"column:sha256;size:64;not null" json:"sha256"` // Hash the finished file must have
+	Completed    bool      `gorm:"not null;default:false" json:"completed"`
+	ExpiresAt    time.Time `gorm:"not null;index" json:"expires_at"` // Unfinished uploads are deleted after this
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// BeforeCreate sets the ID before creating an upload
+func (u *FileUpload) BeforeCreate(tx *gorm.DB) error {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return nil
+}
+
+// CommandFile records that a command's instructions refer to a stored file.
+// Retention keeps the file while the command is unfinished.
+type CommandFile struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	CommandID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_command_files_unique;constraint:OnDelete:CASCADE" json:"command_id"`
+	FileHash  string    `gorm:"size:64;not null;uniqueIndex:idx_command_files_unique;index" json:"file_hash"`
+
+	// Relationships
+	Command Command      `gorm:"foreignKey:CommandID;references:ID" json:"-"`
+	File    FileMetadata `gorm:"foreignKey:FileHash;references:Hash;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating a command file reference
+func (f *CommandFile) BeforeCreate(tx *gorm.DB) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.New()
+	}
+	return nil
+}
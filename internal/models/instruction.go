@@ -1,52 +1,136 @@
 package models
 
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
 // Instruction represents a single instruction within a command
 type Instruction struct {
 	Type    string      `json:"type"`    // The instruction type (popup-msg, download-file, etc.)
-	Content interface{} `json:"content"` // Arbitrary struct containing instruction-specific data
+	Content interface{} `json:"content"` // Instruction-specific data, see the registered content types
 }
 
-// InstructionContent represents different types of instruction content,
-// this is just an example structure
-// type InstructionContent struct {
-// 	// For popup-msg
-// 	Body   string `json:"body,omitempty"`
-// 	Button string `json:"button,omitempty"`
+// Content types for the standard instructions. Fields without omitempty are
+// required, and enum tags list the accepted values. Both rules are enforced by
+// ValidateInstruction and published in the JSON Schema.
 
-// 	// For download-file
-// 	FileHash string `json:"file_hash,omitempty"`
-// 	FileName string `json:"file_name,omitempty"`
+// PopupMsgContent displays a modal message with a button
+type PopupMsgContent struct {
+	Body   string `json:"body"`
+	Button string `json:"button,omitempty"`
+}
 
-// 	// For display-text
-// 	Text   string `json:"text,omitempty"`
-// 	Format string `json:"format,omitempty"`
+// DownloadFileContent triggers a download of a stored file
+type DownloadFileContent struct {
+	FileHash string `json:"file_hash"`
+	FileName string `json:"file_name"`
+}
 
-// 	// For timer
-// 	Duration int    `json:"duration,omitempty"`
-// 	Title    string `json:"title,omitempty"`
+// DisplayTextContent shows formatted text
+type DisplayTextContent struct {
+	Text   string `json:"text"`
+	Format string `json:"format,omitempty" enum:"plain,markdown,html"`
+}
 
-// 	// For notification
-// 	// Title and Body already defined above
+// TimerContent starts a countdown timer
+type TimerContent struct {
+	Duration int    `json:"duration"` // Seconds
+	Title    string `json:"title"`
+	Message  string `json:"message,omitempty"`
+}
 
-// 	// For open-url
-// 	URL string `json:"url,omitempty"`
+// NotificationContent sends a system notification
+type NotificationContent struct {
+	Title    string `json:"title"`
+	Body     string `json:"body"`
+	Priority string `json:"priority,omitempty" enum:"low,normal,high"`
+}
 
-// 	// For form-input
-// 	Fields   []FormField `json:"fields,omitempty"`
-// 	SubmitTo string      `json:"submit_to,omitempty"`
+// OpenURLContent opens a URL in a browser or in-app
+type OpenURLContent struct {
+	URL     string `json:"url"`
+	Display string `json:"display,omitempty" enum:"external,inline,tab"`
+}
 
-// 	// For announcement
-// 	Priority string `json:"priority,omitempty"`
+// FormInputContent collects input from the receiver
+type FormInputContent struct {
+	Title    string      `json:"title"`
+	Fields   []FormField `json:"fields"`
+	SubmitTo string      `json:"submit_to,omitempty"`
+}
 
-// 	// For custom instructions - allows any additional fields
-// 	// The interface{} content field in Instruction handles arbitrary data
-// }
+// AnnouncementContent is a broadcast message with a priority
+type AnnouncementContent struct {
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	Priority  string `json:"priority,omitempty" enum:"low,normal,high"`
+	ExpiresAt string `json:"expires_at,omitempty" format:"date-time"`
+}
 
 // FormField represents a field in a form-input instruction
 type FormField struct {
 	Name     string   `json:"name"`
 	Label    string   `json:"label"`
-	Type     string   `json:"type"`
+	Type     string   `json:"type" enum:"text,textarea,select,radio,checkbox,number"`
 	Options  []string `json:"options,omitempty"`
 	Required bool     `json:"required,omitempty"`
 }
+
+var fileHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Validate checks that the file hash is a lowercase hex SHA-256 digest
+func (c *DownloadFileContent) Validate() error {
+	if !fileHashPattern.MatchString(c.FileHash) {
+		return fmt.Errorf("file_hash must be a lowercase hex SHA-256 digest")
+	}
+	return nil
+}
+
+// Validate checks that the duration is positive
+func (c *TimerContent) Validate() error {
+	if c.Duration <= 0 {
+		return fmt.Errorf("duration must be a positive number of seconds")
+	}
+	return nil
+}
+
+// Validate checks that the URL is an absolute http(s) URL
+func (c *OpenURLContent) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// Validate checks that the form has fields and that choice fields have options
+func (c *FormInputContent) Validate() error {
+	if len(c.Fields) == 0 {
+		return fmt.Errorf("fields must not be empty")
+	}
+	for i, field := range c.Fields {
+		if err := validateStruct(&c.Fields[i]); err != nil {
+			return fmt.Errorf("fields[%d]: %w", i, err)
+		}
+		switch field.Type {
+		case "select", "radio", "checkbox":
+			if len(field.Options) == 0 {
+				return fmt.Errorf("fields[%d]: options are required for %s fields", i, field.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterInstructionType("popup-msg", "Display a modal message with a button", func() interface{} { return &PopupMsgContent{} })
+	RegisterInstructionType("download-file", "Download a stored file by hash", func() interface{} { return &DownloadFileContent{} })
+	RegisterInstructionType("display-text", "Show formatted text content", func() interface{} { return &DisplayTextContent{} })
+	RegisterInstructionType("timer", "Start a countdown timer", func() interface{} { return &TimerContent{} })
+	RegisterInstructionType("notification", "Send a system notification", func() interface{} { return &NotificationContent{} })
+	RegisterInstructionType("open-url", "Open a URL in a browser", func() interface{} { return &OpenURLContent{} })
+	RegisterInstructionType("form-input", "Collect input from the receiver", func() interface{} { return &FormInputContent{} })
+	RegisterInstructionType("announcement", "Broadcast message with a priority", func() interface{} { return &AnnouncementContent{} })
+}
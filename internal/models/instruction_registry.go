@@ -0,0 +1,242 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InstructionValidator is implemented by content types that have rules
+// beyond required fields and enums
+type InstructionValidator interface {
+	Validate() error
+}
+
+// InstructionType describes a registered instruction type
+type InstructionType struct {
+	Name        string
+	Description string
+	newContent  func() interface{}
+}
+
+// instructionTypes holds every registered instruction type, keyed by name
+var instructionTypes = map[string]InstructionType{}
+
+// RegisterInstructionType registers an instruction type and its content struct.
+// newContent must return a pointer to a new, empty content struct.
+// It is meant to be called from init functions and panics on duplicates.
+func RegisterInstructionType(name, description string, newContent func() interface{}) {
+	if _, exists := instructionTypes[name]; exists {
+		panic("instruction type registered twice: " + name)
+	}
+	instructionTypes[name] = InstructionType{
+		Name:        name,
+		Description: description,
+		newContent:  newContent,
+	}
+}
+
+// LookupInstructionType returns the registered instruction type with the given name
+func LookupInstructionType(name string) (InstructionType, bool) {
+	instructionType, ok := instructionTypes[name]
+	return instructionType, ok
+}
+
+// InstructionTypeNames returns the names of all registered instruction types, sorted
+func InstructionTypeNames() []string {
+	names := make([]string, 0, len(instructionTypes))
+	for name := range instructionTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateInstruction checks an instruction against its registered content type.
+// It returns the instruction with its content decoded into the typed struct.
+func ValidateInstruction(instruction Instruction) (Instruction, error) {
+	instructionType, ok := instructionTypes[instruction.Type]
+	if !ok {
+		return instruction, fmt.Errorf("unknown instruction type %q", instruction.Type)
+	}
+
+	raw, err := json.Marshal(instruction.Content)
+	if err != nil {
+		return instruction, fmt.Errorf("%s: content is not valid JSON: %w", instruction.Type, err)
+	}
+	if bytes.Equal(raw, []byte("null")) {
+		return instruction, fmt.Errorf("%s: content is required", instruction.Type)
+	}
+
+	content := instructionType.newContent()
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(content); err != nil {
+		return instruction, fmt.Errorf("%s: malformed content: %w", instruction.Type, err)
+	}
+
+	if err := validateStruct(content); err != nil {
+		return instruction, fmt.Errorf("%s: %w", instruction.Type, err)
+	}
+	if validator, ok := content.(InstructionValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return instruction, fmt.Errorf("%s: %w", instruction.Type, err)
+		}
+	}
+
+	return Instruction{Type: instruction.Type, Content: content}, nil
+}
+
+// InstructionSchema returns a JSON Schema document describing every registered instruction type
+func InstructionSchema() map[string]interface{} {
+	variants := make([]interface{}, 0, len(instructionTypes))
+	for _, name := range InstructionTypeNames() {
+		instructionType := instructionTypes[name]
+		variants = append(variants, map[string]interface{}{
+			"title":       name,
+			"description": instructionType.Description,
+			"type":        "object",
+			"properties": map[string]interface{}{
+				"type":    map[string]interface{}{"const": name},
+				"content": schemaForType(reflect.TypeOf(instructionType.newContent())),
+			},
+			"required":             []string{"type", "content"},
+			"additionalProperties": false,
+		})
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Instruction",
+		"oneOf":   variants,
+	}
+}
+
+// schemaField describes a struct field as seen by JSON encoding
+type schemaField struct {
+	name     string
+	required bool
+	enum     []string
+	format   string
+}
+
+// parseSchemaField reads the json, enum and format tags of a struct field.
+// It reports false for fields that are not encoded.
+func parseSchemaField(field reflect.StructField) (schemaField, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" || !field.IsExported() {
+		return schemaField{}, false
+	}
+
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+
+	result := schemaField{
+		name:     name,
+		required: !strings.Contains(options, "omitempty"),
+		format:   field.Tag.Get("format"),
+	}
+	if enum := field.Tag.Get("enum"); enum != "" {
+		result.enum = strings.Split(enum, ",")
+	}
+	return result, true
+}
+
+// validateStruct enforces the required, enum and format tags of a content struct
+func validateStruct(value interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field, ok := parseSchemaField(t.Field(i))
+		if !ok {
+			continue
+		}
+		fieldValue := v.Field(i)
+
+		if fieldValue.IsZero() {
+			if field.required {
+				return fmt.Errorf("%s is required", field.name)
+			}
+			continue
+		}
+
+		if len(field.enum) > 0 {
+			allowed := false
+			for _, option := range field.enum {
+				if fieldValue.String() == option {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return fmt.Errorf("%s must be one of %s", field.name, strings.Join(field.enum, ", "))
+			}
+		}
+
+		if field.format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, fieldValue.String()); err != nil {
+				return fmt.Errorf("%s must be an RFC 3339 timestamp", field.name)
+			}
+		}
+	}
+	return nil
+}
+
+// schemaForType builds the JSON Schema of a Go type
+func schemaForType(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field, ok := parseSchemaField(t.Field(i))
+			if !ok {
+				continue
+			}
+			property := schemaForType(t.Field(i).Type)
+			if len(field.enum) > 0 {
+				property["enum"] = field.enum
+			}
+			if field.format != "" {
+				property["format"] = field.format
+			}
+			properties[field.name] = property
+			if field.required {
+				required = append(required, field.name)
+			}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaForType(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
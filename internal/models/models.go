@@ -18,11 +18,25 @@ type User struct {
 	RandomOptIn  bool      `gorm:"default:false" json:"random_opt_in"`
 	AnonCmd      bool      `gorm:"default:false" json:"anon_cmd"`
 	Verified     bool      `gorm:"default:false" json:"verified"`
-	VerifiedCode int       `gorm:"default:0" json:"verified_code"`
+	VerifiedCode int       `gorm:"default:0" json:"-"` // Email verification code
 	ThumbsUp     int       `gorm:"default:0" json:"thumbs_up"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	LoginDate    time.Time `json:"login_date"`
+
+	// Moderation, only shown to moderators (see responses.ModeratedUser)
+	SuspendedUntil *time.Time `json:"-"` // Set when a moderator suspends the user
+	BannedAt       *time.Time `json:"-"` // Set when a moderator bans the user
+	ReviewHoldAt   *time.Time `json:"-"` // Set when an upload matched the blocklist, until a moderator releases the user
+
+	// Email verification
+	VerificationSentAt   *time.Time `json:"-"`                  // When VerifiedCode was sent
+	VerificationAttempts int        `gorm:"default:0" json:"-"` // Wrong guesses of VerifiedCode
+}
+
+// IsRestricted reports whether the user is banned, held for review or suspended at the given time
+func (u *User) IsRestricted(now time.Time) bool {
+	return u.BannedAt != nil || u.ReviewHoldAt != nil || (u.SuspendedUntil != nil && now.Before(*u.SuspendedUntil))
 }
 
 // BeforeCreate sets the ID and LoginDate before creating a user
@@ -43,13 +57,14 @@ type Command struct {
 	SenderID     uuid.UUID  `gorm:"type:uuid;not null;constraint:OnDelete:CASCADE" json:"sender_id"` // User who sent the command
 	ReceiverID   *uuid.UUID `gorm:"type:uuid;constraint:OnDelete:SET NULL" json:"receiver_id,omitempty"` // Optional: specific user target
 	Tags         string     `gorm:"type:text" json:"tags"`                          // JSON array of tag names for broadcast
-	Status       string     `gorm:"size:20;default:'pending'" json:"status"`       // pending, delivered, completed
+	Status       string     `gorm:"size:20;default:'pending'" json:"status"`       // See CommandStatus* constants
 	CreatedAt    time.Time  `json:"created_at"`
 	UpdatedAt    time.Time  `json:"updated_at"`
 
 	// Relationships - define them explicitly to avoid migration issues
-	Sender   User  `gorm:"foreignKey:SenderID;references:ID" json:"sender"`
-	Receiver *User `gorm:"foreignKey:ReceiverID;references:ID" json:"receiver,omitempty"`
+	Sender      User                `gorm:"foreignKey:SenderID;references:ID" json:"sender"`
+	Receiver    *User               `gorm:"foreignKey:ReceiverID;references:ID" json:"receiver,omitempty"`
+	Assignments []CommandAssignment `gorm:"foreignKey:CommandID;references:ID" json:"-"`
 }
 
 // BeforeCreate sets the ID before creating a command
@@ -60,6 +75,32 @@ func (c *Command) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// CommandAssignment tracks delivery and completion of a command for one recipient.
+// Direct commands have a single assignment; broadcasts have one per subscriber.
+type CommandAssignment struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	CommandID      uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_command_assignments_unique;constraint:OnDelete:CASCADE" json:"command_id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_command_assignments_unique;index;constraint:OnDelete:CASCADE" json:"user_id"`
+	Status         string     `gorm:"size:20;default:'pending';index" json:"status"` // See CommandStatus* constants
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"` // Set for completed and failed
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// Relationships
+	Command Command `gorm:"foreignKey:CommandID;references:ID" json:"-"`
+	User    User    `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating a command assignment
+func (a *CommandAssignment) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
 // Tag represents content categories/tags (chastity, feet, general, etc.)
 type Tag struct {
 	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
@@ -77,16 +118,75 @@ func (t *Tag) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// TagSubscription subscribes a user to broadcasts carrying a tag
+type TagSubscription struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_tag_subscriptions_unique;constraint:OnDelete:CASCADE" json:"user_id"`
+	TagID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_tag_subscriptions_unique;index;constraint:OnDelete:CASCADE" json:"tag_id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	Tag  Tag  `gorm:"foreignKey:TagID;references:ID" json:"tag"`
+}
+
+// BeforeCreate sets the ID before creating a tag subscription
+func (s *TagSubscription) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// Tag preferences a user can set to control the commands they receive.
+// Tags without a preference are allowed.
+const (
+	TagPreferenceAllow           = "allow"            // Deliver commands with the tag
+	TagPreferenceBlock           = "block"            // Never deliver commands with the tag
+	TagPreferenceRequireApproval = "require_approval" // Hold commands with the tag until the user approves them
+)
+
+// IsValidTagPreference reports whether preference is a known tag preference
+func IsValidTagPreference(preference string) bool {
+	switch preference {
+	case TagPreferenceAllow, TagPreferenceBlock, TagPreferenceRequireApproval:
+		return true
+	}
+	return false
+}
+
+// UserTagPreference records how a user wants commands carrying a tag handled
+type UserTagPreference struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_user_tag_preferences_unique;constraint:OnDelete:CASCADE" json:"user_id"`
+	TagID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_user_tag_preferences_unique;index;constraint:OnDelete:CASCADE" json:"tag_id"`
+	Preference string    `gorm:"size:20;not null" json:"preference"` // See TagPreference* constants
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	Tag  Tag  `gorm:"foreignKey:TagID;references:ID" json:"tag"`
+}
+
+// BeforeCreate sets the ID before creating a user tag preference
+func (p *UserTagPreference) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
 // Block represents blocked users
 type Block struct {
 	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null;constraint:OnDelete:CASCADE" json:"user_id"`
-	BlockedID uuid.UUID `gorm:"type:uuid;not null;constraint:OnDelete:CASCADE" json:"blocked_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_blocks_unique;constraint:OnDelete:CASCADE" json:"user_id"`
+	BlockedID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_blocks_unique;index;constraint:OnDelete:CASCADE" json:"blocked_id"`
 	Reason    string    `gorm:"type:text" json:"reason"`
 	CreatedAt time.Time `json:"created_at"`
 
 	// Relationships
-	User    User `gorm:"foreignKey:UserID;references:ID" json:"user"`
+	User    User `gorm:"foreignKey:UserID;references:ID" json:"-"`
 	Blocked User `gorm:"foreignKey:BlockedID;references:ID" json:"blocked"`
 }
 
@@ -98,18 +198,41 @@ func (b *Block) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// Report statuses
+const (
+	ReportStatusPending  = "pending"  // Waiting for a moderator
+	ReportStatusResolved = "resolved" // A moderator recorded a resolution
+)
+
+// Report resolutions a moderator can choose
+const (
+	ReportResolutionDismiss = "dismiss" // No action taken
+	ReportResolutionWarn    = "warn"    // The reported user is warned
+	ReportResolutionSuspend = "suspend" // The reported user is suspended for a while
+	ReportResolutionBan     = "ban"     // The reported user is banned
+)
+
 // Report represents user reports
 type Report struct {
-	ID         uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
-	ReporterID uuid.UUID `gorm:"type:uuid;not null;constraint:OnDelete:CASCADE" json:"reporter_id"`
-	ReportedID uuid.UUID `gorm:"type:uuid;not null;constraint:OnDelete:CASCADE" json:"reported_id"`
-	Reason     string    `gorm:"type:text;not null" json:"reason"`
-	Status     string    `gorm:"size:20;default:'pending'" json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	ReporterID uuid.UUID  `gorm:"type:uuid;not null;constraint:OnDelete:CASCADE" json:"reporter_id"`
+	ReportedID uuid.UUID  `gorm:"type:uuid;not null;constraint:OnDelete:CASCADE" json:"reported_id"`
+	CommandID  *uuid.UUID `gorm:"type:uuid;constraint:OnDelete:SET NULL" json:"command_id,omitempty"` // Optional command given as evidence
+	Reason     string     `gorm:"type:text;not null" json:"reason"`
+	Status     string     `gorm:"size:20;default:'pending';index" json:"status"` // See ReportStatus* constants
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Resolution
+	Resolution    string     `gorm:"size:20" json:"resolution,omitempty"` // See ReportResolution* constants
+	ModeratorID   *uuid.UUID `gorm:"type:uuid;constraint:OnDelete:SET NULL" json:"moderator_id,omitempty"`
+	ModeratorNote string     `gorm:"type:text" json:"moderator_note,omitempty"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
 
 	// Relationships
-	Reporter User `gorm:"foreignKey:ReporterID;references:ID" json:"reporter"`
-	Reported User `gorm:"foreignKey:ReportedID;references:ID" json:"reported"`
+	Reporter  User     `gorm:"foreignKey:ReporterID;references:ID" json:"reporter"`
+	Reported  User     `gorm:"foreignKey:ReportedID;references:ID" json:"reported"`
+	Command   *Command `gorm:"foreignKey:CommandID;references:ID" json:"command,omitempty"`
+	Moderator *User    `gorm:"foreignKey:ModeratorID;references:ID" json:"moderator,omitempty"`
 }
 
 // BeforeCreate sets the ID before creating a report
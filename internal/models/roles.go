@@ -0,0 +1,60 @@
+package models
+
+// User roles
+const (
+	RoleUser      = "user"      // Regular account
+	RoleModerator = "moderator" // Reviews and resolves reports
+	RoleAdmin     = "admin"     // Full access, including role changes
+)
+
+// Permissions checked by RequirePermission on privileged routes
+const (
+	PermissionUsersRead        = "users:read"         // List and look up users
+	PermissionUsersCreate      = "users:create"       // Create accounts directly, bypassing registration
+	PermissionUsersManageRoles = "users:manage_roles" // Change a user's role
+	PermissionUsersUnlock      = "users:unlock"       // Clear failed logins that locked an account
+	PermissionAuditRead        = "audit:read"         // Read the security audit log
+	PermissionReportsReview    = "reports:review"     // Read the report review queue
+	PermissionReportsResolve   = "reports:resolve"    // Resolve reports and sanction users
+	PermissionBlocklistManage  = "blocklist:manage"   // Reload the upload blocklist
+)
+
+// rolePermissions lists the permissions granted to each role
+var rolePermissions = map[string][]string{
+	RoleUser: {
+		PermissionUsersRead,
+	},
+	RoleModerator: {
+		PermissionUsersRead,
+		PermissionUsersUnlock,
+		PermissionAuditRead,
+		PermissionReportsReview,
+		PermissionReportsResolve,
+	},
+	RoleAdmin: {
+		PermissionUsersRead,
+		PermissionUsersCreate,
+		PermissionUsersManageRoles,
+		PermissionUsersUnlock,
+		PermissionAuditRead,
+		PermissionReportsReview,
+		PermissionReportsResolve,
+		PermissionBlocklistManage,
+	},
+}
+
+// IsValidRole reports whether role is a known role
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether a role grants a permission. Unknown roles grant nothing.
+func HasPermission(role, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Session is a signed-in device. Access tokens carry the session ID and stop
+// working once the session is revoked; the refresh token rotates on every use.
+type Session struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID            uuid.UUID  `gorm:"type:uuid;not null;index;constraint:OnDelete:CASCADE" json:"user_id"`
+	RefreshTokenHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	PreviousTokenHash string     `gorm:"size:64;index" json:"-"` // Detects reuse of a rotated refresh token
+	DeviceName        string     `gorm:"size:100" json:"device_name"`
+	ClientType        string     `gorm:"size:20" json:"client_type"` // web or desktop
+	UserAgent         string     `gorm:"size:255" json:"user_agent,omitempty"`
+	IPAddress         string     `gorm:"size:45" json:"ip_address,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	ExpiresAt         time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating a session
+func (s *Session) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsActive reports whether the session can still be used at the given time
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
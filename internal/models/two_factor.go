@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TwoFactor holds a user's TOTP secret. It is created when the user starts
+// enrolling and takes effect once EnabledAt is set by confirming a code.
+type TwoFactor struct {
+	UserID       uuid.UUID  `gorm:"type:uuid;primary_key;constraint:OnDelete:CASCADE" json:"-"`
+	Secret       string     `gorm:"size:64;not null" json:"-"`
+	LastUsedStep int64      `gorm:"default:0" json:"-"` // Time step of the last accepted code, so codes cannot be replayed
+	CreatedAt    time.Time  `json:"created_at"`
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+// IsEnabled reports whether the user has confirmed enrollment
+func (t *TwoFactor) IsEnabled() bool {
+	return t.EnabledAt != nil
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the user
+// has lost their authenticator. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID       uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID   uuid.UUID  `gorm:"type:uuid;not null;index;constraint:OnDelete:CASCADE" json:"user_id"`
+	CodeHash string     `gorm:"size:64;not null" json:"-"`
+	UsedAt   *time.Time `json:"used_at,omitempty"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating a recovery code
+func (r *RecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// LoginChallenge is the second step of a login for a user with two-factor
+// authentication. It remembers the login's device until a code is given.
+type LoginChallenge struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index;constraint:OnDelete:CASCADE" json:"user_id"`
+	TokenHash  string    `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	DeviceName string    `gorm:"size:100" json:"device_name"`
+	ClientType string    `gorm:"size:20" json:"client_type"`
+	Attempts   int       `gorm:"default:0" json:"attempts"` // Wrong codes given so far
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `gorm:"not null;index" json:"expires_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating a login challenge
+func (c *LoginChallenge) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
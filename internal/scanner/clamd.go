@@ -0,0 +1,117 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/thecontrolapp/controlme-go/internal/models"
+)
+
+// Size of the chunks streamed to clamd. It must stay below clamd's StreamMaxLength.
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans files with a ClamAV daemon over TCP using the INSTREAM command
+type ClamdScanner struct {
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd at address (host:port).
+// timeout bounds a whole scan, from connecting to reading the verdict.
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	return &ClamdScanner{
+		address: address,
+		timeout: timeout,
+	}
+}
+
+// Name identifies the scanner
+func (s *ClamdScanner) Name() string {
+	return "clamd"
+}
+
+// Scan streams the content to clamd and reports whether it found a signature
+func (s *ClamdScanner) Scan(r io.Reader) (Result, error) {
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return Result{}, err
+	}
+
+	if err := sendStream(conn, r); err != nil {
+		// clamd replies before closing when it refuses a stream, such as one over its size limit
+		if reply, replyErr := readReply(conn); replyErr == nil {
+			return parseReply(reply)
+		}
+		return Result{}, fmt.Errorf("failed to send file to clamd: %w", err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// sendStream writes the INSTREAM command followed by the content in
+// length-prefixed chunks and the zero-length chunk that ends it
+func sendStream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := w.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// readReply reads the NUL-terminated reply to a z-prefixed command
+func readReply(r io.Reader) (string, error) {
+	reply, err := bufio.NewReader(r).ReadString(0)
+	if err != nil && !(err == io.EOF && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+// parseReply turns "stream: OK", "stream: <signature> FOUND" or "<message> ERROR" into a result
+func parseReply(reply string) (Result, error) {
+	verdict := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case verdict == "OK":
+		return clean, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{
+			Status: models.ScanStatusInfected,
+			Detail: strings.TrimSuffix(verdict, " FOUND"),
+		}, nil
+	case strings.HasSuffix(verdict, " ERROR"):
+		return Result{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(verdict, " ERROR"))
+	default:
+		return Result{}, fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+}
@@ -0,0 +1,310 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/thecontrolapp/controlme-go/internal/models"
+)
+
+// fakeClamd accepts INSTREAM scans on a local port, like clamd does. A
+// stream that breaks the protocol is dropped without a reply.
+type fakeClamd struct {
+	listener net.Listener
+	reply    func(content []byte) string // Verdict for the streamed content
+	maxBytes int                         // StreamMaxLength; 0 for no limit
+
+	chunks  chan []int  // Chunk lengths of each scan, in order
+	content chan []byte // Content of each scan
+}
+
+func newFakeClamd(t *testing.T, reply func(content []byte) string) *fakeClamd {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	f := &fakeClamd{
+		listener: listener,
+		reply:    reply,
+		chunks:   make(chan []int, 10),
+		content:  make(chan []byte, 10),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) address() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		return
+	}
+
+	var chunks []int
+	var content bytes.Buffer
+	limited := false
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		chunks = append(chunks, int(size))
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+			return
+		}
+		if f.maxBytes > 0 && content.Len() > f.maxBytes && !limited {
+			// clamd refuses the stream as soon as it is over the limit. Unlike
+			// clamd, the fake reads on, so a reset cannot swallow the reply.
+			limited = true
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+		}
+	}
+	f.chunks <- chunks
+	f.content <- content.Bytes()
+
+	if !limited {
+		conn.Write([]byte(f.reply(content.Bytes()) + "\x00"))
+	}
+}
+
+func TestClamdScannerVerdicts(t *testing.T) {
+	eicar := []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+	clamd := newFakeClamd(t, func(content []byte) string {
+		switch {
+		case bytes.Equal(content, eicar):
+			return "stream: Eicar-Test-Signature FOUND"
+		case bytes.HasPrefix(content, []byte("broken")):
+			return "Can't allocate memory ERROR"
+		case bytes.HasPrefix(content, []byte("garbage")):
+			return "what?"
+		default:
+			return "stream: OK"
+		}
+	})
+	scanner := NewClamdScanner(clamd.address(), 5*time.Second)
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    Result
+		wantErr string
+	}{
+		{"clean", []byte("hello"), Result{Status: models.ScanStatusClean}, ""},
+		{"empty", nil, Result{Status: models.ScanStatusClean}, ""},
+		{"infected", eicar, Result{Status: models.ScanStatusInfected, Detail: "Eicar-Test-Signature"}, ""},
+		{"error", []byte("broken"), Result{}, "clamd: Can't allocate memory"},
+		{"unexpected reply", []byte("garbage"), Result{}, "unexpected clamd reply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scanner.Scan(bytes.NewReader(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Scan() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Scan() = %+v, want %+v", got, tt.want)
+			}
+			if content := <-clamd.content; !bytes.Equal(content, tt.content) {
+				t.Errorf("clamd received %d bytes, want %d", len(content), len(tt.content))
+			}
+			<-clamd.chunks
+		})
+	}
+}
+
+func TestClamdScannerChunkFraming(t *testing.T) {
+	clamd := newFakeClamd(t, func([]byte) string { return "stream: OK" })
+	scanner := NewClamdScanner(clamd.address(), 5*time.Second)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), (2*clamdChunkSize+1000)/16)
+	if _, err := scanner.Scan(bytes.NewReader(content)); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	chunks := <-clamd.chunks
+	want := []int{clamdChunkSize, clamdChunkSize, len(content) - 2*clamdChunkSize, 0}
+	if len(chunks) != len(want) {
+		t.Fatalf("chunks = %v, want %v", chunks, want)
+	}
+	for i := range want {
+		if chunks[i] != want[i] {
+			t.Fatalf("chunks = %v, want %v", chunks, want)
+		}
+	}
+	if received := <-clamd.content; !bytes.Equal(received, content) {
+		t.Error("clamd received different content")
+	}
+}
+
+func TestClamdScannerSizeLimit(t *testing.T) {
+	clamd := newFakeClamd(t, func([]byte) string { return "stream: OK" })
+	clamd.maxBytes = clamdChunkSize
+	scanner := NewClamdScanner(clamd.address(), 5*time.Second)
+
+	_, err := scanner.Scan(bytes.NewReader(make([]byte, 3*clamdChunkSize)))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("Scan() error = %v, want the size limit reply", err)
+	}
+}
+
+func TestClamdScannerUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	scanner := NewClamdScanner(address, time.Second)
+	if _, err := scanner.Scan(strings.NewReader("hello")); err == nil {
+		t.Fatal("Scan() succeeded without clamd")
+	}
+}
+
+func TestClamdScannerTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		// Accepts the stream but never answers
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	scanner := NewClamdScanner(listener.Addr().String(), 100*time.Millisecond)
+	start := time.Now()
+	if _, err := scanner.Scan(strings.NewReader("hello")); err == nil {
+		t.Fatal("Scan() succeeded without a reply")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Scan() took %s despite the timeout", elapsed)
+	}
+}
+
+func TestSendStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"empty", "", "zINSTREAM\x00\x00\x00\x00\x00"},
+		{"one chunk", "hello", "zINSTREAM\x00\x00\x00\x00\x05hello\x00\x00\x00\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := sendStream(&buf, strings.NewReader(tt.content)); err != nil {
+				t.Fatalf("sendStream() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("sendStream() wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	// A reader returning short reads gets one chunk per read
+	var buf bytes.Buffer
+	if err := sendStream(&buf, iotest.OneByteReader(strings.NewReader("ab"))); err != nil {
+		t.Fatalf("sendStream() error = %v", err)
+	}
+	if want := "zINSTREAM\x00\x00\x00\x00\x01a\x00\x00\x00\x01b\x00\x00\x00\x00"; buf.String() != want {
+		t.Errorf("sendStream() wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"terminated", "stream: OK\x00", "stream: OK", false},
+		{"trailing newline", "stream: OK\n\x00", "stream: OK", false},
+		{"unterminated", "stream: OK", "stream: OK", false},
+		{"stops at the terminator", "stream: OK\x00garbage", "stream: OK", false},
+		{"closed without a reply", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readReply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readReply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    Result
+		wantErr string
+	}{
+		{"clean", "stream: OK", Result{Status: models.ScanStatusClean}, ""},
+		{"infected", "stream: Eicar-Signature FOUND", Result{Status: models.ScanStatusInfected, Detail: "Eicar-Signature"}, ""},
+		{"signature with spaces", "stream: Win.Test.EICAR_HDB-1 (extra) FOUND", Result{Status: models.ScanStatusInfected, Detail: "Win.Test.EICAR_HDB-1 (extra)"}, ""},
+		{"error", "INSTREAM size limit exceeded. ERROR", Result{}, "clamd: INSTREAM size limit exceeded."},
+		{"unknown", "UNKNOWN COMMAND", Result{}, "unexpected clamd reply"},
+		{"empty", "", Result{}, "unexpected clamd reply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReply(tt.reply)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseReply() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseReply() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseReply() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package scanner
+
+import (
+	"io"
+
+	"github.com/thecontrolapp/controlme-go/internal/config"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+)
+
+// Result is the outcome of scanning a file
+type Result struct {
+	Status string // models.ScanStatusClean, ScanStatusInfected or ScanStatusPending
+	Detail string // What was found, such as a signature name
+}
+
+// Scanner checks file content for malware or abuse. A scanner that cannot
+// decide yet returns a pending result, and the file is scanned again later.
+type Scanner interface {
+	// Name identifies the scanner in logs and scan details
+	Name() string
+	Scan(r io.Reader) (Result, error)
+}
+
+// FromConfig returns the scanners enabled in the configuration. Every
+// upload must pass all of them before it can be downloaded.
+func FromConfig(cfg config.Scanning) []Scanner {
+	var scanners []Scanner
+	if cfg.ClamdAddress != "" {
+		scanners = append(scanners, NewClamdScanner(cfg.ClamdAddress, cfg.ClamdTimeout))
+	}
+	return scanners
+}
+
+// clean is the result of a scan that found nothing
+var clean = Result{Status: models.ScanStatusClean}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+)
+
+// AuditService writes and reads the security audit log
+type AuditService struct {
+	db *gorm.DB
+}
+
+// NewAuditService creates a new audit service
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record appends an entry to the audit log. Failures are logged rather than
+// returned so auditing never blocks the action being audited.
+func (as *AuditService) Record(entry models.AuditLog) {
+	if err := as.db.Create(&entry).Error; err != nil {
+		logrus.WithError(err).WithField("event", entry.Event).Error("Failed to write audit log")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"event":      entry.Event,
+		"user_id":    entry.UserID,
+		"actor_id":   entry.ActorID,
+		"ip_address": entry.IPAddress,
+	}).Warn(entry.Detail)
+}
+
+// ListEntries returns one page of audit log entries, newest first, optionally
+// filtered by event and by the user they are about
+func (as *AuditService) ListEntries(event string, userID uuid.UUID, page, pageSize int) ([]models.AuditLog, int64, error) {
+	query := as.db.Model(&models.AuditLog{})
+	if event != "" {
+		query = query.Where("event = ?", event)
+	}
+	if userID != uuid.Nil {
+		query = query.Where("user_id = ?", userID)
+	}
+	// Count and Find each start from the filters rather than sharing one statement
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []models.AuditLog
+	err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&entries).Error
+	return entries, total, err
+}
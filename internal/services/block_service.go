@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlockService handles users blocking each other
+type BlockService struct {
+	db       *gorm.DB
+	commands *CommandService
+}
+
+// NewBlockService creates a new block service
+func NewBlockService(db *gorm.DB, commandService *CommandService) *BlockService {
+	return &BlockService{
+		db:       db,
+		commands: commandService,
+	}
+}
+
+// notBlocking scopes a user query to users who have not blocked the viewer
+func notBlocking(viewerID uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("users.id NOT IN (SELECT user_id FROM blocks WHERE blocked_id = ?)", viewerID)
+	}
+}
+
+// BlockUser blocks another user. The blocked user can no longer send commands
+// to the user or see them, and their unfinished commands to the user are cancelled.
+// Blocking a user again updates the reason.
+func (bs *BlockService) BlockUser(userID, blockedID uuid.UUID, reason string) (*models.Block, error) {
+	if userID == blockedID {
+		return nil, ErrCannotBlockSelf
+	}
+
+	var blocked models.User
+	if err := bs.db.First(&blocked, "id = ?", blockedID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	block := models.Block{UserID: userID, BlockedID: blockedID, Reason: reason}
+	err := bs.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "blocked_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reason"}),
+	}).Create(&block).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := bs.commands.CancelCommandsFrom(blockedID, userID); err != nil {
+		return nil, err
+	}
+
+	block.Blocked = blocked
+	return &block, nil
+}
+
+// UnblockUser removes a block. Unblocking a user that is not blocked is a no-op.
+func (bs *BlockService) UnblockUser(userID, blockedID uuid.UUID) error {
+	return bs.db.Where("user_id = ? AND blocked_id = ?", userID, blockedID).
+		Delete(&models.Block{}).Error
+}
+
+// GetBlocks returns the users a user has blocked, most recent first
+func (bs *BlockService) GetBlocks(userID uuid.UUID) ([]models.Block, error) {
+	var blocks []models.Block
+	err := bs.db.Where("user_id = ?", userID).
+		Preload("Blocked").
+		Order("created_at DESC").
+		Find(&blocks).Error
+	return blocks, err
+}
@@ -1,44 +1,658 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
+
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"github.com/thecontrolapp/controlme-go/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CommandService struct {
 	db *gorm.DB
+
+	// Whether senders must have verified their email address
+	requireVerifiedSender bool
+}
+
+func NewCommandService(db *gorm.DB, requireVerifiedSender bool) *CommandService {
+	return &CommandService{db: db, requireVerifiedSender: requireVerifiedSender}
+}
+
+// CreateCommandRequest is used for creating a command via REST or WebSocket.
+// Without a receiver the command is broadcast to every subscriber of its tags.
+type CreateCommandRequest struct {
+	Instructions []models.Instruction `json:"instructions" binding:"required,min=1"`
+	Receiver     string               `json:"receiver,omitempty"` // Username or user ID
+	Tags         []string             `json:"tags"`
+}
+
+// unfinishedStatuses are the assignment statuses that can still change
+var unfinishedStatuses = []string{
+	models.CommandStatusAwaitingApproval, models.CommandStatusPending, models.CommandStatusDelivered, models.CommandStatusAcknowledged,
 }
 
-func NewCommandService(db *gorm.DB) *CommandService {
-	return &CommandService{db: db}
+// CreateCommand stores a new pending command from the sender together with
+// one assignment per recipient. Receivers who blocked the sender are treated
+// as unknown users and left out of broadcasts. Recipients' tag preferences are applied: a
+// direct command to a receiver blocking one of its tags fails with
+// ErrCommandBlocked, blocking subscribers are left out of broadcasts, and
+// recipients requiring approval get an assignment awaiting approval.
+// The returned command has its assignments loaded.
+func (cs *CommandService) CreateCommand(senderID uuid.UUID, req CreateCommandRequest) (*models.Command, error) {
+	if len(req.Instructions) == 0 {
+		return nil, ErrNoInstructions
+	}
+	instructions := make([]models.Instruction, len(req.Instructions))
+	var fileHashes []string
+	for i, instruction := range req.Instructions {
+		validated, err := models.ValidateInstruction(instruction)
+		if err != nil {
+			return nil, fmt.Errorf("%w: instructions[%d]: %v", ErrInvalidInstruction, i, err)
+		}
+		if download, ok := validated.Content.(*models.DownloadFileContent); ok {
+			fileHashes = append(fileHashes, download.FileHash)
+		}
+		instructions[i] = validated
+	}
+	fileHashes = uniqueStrings(fileHashes)
+	if err := cs.checkFilesExist(fileHashes); err != nil {
+		return nil, err
+	}
+
+	encodedInstructions, err := json.Marshal(instructions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode instructions: %w", err)
+	}
+
+	tags := uniqueStrings(req.Tags)
+	if err := cs.checkTagsExist(tags); err != nil {
+		return nil, err
+	}
+	encodedTags, err := json.Marshal(tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tags: %w", err)
+	}
+
+	command := models.Command{
+		Instructions: string(encodedInstructions),
+		SenderID:     senderID,
+		Tags:         string(encodedTags),
+		Status:       models.CommandStatusPending,
+	}
+
+	err = cs.db.Transaction(func(tx *gorm.DB) error {
+		var sender models.User
+		if err := tx.First(&sender, "id = ?", senderID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if sender.IsRestricted(time.Now()) {
+			return ErrAccountRestricted
+		}
+		if cs.requireVerifiedSender && !sender.Verified {
+			return ErrEmailNotVerified
+		}
+
+		recipients, err := cs.resolveRecipients(tx, senderID, req.Receiver, tags)
+		if err != nil {
+			return err
+		}
+		preferences, err := strictestTagPreferences(tx, recipients, tags)
+		if err != nil {
+			return err
+		}
+
+		assignments := make([]models.CommandAssignment, 0, len(recipients))
+		for _, userID := range recipients {
+			status := models.CommandStatusPending
+			switch preferences[userID] {
+			case models.TagPreferenceBlock:
+				if req.Receiver != "" {
+					return ErrCommandBlocked
+				}
+				continue
+			case models.TagPreferenceRequireApproval:
+				status = models.CommandStatusAwaitingApproval
+			}
+			assignments = append(assignments, models.CommandAssignment{
+				UserID: userID,
+				Status: status,
+			})
+		}
+
+		if req.Receiver != "" {
+			command.ReceiverID = &recipients[0]
+			command.Status = assignments[0].Status
+		}
+
+		if err := tx.Create(&command).Error; err != nil {
+			return err
+		}
+
+		// The file references keep the files from being swept while the command is unfinished
+		if len(fileHashes) > 0 {
+			files := make([]models.CommandFile, len(fileHashes))
+			for i, hash := range fileHashes {
+				files[i] = models.CommandFile{CommandID: command.ID, FileHash: hash}
+			}
+			if err := tx.Create(&files).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(assignments) == 0 {
+			return nil
+		}
+		for i := range assignments {
+			assignments[i].CommandID = command.ID
+		}
+		return tx.Create(&assignments).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var created models.Command
+	err = cs.db.Where("id = ?", command.ID).
+		Preload("Sender").
+		Preload("Receiver").
+		Preload("Assignments").
+		First(&created).Error
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// resolveRecipients returns the users a new command is assigned to: the
+// receiver for direct commands, or the subscribers of its tags for broadcasts.
+func (cs *CommandService) resolveRecipients(tx *gorm.DB, senderID uuid.UUID, receiver string, tags []string) ([]uuid.UUID, error) {
+	if receiver != "" {
+		user, err := cs.findUser(tx.Scopes(notBlocking(senderID)), receiver)
+		if err != nil {
+			return nil, err
+		}
+		return []uuid.UUID{user.ID}, nil
+	}
+
+	if len(tags) == 0 {
+		return nil, ErrBroadcastWithoutTags
+	}
+
+	var recipients []uuid.UUID
+	err := tx.Model(&models.TagSubscription{}).
+		Joins("JOIN tags ON tags.id = tag_subscriptions.tag_id").
+		Where("tags.name IN ? AND tag_subscriptions.user_id <> ?", tags, senderID).
+		Where("tag_subscriptions.user_id NOT IN (SELECT user_id FROM blocks WHERE blocked_id = ?)", senderID).
+		Distinct().
+		Pluck("tag_subscriptions.user_id", &recipients).Error
+	return recipients, err
+}
+
+// strictestTagPreferences returns, for each of the users that restricts any of
+// the tags, the strictest of their preferences. Block is stricter than require_approval.
+func strictestTagPreferences(tx *gorm.DB, userIDs []uuid.UUID, tags []string) (map[uuid.UUID]string, error) {
+	result := map[uuid.UUID]string{}
+	if len(userIDs) == 0 || len(tags) == 0 {
+		return result, nil
+	}
+
+	var preferences []models.UserTagPreference
+	err := tx.Joins("JOIN tags ON tags.id = user_tag_preferences.tag_id").
+		Where("user_tag_preferences.user_id IN ? AND tags.name IN ? AND user_tag_preferences.preference <> ?",
+			userIDs, tags, models.TagPreferenceAllow).
+		Find(&preferences).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, preference := range preferences {
+		if result[preference.UserID] != models.TagPreferenceBlock {
+			result[preference.UserID] = preference.Preference
+		}
+	}
+	return result, nil
+}
+
+// BlocksCommand reports whether the user currently blocks any of the command's tags
+func (cs *CommandService) BlocksCommand(userID uuid.UUID, command *models.Command) (bool, error) {
+	var tags []string
+	if command.Tags != "" {
+		if err := json.Unmarshal([]byte(command.Tags), &tags); err != nil {
+			return false, fmt.Errorf("failed to decode tags: %w", err)
+		}
+	}
+
+	preferences, err := strictestTagPreferences(cs.db, []uuid.UUID{userID}, tags)
+	if err != nil {
+		return false, err
+	}
+	return preferences[userID] == models.TagPreferenceBlock, nil
+}
+
+// checkTagsExist returns ErrUnknownTag if any of the tag names is not defined
+func (cs *CommandService) checkTagsExist(tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	var count int64
+	if err := cs.db.Model(&models.Tag{}).Where("name IN ?", tags).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) != len(tags) {
+		return ErrUnknownTag
+	}
+	return nil
+}
+
+// checkFilesExist returns ErrUnknownFile if any of the file hashes is not
+// stored or failed a security scan. Files still awaiting a scan are accepted.
+func (cs *CommandService) checkFilesExist(hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	var count int64
+	err := cs.db.Model(&models.FileMetadata{}).
+		Where("hash IN ? AND scan_status <> ?", hashes, models.ScanStatusInfected).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if int(count) != len(hashes) {
+		return ErrUnknownFile
+	}
+	return nil
+}
+
+// uniqueStrings returns the values without duplicates, keeping their order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// findUser resolves a user by ID, login name or screen name
+func (cs *CommandService) findUser(db *gorm.DB, identifier string) (*models.User, error) {
+	// Only one condition may be added: db can be a transaction, whose
+	// statement keeps every condition added to it
+	var user models.User
+	var query *gorm.DB
+	if id, err := uuid.Parse(identifier); err == nil {
+		query = db.Where("users.id = ?", id)
+	} else {
+		query = db.Where("login_name = ? OR screen_name = ?", identifier, identifier)
+	}
+
+	if err := query.First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return &user, nil
+}
+
+// assignmentsInStatus scopes a command query to the user's assignments with the given status
+func assignmentsInStatus(db *gorm.DB, userID uuid.UUID, status string) *gorm.DB {
+	return db.Joins("JOIN command_assignments ON command_assignments.command_id = commands.id").
+		Where("command_assignments.user_id = ? AND command_assignments.status = ?", userID, status)
 }
 
 func (cs *CommandService) GetPendingCommands(userID uuid.UUID) ([]models.Command, error) {
 	var commands []models.Command
-	err := cs.db.Where("receiver_id = ? AND status = ?", userID, "pending").
+	err := assignmentsInStatus(cs.db, userID, models.CommandStatusPending).
 		Preload("Sender").
 		Preload("Receiver").
+		Order("commands.created_at ASC").
 		Find(&commands).Error
 	return commands, err
 }
 
 func (cs *CommandService) GetPendingCommandCount(userID uuid.UUID) (int64, error) {
 	var count int64
-	err := cs.db.Model(&models.Command{}).Where("receiver_id = ? AND status = ?", userID, "pending").Count(&count).Error
+	err := assignmentsInStatus(cs.db.Model(&models.Command{}), userID, models.CommandStatusPending).Count(&count).Error
 	return count, err
 }
 
+// GetAwaitingApproval returns the commands held for the user's approval, oldest first
+func (cs *CommandService) GetAwaitingApproval(userID uuid.UUID) ([]models.Command, error) {
+	var commands []models.Command
+	err := assignmentsInStatus(cs.db, userID, models.CommandStatusAwaitingApproval).
+		Preload("Sender").
+		Preload("Receiver").
+		Order("commands.created_at ASC").
+		Find(&commands).Error
+	return commands, err
+}
+
+// ApproveCommand releases a command held for the user's approval for delivery
+func (cs *CommandService) ApproveCommand(commandID uuid.UUID, userID uuid.UUID) error {
+	return cs.updateAssignment(commandID, userID, models.CommandStatusPending, "", &userID, "approved by receiver")
+}
+
+// DeclineCommand refuses a command held for the user's approval
+func (cs *CommandService) DeclineCommand(commandID uuid.UUID, userID uuid.UUID) error {
+	return cs.updateAssignment(commandID, userID, models.CommandStatusDeclined, "", &userID, "declined by receiver")
+}
+
+// DeclineBlockedCommand declines a pending command on behalf of a user whose
+// tag preferences changed to block it after it was sent
+func (cs *CommandService) DeclineBlockedCommand(commandID uuid.UUID, userID uuid.UUID) error {
+	return cs.updateAssignment(commandID, userID, models.CommandStatusDeclined, "", nil, "blocked by tag preference")
+}
+
+// CompleteCommand marks a command as completed by its receiver
 func (cs *CommandService) CompleteCommand(commandID uuid.UUID, userID uuid.UUID) error {
-	result := cs.db.Model(&models.Command{}).Where("id = ? AND receiver_id = ?", commandID, userID).Update("status", "completed")
-	if result.Error != nil {
-		return result.Error
+	return cs.UpdateCommandStatus(commandID, userID, models.CommandStatusCompleted)
+}
+
+// ReceivePendingCommands returns the user's pending commands and marks them
+// delivered, for clients that poll over REST instead of confirming receipt over
+// a WebSocket connection. Received commands are not returned again.
+func (cs *CommandService) ReceivePendingCommands(userID uuid.UUID) ([]models.Command, error) {
+	commands, err := cs.GetPendingCommands(userID)
+	if err != nil {
+		return nil, err
 	}
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("command not found")
+
+	received := commands[:0]
+	for _, command := range commands {
+		err := cs.MarkDelivered(command.ID, userID)
+		var transitionErr *InvalidTransitionError
+		if errors.As(err, &transitionErr) {
+			// Cancelled or expired since it was loaded
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if command.ReceiverID != nil {
+			command.Status = models.CommandStatusDelivered
+		}
+		received = append(received, command)
 	}
-	return nil
+	return received, nil
+}
+
+// MarkDelivered marks a pending command as delivered once the receiver's client has received it
+func (cs *CommandService) MarkDelivered(commandID uuid.UUID, userID uuid.UUID) error {
+	return cs.UpdateCommandStatus(commandID, userID, models.CommandStatusDelivered)
+}
+
+// UpdateCommandStatus applies a status change reported by one of the command's
+// recipients to their assignment. Direct commands mirror the status of their only recipient.
+func (cs *CommandService) UpdateCommandStatus(commandID uuid.UUID, userID uuid.UUID, status string) error {
+	timestampColumn := ""
+	switch status {
+	case models.CommandStatusDelivered:
+		timestampColumn = "delivered_at"
+	case models.CommandStatusAcknowledged:
+		timestampColumn = "acknowledged_at"
+	case models.CommandStatusCompleted, models.CommandStatusFailed:
+		timestampColumn = "completed_at"
+	default:
+		return ErrInvalidCommandStatus
+	}
+
+	return cs.updateAssignment(commandID, userID, status, timestampColumn, &userID, "")
+}
+
+// CancelCommandsFrom cancels the user's unfinished assignments of commands sent
+// by the sender, such as when the user blocks them. It returns how many were cancelled.
+func (cs *CommandService) CancelCommandsFrom(senderID uuid.UUID, userID uuid.UUID) (int, error) {
+	var commandIDs []uuid.UUID
+	err := cs.db.Model(&models.CommandAssignment{}).
+		Joins("JOIN commands ON commands.id = command_assignments.command_id").
+		Where("commands.sender_id = ? AND command_assignments.user_id = ? AND command_assignments.status IN ?",
+			senderID, userID, unfinishedStatuses).
+		Pluck("command_assignments.command_id", &commandIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	cancelled := 0
+	for _, commandID := range commandIDs {
+		err := cs.updateAssignment(commandID, userID, models.CommandStatusCancelled, "", &userID, "sender blocked")
+		var transitionErr *InvalidTransitionError
+		if errors.As(err, &transitionErr) {
+			// Finished between the query and the update
+			continue
+		}
+		if err != nil {
+			return cancelled, err
+		}
+		cancelled++
+	}
+	return cancelled, nil
+}
+
+// updateAssignment moves one recipient's assignment to a new status, sets the
+// timestamp column if one is given, and records the change in the command_events history
+func (cs *CommandService) updateAssignment(commandID uuid.UUID, userID uuid.UUID, status, timestampColumn string, actorID *uuid.UUID, note string) error {
+	return cs.db.Transaction(func(tx *gorm.DB) error {
+		var assignment models.CommandAssignment
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("command_id = ? AND user_id = ?", commandID, userID).
+			First(&assignment).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCommandNotFound
+			}
+			return err
+		}
+
+		from := assignment.Status
+		if !models.CanTransitionCommand(from, status) {
+			return &InvalidTransitionError{From: from, To: status}
+		}
+
+		updates := map[string]interface{}{"status": status}
+		if timestampColumn != "" {
+			updates[timestampColumn] = time.Now()
+		}
+		if err := tx.Model(&assignment).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if err := syncCommandStatus(tx, commandID); err != nil {
+			return err
+		}
+
+		return tx.Create(&models.CommandEvent{
+			CommandID:  commandID,
+			UserID:     &userID,
+			FromStatus: from,
+			ToStatus:   status,
+			ActorID:    actorID,
+			Note:       note,
+		}).Error
+	})
+}
+
+// finishedBroadcastStatuses are the statuses a broadcast can finish in, by
+// precedence: the first one any recipient finished in becomes the broadcast's
+var finishedBroadcastStatuses = []string{
+	models.CommandStatusCompleted, models.CommandStatusFailed, models.CommandStatusDeclined, models.CommandStatusExpired, models.CommandStatusCancelled,
+}
+
+// syncCommandStatus derives a command's status from its assignments. A command
+// whose recipients all share a status takes that status, so direct commands
+// mirror their only recipient. A broadcast whose recipients differ keeps its
+// status while any of them is unfinished, and otherwise takes the
+// finishedBroadcastStatuses entry of highest precedence among them.
+func syncCommandStatus(tx *gorm.DB, commandID uuid.UUID) error {
+	var statuses []string
+	err := tx.Model(&models.CommandAssignment{}).
+		Where("command_id = ?", commandID).
+		Distinct("status").
+		Pluck("status", &statuses).Error
+	if err != nil || len(statuses) == 0 {
+		return err
+	}
+
+	status := statuses[0]
+	if len(statuses) > 1 {
+		present := make(map[string]bool, len(statuses))
+		for _, s := range statuses {
+			present[s] = true
+		}
+		for _, unfinished := range unfinishedStatuses {
+			if present[unfinished] {
+				return nil
+			}
+		}
+		for _, finished := range finishedBroadcastStatuses {
+			if present[finished] {
+				status = finished
+				break
+			}
+		}
+	}
+
+	return tx.Model(&models.Command{}).
+		Where("id = ?", commandID).
+		Update("status", status).Error
+}
+
+// CancelCommand withdraws a command for every recipient that has not finished it
+func (cs *CommandService) CancelCommand(commandID uuid.UUID, actorID *uuid.UUID, note string) error {
+	return cs.transitionCommand(commandID, models.CommandStatusCancelled, actorID, note)
+}
+
+// CancelSentCommand withdraws a command on behalf of its sender. Commands the
+// user did not send are reported as ErrCommandNotFound.
+func (cs *CommandService) CancelSentCommand(commandID uuid.UUID, senderID uuid.UUID) error {
+	var count int64
+	err := cs.db.Model(&models.Command{}).
+		Where("id = ? AND sender_id = ?", commandID, senderID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrCommandNotFound
+	}
+	return cs.CancelCommand(commandID, &senderID, "cancelled by sender")
+}
+
+// ExpireCommands expires every command created before the cutoff that a
+// recipient has not finished, or that has no recipients and is unfinished itself
+func (cs *CommandService) ExpireCommands(cutoff time.Time) (int, error) {
+	var ids []uuid.UUID
+	err := cs.db.Model(&models.Command{}).
+		Where("created_at < ?", cutoff).
+		Where("(EXISTS (SELECT 1 FROM command_assignments WHERE command_assignments.command_id = commands.id AND command_assignments.status IN ?)"+
+			" OR (status IN ? AND NOT EXISTS (SELECT 1 FROM command_assignments WHERE command_assignments.command_id = commands.id)))",
+			unfinishedStatuses, unfinishedStatuses).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, id := range ids {
+		err := cs.transitionCommand(id, models.CommandStatusExpired, nil, "retention window passed")
+		var transitionErr *InvalidTransitionError
+		if errors.As(err, &transitionErr) {
+			// Finished between the query and the update
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// ExpireStaleCommands expires commands still unfinished maxAge after they
+// were sent, once and then every interval. It returns when ctx is cancelled.
+func (cs *CommandService) ExpireStaleCommands(ctx context.Context, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := cs.ExpireCommands(time.Now().Add(-maxAge))
+		if err != nil {
+			logrus.WithError(err).Error("Failed to expire commands")
+		} else if expired > 0 {
+			logrus.WithField("commands", expired).Info("Expired unfinished commands")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// transitionCommand moves every assignment that has not finished yet to a new
+// status, derives the command's status from its assignments and records the
+// change in the command_events history. A command with no unfinished
+// assignment left cannot be moved, unless it has no recipients at all.
+func (cs *CommandService) transitionCommand(commandID uuid.UUID, to string, actorID *uuid.UUID, note string) error {
+	return cs.db.Transaction(func(tx *gorm.DB) error {
+		var command models.Command
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", commandID).
+			First(&command).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCommandNotFound
+			}
+			return err
+		}
+
+		from := command.Status
+		result := tx.Model(&models.CommandAssignment{}).
+			Where("command_id = ? AND status IN ?", commandID, unfinishedStatuses).
+			Update("status", to)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected > 0 {
+			err = syncCommandStatus(tx, commandID)
+		} else {
+			var assignments int64
+			if err := tx.Model(&models.CommandAssignment{}).Where("command_id = ?", commandID).Count(&assignments).Error; err != nil {
+				return err
+			}
+			if assignments > 0 || !models.CanTransitionCommand(from, to) {
+				return &InvalidTransitionError{From: from, To: to}
+			}
+			err = tx.Model(&command).Update("status", to).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.CommandEvent{
+			CommandID:  command.ID,
+			FromStatus: from,
+			ToStatus:   to,
+			ActorID:    actorID,
+			Note:       note,
+		}).Error
+	})
 }
 
 // GetCommandByID gets a command by ID with relationships loaded
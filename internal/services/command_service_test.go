@@ -0,0 +1,230 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// createTestUser creates a user with the login name and "The <name>" as screen name
+func createTestUser(t *testing.T, db *gorm.DB, name string) models.User {
+	t.Helper()
+	user := models.User{
+		ScreenName: "The " + name,
+		LoginName:  name,
+		Email:      name + "@example.com",
+		Password:   "unused",
+		Role:       models.RoleUser,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+// popup returns the instructions of a command showing a message
+func popup(body string) []models.Instruction {
+	return []models.Instruction{{Type: "popup-msg", Content: &models.PopupMsgContent{Body: body}}}
+}
+
+func TestCreateCommandFindsReceiver(t *testing.T) {
+	db := testdb.Open(t)
+	cs := NewCommandService(db, false)
+	sender := createTestUser(t, db, "sender")
+	receiver := createTestUser(t, db, "receiver")
+	blocker := createTestUser(t, db, "blocker")
+	if err := db.Create(&models.Block{UserID: blocker.ID, BlockedID: sender.ID}).Error; err != nil {
+		t.Fatalf("failed to create block: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		receiver string
+		want     uuid.UUID
+		wantErr  error
+	}{
+		{"id", receiver.ID.String(), receiver.ID, nil},
+		{"login name", "receiver", receiver.ID, nil},
+		{"screen name", "The receiver", receiver.ID, nil},
+		{"unknown name", "nobody", uuid.Nil, ErrUserNotFound},
+		{"unknown id", uuid.NewString(), uuid.Nil, ErrUserNotFound},
+		{"blocking receiver by id", blocker.ID.String(), uuid.Nil, ErrUserNotFound},
+		{"blocking receiver by name", "blocker", uuid.Nil, ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, err := cs.CreateCommand(sender.ID, CreateCommandRequest{
+				Instructions: popup("hello"),
+				Receiver:     tt.receiver,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CreateCommand() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateCommand() error = %v", err)
+			}
+			if command.ReceiverID == nil || *command.ReceiverID != tt.want {
+				t.Errorf("ReceiverID = %v, want %s", command.ReceiverID, tt.want)
+			}
+		})
+	}
+}
+
+// broadcastTest has a broadcast from the sender to two subscribers of a tag
+type broadcastTest struct {
+	t          *testing.T
+	db         *gorm.DB
+	cs         *CommandService
+	sender     uuid.UUID
+	recipients []uuid.UUID
+	command    *models.Command
+}
+
+func newBroadcastTest(t *testing.T) *broadcastTest {
+	t.Helper()
+	db := testdb.Open(t)
+	bt := &broadcastTest{t: t, db: db, cs: NewCommandService(db, false)}
+	bt.sender = createTestUser(t, db, "sender").ID
+	tag := models.Tag{Name: "general"}
+	if err := db.Create(&tag).Error; err != nil {
+		t.Fatalf("failed to create tag: %v", err)
+	}
+	for _, name := range []string{"first", "second"} {
+		user := createTestUser(t, db, name)
+		if err := db.Create(&models.TagSubscription{UserID: user.ID, TagID: tag.ID}).Error; err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		bt.recipients = append(bt.recipients, user.ID)
+	}
+
+	command, err := bt.cs.CreateCommand(bt.sender, CreateCommandRequest{Instructions: popup("hello"), Tags: []string{"general"}})
+	if err != nil {
+		t.Fatalf("CreateCommand() error = %v", err)
+	}
+	bt.command = command
+	return bt
+}
+
+// finish has the recipient receive the broadcast and report the status
+func (bt *broadcastTest) finish(recipient uuid.UUID, status string) {
+	bt.t.Helper()
+	if err := bt.cs.MarkDelivered(bt.command.ID, recipient); err != nil {
+		bt.t.Fatalf("MarkDelivered() error = %v", err)
+	}
+	if err := bt.cs.UpdateCommandStatus(bt.command.ID, recipient, status); err != nil {
+		bt.t.Fatalf("UpdateCommandStatus() error = %v", err)
+	}
+}
+
+// statuses returns the status of the command and of each recipient's assignment
+func (bt *broadcastTest) statuses() (string, []string) {
+	bt.t.Helper()
+	var command models.Command
+	if err := bt.db.First(&command, "id = ?", bt.command.ID).Error; err != nil {
+		bt.t.Fatalf("failed to load command: %v", err)
+	}
+	var assignments []string
+	for _, recipient := range bt.recipients {
+		var assignment models.CommandAssignment
+		err := bt.db.First(&assignment, "command_id = ? AND user_id = ?", bt.command.ID, recipient).Error
+		if err != nil {
+			bt.t.Fatalf("failed to load assignment: %v", err)
+		}
+		assignments = append(assignments, assignment.Status)
+	}
+	return command.Status, assignments
+}
+
+func TestBroadcastStatusFollowsRecipients(t *testing.T) {
+	bt := newBroadcastTest(t)
+
+	bt.finish(bt.recipients[0], models.CommandStatusCompleted)
+	if status, _ := bt.statuses(); status != models.CommandStatusPending {
+		t.Errorf("status with one recipient left = %s, want pending", status)
+	}
+
+	bt.finish(bt.recipients[1], models.CommandStatusFailed)
+	if status, _ := bt.statuses(); status != models.CommandStatusCompleted {
+		t.Errorf("status after every recipient finished = %s, want completed", status)
+	}
+
+	if expired, err := bt.cs.ExpireCommands(time.Now().Add(time.Hour)); err != nil || expired != 0 {
+		t.Errorf("ExpireCommands() = %d, %v, want 0", expired, err)
+	}
+	var transitionErr *InvalidTransitionError
+	if err := bt.cs.CancelSentCommand(bt.command.ID, bt.sender); !errors.As(err, &transitionErr) {
+		t.Errorf("CancelSentCommand() error = %v, want an InvalidTransitionError", err)
+	}
+	status, assignments := bt.statuses()
+	if status != models.CommandStatusCompleted || assignments[0] != models.CommandStatusCompleted || assignments[1] != models.CommandStatusFailed {
+		t.Errorf("finished broadcast changed to %s with assignments %v", status, assignments)
+	}
+}
+
+func TestBroadcastExpiryAndCancelSkipFinishedRecipients(t *testing.T) {
+	tests := []struct {
+		name       string
+		transition func(bt *broadcastTest) error
+		want       string
+	}{
+		{"expire", func(bt *broadcastTest) error {
+			expired, err := bt.cs.ExpireCommands(time.Now().Add(time.Hour))
+			if err == nil && expired != 1 {
+				bt.t.Errorf("ExpireCommands() = %d, want 1", expired)
+			}
+			return err
+		}, models.CommandStatusExpired},
+		{"cancel", func(bt *broadcastTest) error {
+			return bt.cs.CancelSentCommand(bt.command.ID, bt.sender)
+		}, models.CommandStatusCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bt := newBroadcastTest(t)
+			bt.finish(bt.recipients[0], models.CommandStatusFailed)
+
+			if err := tt.transition(bt); err != nil {
+				t.Fatalf("transition error = %v", err)
+			}
+			status, assignments := bt.statuses()
+			if assignments[0] != models.CommandStatusFailed || assignments[1] != tt.want {
+				t.Errorf("assignments = %v, want [failed %s]", assignments, tt.want)
+			}
+			if status != models.CommandStatusFailed {
+				t.Errorf("status = %s, want failed", status)
+			}
+		})
+	}
+}
+
+func TestBroadcastWithoutRecipientsExpires(t *testing.T) {
+	db := testdb.Open(t)
+	cs := NewCommandService(db, false)
+	sender := createTestUser(t, db, "sender")
+	if err := db.Create(&models.Tag{Name: "general"}).Error; err != nil {
+		t.Fatalf("failed to create tag: %v", err)
+	}
+	command, err := cs.CreateCommand(sender.ID, CreateCommandRequest{Instructions: popup("hello"), Tags: []string{"general"}})
+	if err != nil {
+		t.Fatalf("CreateCommand() error = %v", err)
+	}
+
+	if expired, err := cs.ExpireCommands(time.Now().Add(time.Hour)); err != nil || expired != 1 {
+		t.Fatalf("ExpireCommands() = %d, %v, want 1", expired, err)
+	}
+	var stored models.Command
+	if err := db.First(&stored, "id = ?", command.ID).Error; err != nil {
+		t.Fatalf("failed to load command: %v", err)
+	}
+	if stored.Status != models.CommandStatusExpired {
+		t.Errorf("status = %s, want expired", stored.Status)
+	}
+}
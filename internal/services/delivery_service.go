@@ -0,0 +1,132 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/websocket"
+)
+
+// DeliveryService pushes commands to connected clients through the WebSocket hub
+type DeliveryService struct {
+	commands *CommandService
+	hub      *websocket.Hub
+}
+
+// NewDeliveryService creates a new delivery service
+func NewDeliveryService(commandService *CommandService, hub *websocket.Hub) *DeliveryService {
+	return &DeliveryService{
+		commands: commandService,
+		hub:      hub,
+	}
+}
+
+// CommandPayload is the data of a "command" message sent to clients
+type CommandPayload struct {
+	ID           uuid.UUID       `json:"id"`
+	Instructions json.RawMessage `json:"instructions"`
+	Sender       string          `json:"sender"`
+	Receiver     string          `json:"receiver,omitempty"`
+	Tags         json.RawMessage `json:"tags,omitempty"`
+	Status       string          `json:"status"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// DeliverPending replays every pending command for a user in creation order.
+// Commands stay pending until the client acknowledges them, so a replay that
+// is interrupted by a disconnect is simply repeated on the next connection.
+func (ds *DeliveryService) DeliverPending(userID uuid.UUID) {
+	commands, err := ds.commands.GetPendingCommands(userID)
+	if err != nil {
+		logrus.WithError(err).WithField("user_id", userID).Error("Failed to load pending commands")
+		return
+	}
+
+	delivered := 0
+	for i := range commands {
+		blocked, err := ds.commands.BlocksCommand(userID, &commands[i])
+		if err != nil {
+			logrus.WithError(err).WithField("command_id", commands[i].ID).Error("Failed to check tag preferences")
+			continue
+		}
+		if blocked {
+			// The user blocked one of its tags after it was sent
+			if err := ds.commands.DeclineBlockedCommand(commands[i].ID, userID); err != nil {
+				logrus.WithError(err).WithField("command_id", commands[i].ID).Error("Failed to decline blocked command")
+			}
+			continue
+		}
+		ds.hub.SendToUser(userID, commandMessage(&commands[i]))
+		delivered++
+	}
+
+	if delivered > 0 {
+		logrus.WithFields(logrus.Fields{
+			"user_id":  userID,
+			"commands": delivered,
+		}).Info("Replayed pending commands")
+	}
+}
+
+// Deliver pushes a new command to every recipient that is online. Recipients
+// whose assignment awaits approval get an "approval_required" message instead.
+// The command must have its assignments loaded, as returned by CreateCommand.
+// Offline recipients get it from DeliverPending when they reconnect.
+func (ds *DeliveryService) Deliver(command *models.Command) {
+	message := commandMessage(command)
+	for _, assignment := range command.Assignments {
+		if !ds.hub.IsUserConnected(assignment.UserID) {
+			continue
+		}
+		switch assignment.Status {
+		case models.CommandStatusPending:
+			ds.hub.SendToUser(assignment.UserID, message)
+		case models.CommandStatusAwaitingApproval:
+			approval := message
+			approval.Type = websocket.MessageTypeApprovalRequired
+			ds.hub.SendToUser(assignment.UserID, approval)
+		}
+	}
+}
+
+// DeliverTo pushes a command to one user if they are online, such as after
+// they approved it
+func (ds *DeliveryService) DeliverTo(userID uuid.UUID, command *models.Command) {
+	if ds.hub.IsUserConnected(userID) {
+		ds.hub.SendToUser(userID, commandMessage(command))
+	}
+}
+
+// commandMessage builds the "command" message for a command
+func commandMessage(command *models.Command) websocket.Message {
+	payload := CommandPayload{
+		ID:           command.ID,
+		Instructions: rawJSON(command.Instructions, "[]"),
+		Sender:       command.Sender.ScreenName,
+		Tags:         rawJSON(command.Tags, "[]"),
+		Status:       command.Status,
+		CreatedAt:    command.CreatedAt,
+	}
+	if command.Receiver != nil {
+		payload.Receiver = command.Receiver.ScreenName
+	}
+
+	return websocket.Message{
+		Type:      websocket.MessageTypeCommand,
+		ID:        command.ID.String(),
+		Timestamp: time.Now(),
+		From:      command.SenderID,
+		Data:      payload,
+	}
+}
+
+// rawJSON returns a stored JSON column as raw JSON, falling back when it is empty or invalid
+func rawJSON(value, fallback string) json.RawMessage {
+	if value == "" || !json.Valid([]byte(value)) {
+		return json.RawMessage(fallback)
+	}
+	return json.RawMessage(value)
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/websocket"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// How long a pairing code can be approved and claimed
+	pairingTTL = 10 * time.Minute
+
+	// Seconds a device should wait between polls for its pairing
+	pairingPollInterval = 5
+
+	// Letters used in user codes, without vowels and look-alike characters
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+	// Number of user code letters, shown as two groups of four
+	userCodeLength = 8
+)
+
+// DeviceService pairs desktop clients with accounts and manages their device credentials
+type DeviceService struct {
+	db  *gorm.DB
+	hub *websocket.Hub
+}
+
+// NewDeviceService creates a new device service
+func NewDeviceService(db *gorm.DB, hub *websocket.Hub) *DeviceService {
+	return &DeviceService{
+		db:  db,
+		hub: hub,
+	}
+}
+
+// PairingCodes are handed to a device that starts pairing. The device shows
+// the user code and keeps the device code secret to poll with.
+type PairingCodes struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresIn  int // Seconds until the codes expire
+	Interval   int // Seconds to wait between polls
+}
+
+// DeviceKey is a newly issued device credential and its key, which is only available once
+type DeviceKey struct {
+	Credential models.DeviceCredential
+	Key        string
+}
+
+// StartPairing creates a pairing request for a device
+func (ds *DeviceService) StartPairing(deviceName string) (*PairingCodes, error) {
+	now := time.Now()
+	if err := ds.db.Where("expires_at < ?", now).Delete(&models.DevicePairing{}).Error; err != nil {
+		return nil, err
+	}
+
+	deviceCode, deviceCodeHash, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// 20^8 user codes make a collision with a pairing in progress negligible;
+	// the unique index rejects one should it happen
+	userCode, err := newUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	pairing := models.DevicePairing{
+		DeviceCodeHash: deviceCodeHash,
+		UserCode:       userCode,
+		DeviceName:     deviceName,
+		Status:         models.PairingStatusPending,
+		ExpiresAt:      now.Add(pairingTTL),
+	}
+	if err := ds.db.Create(&pairing).Error; err != nil {
+		return nil, err
+	}
+
+	return &PairingCodes{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ExpiresIn:  int(pairingTTL.Seconds()),
+		Interval:   pairingPollInterval,
+	}, nil
+}
+
+// newUserCode generates a random user code such as "BCDF-GHJK"
+func newUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	code := make([]byte, 0, userCodeLength+1)
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code = append(code, userCodeAlphabet[n.Int64()])
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode accepts a user code typed in any case, with or without the dash
+func normalizeUserCode(userCode string) string {
+	code := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(userCode))
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// ApprovePairing lets the device showing the user code connect to the user's
+// account with the given scope. An empty name keeps the name the device sent.
+func (ds *DeviceService) ApprovePairing(userID uuid.UUID, userCode, name, scope string) error {
+	if scope == "" {
+		scope = models.DeviceScopeReceive
+	}
+	if !models.IsValidDeviceScope(scope) {
+		return ErrInvalidDeviceScope
+	}
+
+	updates := map[string]interface{}{
+		"status":  models.PairingStatusApproved,
+		"user_id": userID,
+		"scope":   scope,
+	}
+	if name != "" {
+		updates["device_name"] = name
+	}
+	return ds.answerPairing(userCode, updates)
+}
+
+// DenyPairing refuses the pairing of the device showing the user code
+func (ds *DeviceService) DenyPairing(userID uuid.UUID, userCode string) error {
+	return ds.answerPairing(userCode, map[string]interface{}{
+		"status":  models.PairingStatusDenied,
+		"user_id": userID,
+	})
+}
+
+// answerPairing applies an approval or denial to a pending, unexpired pairing
+func (ds *DeviceService) answerPairing(userCode string, updates map[string]interface{}) error {
+	result := ds.db.Model(&models.DevicePairing{}).
+		Where("user_code = ? AND status = ? AND expires_at > ?", normalizeUserCode(userCode), models.PairingStatusPending, time.Now()).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPairingNotFound
+	}
+	return nil
+}
+
+// ClaimPairing is polled by a device with its device code. Once the pairing is
+// approved it issues the device credential and ends the pairing, so the key is
+// handed out exactly once.
+func (ds *DeviceService) ClaimPairing(deviceCode string) (*DeviceKey, error) {
+	var issued *DeviceKey
+	// Expired and denied pairings are deleted, so their outcome is reported
+	// after the transaction commits
+	var outcome error
+	err := ds.db.Transaction(func(tx *gorm.DB) error {
+		var pairing models.DevicePairing
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("device_code_hash = ?", auth.HashToken(deviceCode)).
+			First(&pairing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPairingNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case time.Now().After(pairing.ExpiresAt):
+			outcome = ErrPairingExpired
+			return tx.Delete(&pairing).Error
+		case pairing.Status == models.PairingStatusPending:
+			return ErrPairingPending
+		case pairing.Status == models.PairingStatusDenied:
+			outcome = ErrPairingDenied
+			return tx.Delete(&pairing).Error
+		}
+
+		key, keyHash, err := auth.NewDeviceKey()
+		if err != nil {
+			return err
+		}
+		credential := models.DeviceCredential{
+			UserID:    *pairing.UserID,
+			Name:      pairing.DeviceName,
+			KeyHash:   keyHash,
+			KeyPrefix: key[:len(auth.DeviceKeyPrefix)+6],
+			Scope:     pairing.Scope,
+		}
+		if err := tx.Create(&credential).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&pairing).Error; err != nil {
+			return err
+		}
+
+		issued = &DeviceKey{Credential: credential, Key: key}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if outcome != nil {
+		return nil, outcome
+	}
+	return issued, nil
+}
+
+// Authenticate returns the active credential for a device key and records its use
+func (ds *DeviceService) Authenticate(key string) (*models.DeviceCredential, error) {
+	var credential models.DeviceCredential
+	err := ds.db.Preload("User").
+		Where("key_hash = ? AND revoked_at IS NULL", auth.HashToken(key)).
+		First(&credential).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidDeviceKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if credential.User.IsRestricted(now) {
+		return nil, ErrAccountRestricted
+	}
+
+	if err := ds.db.Model(&credential).Update("last_used_at", now).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// GetDevices returns the user's device credentials that have not been revoked, newest first
+func (ds *DeviceService) GetDevices(userID uuid.UUID) ([]models.DeviceCredential, error) {
+	var devices []models.DeviceCredential
+	err := ds.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&devices).Error
+	return devices, err
+}
+
+// RevokeDevice revokes one of the user's device credentials and disconnects the device
+func (ds *DeviceService) RevokeDevice(userID, deviceID uuid.UUID) error {
+	result := ds.db.Model(&models.DeviceCredential{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", deviceID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDeviceNotFound
+	}
+
+	ds.hub.DisconnectDevice(deviceID)
+	return nil
+}
+
+// RevokeAllDevices revokes every device credential of the user and disconnects the devices
+func (ds *DeviceService) RevokeAllDevices(userID uuid.UUID) error {
+	var ids []uuid.UUID
+	err := ds.db.Model(&models.DeviceCredential{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	err = ds.db.Model(&models.DeviceCredential{}).
+		Where("id IN ?", ids).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		ds.hub.DisconnectDevice(id)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCommandNotFound is returned when a command does not exist or is not visible to the caller
+var ErrCommandNotFound = errors.New("command not found")
+
+// ErrUserNotFound is returned when a referenced user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrCannotBlockSelf is returned when a user tries to block themselves
+var ErrCannotBlockSelf = errors.New("cannot block yourself")
+
+// ErrAccountRestricted is returned when a suspended or banned user tries to sign in or send commands
+var ErrAccountRestricted = errors.New("account is suspended or banned")
+
+// ErrInvalidRole is returned for a role that is not user, moderator or admin
+var ErrInvalidRole = errors.New("invalid role")
+
+// ErrLastAdmin is returned when a role change would leave no admin
+var ErrLastAdmin = errors.New("cannot remove the last admin")
+
+// ErrInvalidRefreshToken is returned for a refresh token that is unknown, expired, revoked or already used
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// ErrSessionNotFound is returned when a session does not exist, belongs to another user or is already revoked
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrPairingNotFound is returned for a device or user code that matches no pairing awaiting an answer
+var ErrPairingNotFound = errors.New("device pairing not found")
+
+// ErrPairingPending is returned when a device polls for a pairing nobody has approved yet
+var ErrPairingPending = errors.New("device pairing is awaiting approval")
+
+// ErrPairingDenied is returned when a device polls for a pairing the user denied
+var ErrPairingDenied = errors.New("device pairing was denied")
+
+// ErrPairingExpired is returned when a device polls for a pairing after its code expired
+var ErrPairingExpired = errors.New("device pairing code expired")
+
+// ErrInvalidDeviceScope is returned for a device credential scope that is not receive or send
+var ErrInvalidDeviceScope = errors.New("invalid device scope")
+
+// ErrInvalidDeviceKey is returned for a device key that is unknown or revoked
+var ErrInvalidDeviceKey = errors.New("invalid device key")
+
+// ErrDeviceNotFound is returned when a device credential does not exist, belongs to another user or is already revoked
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrEmailNotVerified is returned when an unverified user sends a command while verification is required
+var ErrEmailNotVerified = errors.New("email address is not verified")
+
+// ErrAlreadyVerified is returned when verifying an email address that is already verified
+var ErrAlreadyVerified = errors.New("email address is already verified")
+
+// ErrInvalidVerificationCode is returned for a verification code that does not match
+var ErrInvalidVerificationCode = errors.New("invalid verification code")
+
+// ErrVerificationExpired is returned when the verification code expired, was never sent or had too many wrong guesses
+var ErrVerificationExpired = errors.New("verification code expired")
+
+// ErrInvalidResetToken is returned for a password reset token that is unknown, expired or already used
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+// ErrInvalidPassword is returned when the current password given to change it is wrong
+var ErrInvalidPassword = errors.New("invalid password")
+
+// ErrInvalidCredentials is returned when a login name is unknown or the password is wrong
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrTwoFactorEnabled is returned when enrolling a user who already has two-factor authentication
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+
+// ErrTwoFactorNotSetUp is returned when enabling two-factor authentication before setting it up
+var ErrTwoFactorNotSetUp = errors.New("two-factor authentication is not set up")
+
+// ErrTwoFactorNotEnabled is returned when a user without two-factor authentication tries to change it
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+
+// ErrInvalidTwoFactorCode is returned for an authenticator or recovery code that is wrong or already used
+var ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+// ErrInvalidLoginChallenge is returned for a login challenge token that is unknown, expired or used up
+var ErrInvalidLoginChallenge = errors.New("invalid login challenge")
+
+// ErrFileNotFound is returned for a file hash that is not stored
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrFileTooLarge is returned for an upload larger than the configured limit
+var ErrFileTooLarge = errors.New("file too large")
+
+// ErrInvalidFileName is returned for an upload without a usable file name
+var ErrInvalidFileName = errors.New("invalid file name")
+
+// ErrFileQuarantined is returned when downloading a file that has not passed every scanner yet
+var ErrFileQuarantined = errors.New("file is awaiting a security scan")
+
+// ErrFileInfected is returned when downloading a file a scanner flagged
+var ErrFileInfected = errors.New("file failed a security scan")
+
+// ErrFileBlocked is returned for an upload that matched the blocklist
+var ErrFileBlocked = errors.New("file matches the blocklist")
+
+// ErrBlocklistDisabled is returned when reloading the blocklist while none is configured
+var ErrBlocklistDisabled = errors.New("no blocklist is configured")
+
+// ErrUploadNotFound is returned for a resumable upload that does not exist, has expired or belongs to another user
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrUploadOffsetMismatch is returned when a chunk does not start at the offset of its upload
+var ErrUploadOffsetMismatch = errors.New("chunk offset does not match the upload offset")
+
+// ErrUploadBusy is returned when a chunk arrives while another chunk of the same upload is being written
+var ErrUploadBusy = errors.New("another chunk of the upload is being written")
+
+// ErrUploadHashMismatch is returned when a finished upload does not have the hash it was created with
+var ErrUploadHashMismatch = errors.New("uploaded file does not match its hash")
+
+// ErrInvalidUploadHash is returned when an upload is created without a hex SHA-256 hash
+var ErrInvalidUploadHash = errors.New("invalid upload hash")
+
+// ErrInvalidUploadLength is returned when an upload is created with a negative length
+var ErrInvalidUploadLength = errors.New("invalid upload length")
+
+// ErrUploadChunkTooLong is returned when a chunk runs past the length of its upload
+var ErrUploadChunkTooLong = errors.New("chunk is longer than the rest of the upload")
+
+// ErrTooManyUploads is returned when a user starts an upload while at their limit of unfinished uploads
+var ErrTooManyUploads = errors.New("too many unfinished uploads")
+
+// ErrNoReviewHold is returned when releasing a user who is not held for review
+var ErrNoReviewHold = errors.New("user is not held for review")
+
+// ErrUnknownFile is returned when a command references a file hash that is not stored
+var ErrUnknownFile = errors.New("unknown file")
+
+// ErrCannotReportSelf is returned when a user tries to report themselves
+var ErrCannotReportSelf = errors.New("cannot report yourself")
+
+// ErrReportNotFound is returned when a report does not exist
+var ErrReportNotFound = errors.New("report not found")
+
+// ErrReportAlreadyResolved is returned when resolving a report that is no longer pending
+var ErrReportAlreadyResolved = errors.New("report is already resolved")
+
+// ErrInvalidEvidence is returned when a report's evidence command was not sent by the reported user to the reporter
+var ErrInvalidEvidence = errors.New("command is not from the reported user to the reporter")
+
+// ErrInvalidResolution is returned for an unknown resolution or a suspension without a duration
+var ErrInvalidResolution = errors.New("invalid report resolution")
+
+// ErrUnknownTag is returned when a command references a tag that is not defined
+var ErrUnknownTag = errors.New("unknown tag")
+
+// ErrBroadcastWithoutTags is returned for a command with neither a receiver nor tags
+var ErrBroadcastWithoutTags = errors.New("broadcast commands require at least one tag")
+
+// ErrCommandBlocked is returned when the receiver blocks one of a direct command's tags
+var ErrCommandBlocked = errors.New("receiver does not accept commands with these tags")
+
+// ErrInvalidTagPreference is returned for a preference that is not allow, block or require_approval
+var ErrInvalidTagPreference = errors.New("invalid tag preference")
+
+// ErrNoInstructions is returned when a command has no instructions
+var ErrNoInstructions = errors.New("command has no instructions")
+
+// ErrInvalidInstruction is returned when an instruction is malformed
+var ErrInvalidInstruction = errors.New("invalid instruction")
+
+// ErrInvalidCommandStatus is returned for a status that is not part of the command lifecycle
+var ErrInvalidCommandStatus = errors.New("invalid command status")
+
+// RateLimitError is returned when an action is repeated too soon
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many requests, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// InvalidTransitionError is returned when a command cannot move between two statuses
+type InvalidTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("invalid command status transition from %s to %s", e.From, e.To)
+}
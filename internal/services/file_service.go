@@ -0,0 +1,524 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/blocklist"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/scanner"
+	"github.com/thecontrolapp/controlme-go/internal/storage"
+	"github.com/thecontrolapp/controlme-go/internal/websocket"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Bytes read to detect the content type of an upload
+	sniffLength = 512
+
+	// Files scanned at the same time
+	maxConcurrentScans = 4
+
+	// Pending files rescanned per retry round
+	scanRetryBatch = 100
+
+	// Scans without a verdict after which a file is no longer retried
+	maxScanAttempts = 10
+)
+
+// scanBackoff spaces out the retries of a file that could not be scanned
+var scanBackoff = throttlePolicy{base: time.Minute, max: time.Hour}
+
+// FileService stores uploaded files once per content hash and keeps the
+// names they were uploaded under. Uploads matching the blocklist are refused
+// and new files are quarantined until every scanner has passed them.
+type FileService struct {
+	db        *gorm.DB
+	store     *storage.Store
+	maxSize   int64
+	scanners  []scanner.Scanner
+	blocklist *blocklist.List
+	audit     *AuditService
+	hub       *websocket.Hub
+
+	scanSlots chan struct{}
+	scanning  sync.Map // Hashes being scanned, so each is scanned once at a time
+
+	// blobMu orders recording an upload, starting a download and deleting
+	// an expired file, so a blob is never deleted while it is in use
+	blobMu    sync.Mutex
+	downloads map[string]int // Open downloads per hash
+}
+
+// NewFileService creates a new file service. Uploads larger than maxSize
+// bytes are refused. Without scanners, files are clean as soon as they are
+// stored. list may be nil to accept uploads without a blocklist check.
+func NewFileService(db *gorm.DB, store *storage.Store, maxSize int64, scanners []scanner.Scanner, list *blocklist.List, audit *AuditService, hub *websocket.Hub) *FileService {
+	return &FileService{
+		db:        db,
+		store:     store,
+		maxSize:   maxSize,
+		scanners:  scanners,
+		blocklist: list,
+		audit:     audit,
+		hub:       hub,
+		scanSlots: make(chan struct{}, maxConcurrentScans),
+		downloads: make(map[string]int),
+	}
+}
+
+// MaxSize returns the largest upload accepted, in bytes
+func (fs *FileService) MaxSize() int64 {
+	return fs.maxSize
+}
+
+// Upload stores the content read from r under the file name. Uploading
+// content that is already stored only adds the name. New content is scanned
+// in the background and cannot be downloaded before it passes. Content on
+// the blocklist is refused with ErrFileBlocked and its uploader is held for
+// review.
+func (fs *FileService) Upload(userID uuid.UUID, fileName string, r io.Reader) (*models.FileMetadata, *models.FileName, error) {
+	name, err := cleanFileName(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := fs.checkUploader(userID); err != nil {
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReaderSize(r, sniffLength)
+	head, err := reader.Peek(sniffLength)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, nil, err
+	}
+	contentType := http.DetectContentType(head)
+
+	hash, size, err := fs.store.Put(reader, fs.maxSize)
+	if errors.Is(err, storage.ErrTooLarge) {
+		return nil, nil, ErrFileTooLarge
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return fs.register(userID, name, hash, size, contentType)
+}
+
+// checkUploader returns an error unless the user exists and may upload
+func (fs *FileService) checkUploader(userID uuid.UUID) error {
+	var uploader models.User
+	if err := fs.db.First(&uploader, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if uploader.IsRestricted(time.Now()) {
+		return ErrAccountRestricted
+	}
+	return nil
+}
+
+// register records a blob that was just stored under the name a user
+// uploaded it as. Content on the blocklist is rejected; new content is
+// scanned in the background.
+func (fs *FileService) register(userID uuid.UUID, name, hash string, size int64, contentType string) (*models.FileMetadata, *models.FileName, error) {
+	if fs.blocklist != nil {
+		match, blocked, err := fs.matchBlocklist(hash, contentType)
+		if err != nil {
+			return nil, nil, err
+		}
+		if blocked {
+			if err := fs.rejectBlocked(userID, hash, name, match); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, ErrFileBlocked
+		}
+	}
+
+	now := time.Now()
+	scanStatus := models.ScanStatusPending
+	var scannedAt *time.Time
+	if len(fs.scanners) == 0 {
+		scanStatus, scannedAt = models.ScanStatusClean, &now
+	}
+
+	fs.blobMu.Lock()
+	defer fs.blobMu.Unlock()
+
+	// The retention sweeper may have deleted the blob after it was stored
+	exists, err := fs.store.Exists(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, fmt.Errorf("blob %s was deleted while uploading", hash)
+	}
+
+	var metadata models.FileMetadata
+	mapping := models.FileName{FileHash: hash, Name: name, UploadedBy: userID}
+	err = fs.db.Transaction(func(tx *gorm.DB) error {
+		// Uploading stored content again restarts its retention window
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "hash"}},
+			DoUpdates: clause.AssignmentColumns([]string{"last_used_at"}),
+		}).Create(&models.FileMetadata{
+			Hash:        hash,
+			Size:        size,
+			ContentType: contentType,
+			UploadedBy:  userID,
+			ScanStatus:  scanStatus,
+			ScannedAt:   scannedAt,
+			LastUsedAt:  &now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&mapping).Error
+		if err != nil {
+			return err
+		}
+		return tx.First(&metadata, "hash = ?", hash).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if metadata.ScanStatus == models.ScanStatusPending {
+		go fs.scan(hash)
+	}
+	return &metadata, &mapping, nil
+}
+
+// contentTypeOf detects the content type of a stored blob from its first bytes
+func (fs *FileService) contentTypeOf(hash string) (string, error) {
+	file, err := fs.store.Open(hash)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// download is a stored file opened by Open. Until it is closed, the
+// retention sweeper leaves the file alone.
+type download struct {
+	*os.File
+	release func()
+	once    sync.Once
+}
+
+// Close closes the file and ends the download
+func (d *download) Close() error {
+	err := d.File.Close()
+	d.once.Do(d.release)
+	return err
+}
+
+// Open returns the metadata of a stored file that passed scanning and opens
+// its content for reading. The file is not deleted before it is closed.
+func (fs *FileService) Open(hash string) (*models.FileMetadata, io.ReadSeekCloser, error) {
+	if !storage.ValidHash(hash) {
+		return nil, nil, ErrFileNotFound
+	}
+
+	var metadata models.FileMetadata
+	err := fs.db.First(&metadata, "hash = ?", hash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	switch metadata.ScanStatus {
+	case models.ScanStatusClean:
+	case models.ScanStatusInfected:
+		return nil, nil, ErrFileInfected
+	default:
+		return nil, nil, ErrFileQuarantined
+	}
+
+	fs.blobMu.Lock()
+	defer fs.blobMu.Unlock()
+	file, err := fs.store.Open(hash)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	fs.downloads[hash]++
+
+	return &metadata, &download{File: file, release: func() {
+		fs.blobMu.Lock()
+		defer fs.blobMu.Unlock()
+		if fs.downloads[hash]--; fs.downloads[hash] <= 0 {
+			delete(fs.downloads, hash)
+		}
+	}}, nil
+}
+
+// deleteUnused deletes a file that nobody uploaded since cutoff and that no
+// unfinished command, or command created since checkedAt, refers to, unless
+// it is being downloaded. It reports whether the file was deleted.
+func (fs *FileService) deleteUnused(hash string, cutoff, checkedAt time.Time) (bool, error) {
+	fs.blobMu.Lock()
+	defer fs.blobMu.Unlock()
+
+	if fs.downloads[hash] > 0 {
+		return false, nil
+	}
+
+	// The conditions are checked again here so an upload or command made
+	// since the sweeper looked at the file keeps it
+	result := fs.db.Where("hash = ? AND COALESCE(last_used_at, created_at) < ?", hash, cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM command_files JOIN commands ON commands.id = command_files.command_id "+
+			"WHERE command_files.file_hash = ? AND (commands.created_at >= ? OR EXISTS (SELECT 1 FROM command_assignments "+
+			"WHERE command_assignments.command_id = commands.id AND command_assignments.status IN ?)))",
+			hash, checkedAt, unfinishedStatuses).
+		Delete(&models.FileMetadata{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, fs.store.Delete(hash)
+}
+
+// ReloadBlocklist reads the blocklist file again and returns how many
+// entries it has
+func (fs *FileService) ReloadBlocklist() (int, error) {
+	if fs.blocklist == nil {
+		return 0, ErrBlocklistDisabled
+	}
+	count, err := fs.blocklist.Reload()
+	if err != nil {
+		return 0, err
+	}
+	logrus.WithField("entries", count).Info("Blocklist reloaded")
+	return count, nil
+}
+
+// matchBlocklist checks the SHA-256 hash of a stored file against the
+// blocklist and, for images, its perceptual hashes
+func (fs *FileService) matchBlocklist(hash, contentType string) (blocklist.Match, bool, error) {
+	if match, ok := fs.blocklist.MatchSHA256(hash); ok {
+		return match, true, nil
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return blocklist.Match{}, false, nil
+	}
+
+	file, err := fs.store.Open(hash)
+	if err != nil {
+		return blocklist.Match{}, false, err
+	}
+	defer file.Close()
+	return fs.blocklist.MatchImage(file)
+}
+
+// rejectBlocked handles an upload that matched the blocklist. Content that
+// was only just stored is deleted; content stored before its entry was
+// listed is marked infected so it can no longer be downloaded. The uploader
+// is held for review and disconnected, and the match is written to the
+// audit log.
+func (fs *FileService) rejectBlocked(userID uuid.UUID, hash, name string, match blocklist.Match) error {
+	now := time.Now()
+	var stored bool
+	err := fs.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.FileMetadata{}).
+			Where("hash = ?", hash).
+			Updates(map[string]interface{}{
+				"scan_status": models.ScanStatusInfected,
+				"scan_detail": "blocklist: " + match.Kind + " " + match.Hash,
+				"scanned_at":  now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		stored = result.RowsAffected > 0
+
+		return tx.Model(&models.User{}).
+			Where("id = ? AND review_hold_at IS NULL", userID).
+			Update("review_hold_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if !stored {
+		if err := fs.store.Delete(hash); err != nil {
+			logrus.WithError(err).WithField("file_hash", hash).Error("Failed to delete blocked upload")
+		}
+	}
+
+	detail := fmt.Sprintf("Upload %q (%s) matched %s blocklist entry %s", name, hash, match.Kind, match.Hash)
+	if match.Distance > 0 {
+		detail += fmt.Sprintf(" at distance %d", match.Distance)
+	}
+	if match.Label != "" {
+		detail += fmt.Sprintf(" (%s)", match.Label)
+	}
+	fs.audit.Record(models.AuditLog{
+		Event:  models.AuditEventBlocklistMatch,
+		UserID: &userID,
+		Detail: detail + "; uploader held for review",
+	})
+	fs.hub.DisconnectUser(userID)
+	return nil
+}
+
+// RetryPendingScans scans files that are still pending every interval, such
+// as those a scanner could not reach or that were uploaded before a restart.
+// It returns when ctx is cancelled.
+func (fs *FileService) RetryPendingScans(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var hashes []string
+		err := fs.db.Model(&models.FileMetadata{}).
+			Where("scan_status = ? AND scan_attempts < ?", models.ScanStatusPending, maxScanAttempts).
+			Where("next_scan_at IS NULL OR next_scan_at <= ?", time.Now()).
+			Order("created_at").
+			Limit(scanRetryBatch).
+			Pluck("hash", &hashes).Error
+		if err != nil {
+			logrus.WithError(err).Error("Failed to list files awaiting a scan")
+			continue
+		}
+		for _, hash := range hashes {
+			fs.scan(hash)
+		}
+	}
+}
+
+// scan runs every scanner over a pending file and stores the verdict. A
+// scanner error or pending result leaves the file pending for a retry with
+// backoff, see recordScanFailure.
+func (fs *FileService) scan(hash string) {
+	if _, busy := fs.scanning.LoadOrStore(hash, struct{}{}); busy {
+		return
+	}
+	defer fs.scanning.Delete(hash)
+
+	fs.scanSlots <- struct{}{}
+	defer func() { <-fs.scanSlots }()
+
+	log := logrus.WithField("file_hash", hash)
+	result, err := fs.runScanners(hash)
+	if err != nil {
+		log.WithError(err).Warn("File scan failed")
+		fs.recordScanFailure(hash, log)
+		return
+	}
+	if result.Status == models.ScanStatusPending {
+		fs.recordScanFailure(hash, log)
+		return
+	}
+
+	err = fs.db.Model(&models.FileMetadata{}).
+		Where("hash = ? AND scan_status = ?", hash, models.ScanStatusPending).
+		Updates(map[string]interface{}{
+			"scan_status": result.Status,
+			"scan_detail": result.Detail,
+			"scanned_at":  time.Now(),
+		}).Error
+	if err != nil {
+		log.WithError(err).Error("Failed to store file scan result")
+		return
+	}
+	if result.Status == models.ScanStatusInfected {
+		log.WithField("detail", result.Detail).Warn("Uploaded file failed a security scan")
+	}
+}
+
+// recordScanFailure counts a scan that ended without a verdict and schedules
+// the next one. Once maxScanAttempts scans have failed the file is no longer
+// retried and stays quarantined.
+func (fs *FileService) recordScanFailure(hash string, log *logrus.Entry) {
+	var metadata models.FileMetadata
+	if err := fs.db.Select("scan_attempts").First(&metadata, "hash = ?", hash).Error; err != nil {
+		log.WithError(err).Error("Failed to load file scan attempts")
+		return
+	}
+
+	attempts := metadata.ScanAttempts + 1
+	err := fs.db.Model(&models.FileMetadata{}).
+		Where("hash = ? AND scan_status = ?", hash, models.ScanStatusPending).
+		Updates(map[string]interface{}{
+			"scan_attempts": attempts,
+			"next_scan_at":  time.Now().Add(scanBackoff.delay(attempts)),
+		}).Error
+	if err != nil {
+		log.WithError(err).Error("Failed to record file scan attempt")
+		return
+	}
+
+	if attempts >= maxScanAttempts {
+		log.WithField("attempts", attempts).Error("File could not be scanned, giving up; it stays quarantined")
+	}
+}
+
+// runScanners returns the first result that is not clean, or a clean result
+// if every scanner passed the file
+func (fs *FileService) runScanners(hash string) (scanner.Result, error) {
+	for _, s := range fs.scanners {
+		file, err := fs.store.Open(hash)
+		if err != nil {
+			return scanner.Result{}, err
+		}
+		result, err := s.Scan(file)
+		file.Close()
+		if err != nil {
+			return scanner.Result{}, fmt.Errorf("%s: %w", s.Name(), err)
+		}
+		if result.Status != models.ScanStatusClean {
+			result.Detail = s.Name() + ": " + result.Detail
+			return result, nil
+		}
+	}
+	return scanner.Result{Status: models.ScanStatusClean}, nil
+}
+
+// cleanFileName returns the last element of a file name as sent by a
+// client, or ErrInvalidFileName if nothing usable is left
+func cleanFileName(fileName string) (string, error) {
+	name := strings.TrimSpace(path.Base(strings.ReplaceAll(fileName, "\\", "/")))
+	if name == "" || name == "." || name == ".." || name == "/" || len(name) > 255 || !utf8.ValidString(name) {
+		return "", ErrInvalidFileName
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", ErrInvalidFileName
+		}
+	}
+	return name, nil
+}
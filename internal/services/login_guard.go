@@ -0,0 +1,230 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// throttlePolicy describes how failed logins slow down further attempts.
+// After free failures every further failure doubles the wait, starting at
+// base, up to max; reaching max is a temporary lockout.
+type throttlePolicy struct {
+	free int
+	base time.Duration
+	max  time.Duration
+}
+
+var (
+	// Guesses against one account, from any number of addresses
+	accountThrottle = throttlePolicy{free: 5, base: time.Second, max: 15 * time.Minute}
+
+	// Guesses from one address, against any number of accounts
+	ipThrottle = throttlePolicy{free: 20, base: time.Second, max: time.Hour}
+)
+
+// Failed logins are forgotten after this long without another failure
+const throttleWindow = 24 * time.Hour
+
+// delay returns how long to wait after the given number of consecutive failures
+func (p throttlePolicy) delay(failures int) time.Duration {
+	over := failures - p.free
+	if over <= 0 {
+		return 0
+	}
+	if over > 30 {
+		return p.max
+	}
+	delay := p.base << (over - 1)
+	if delay > p.max {
+		return p.max
+	}
+	return delay
+}
+
+// LoginGuard tracks failed logins per account and per IP address and refuses
+// further attempts with exponential backoff
+type LoginGuard struct {
+	db    *gorm.DB
+	audit *AuditService
+	now   func() time.Time // Replaced in tests
+}
+
+// NewLoginGuard creates a new login guard
+func NewLoginGuard(db *gorm.DB, auditService *AuditService) *LoginGuard {
+	return &LoginGuard{
+		db:    db,
+		audit: auditService,
+		now:   time.Now,
+	}
+}
+
+// accountKey keys an account's throttle by login name, so guesses against
+// names that do not exist behave the same as against real accounts
+func accountKey(loginName string) string {
+	return "account:" + strings.ToLower(loginName)
+}
+
+// ipKey keys an IP address's throttle
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a RateLimitError if the account or the IP address must wait
+// before trying to log in again
+func (lg *LoginGuard) Check(loginName, ip string) error {
+	now := lg.now()
+	var throttles []models.LoginThrottle
+	err := lg.db.Where("key IN ? AND blocked_until > ?", []string{accountKey(loginName), ipKey(ip)}, now).
+		Find(&throttles).Error
+	if err != nil {
+		return err
+	}
+
+	var wait time.Duration
+	for _, throttle := range throttles {
+		if remaining := throttle.BlockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	if wait > 0 {
+		return &RateLimitError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login against the account and the IP address
+// and records a lockout in the audit log when either reaches its longest wait
+func (lg *LoginGuard) RecordFailure(loginName, ip string) error {
+	if err := lg.recordFailure(accountKey(loginName), accountThrottle, func(failures int) {
+		lg.audit.Record(models.AuditLog{
+			Event:     models.AuditEventAccountLocked,
+			UserID:    lg.userIDByLoginName(loginName),
+			IPAddress: ip,
+			Detail:    fmt.Sprintf("Login for %q locked for %s after %d failed attempts", loginName, accountThrottle.max, failures),
+		})
+	}); err != nil {
+		return err
+	}
+
+	return lg.recordFailure(ipKey(ip), ipThrottle, func(failures int) {
+		lg.audit.Record(models.AuditLog{
+			Event:     models.AuditEventIPBlocked,
+			IPAddress: ip,
+			Detail:    fmt.Sprintf("Logins from %s blocked for %s after %d failed attempts", ip, ipThrottle.max, failures),
+		})
+	})
+}
+
+// recordFailure counts a failure for a throttle key and calls locked when the
+// failure takes the key to the policy's longest wait
+func (lg *LoginGuard) recordFailure(key string, policy throttlePolicy, locked func(failures int)) error {
+	var failures int
+	err := lg.db.Transaction(func(tx *gorm.DB) error {
+		throttle := models.LoginThrottle{Key: key}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&throttle).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&throttle, "key = ?", key).Error; err != nil {
+			return err
+		}
+
+		now := lg.now()
+		if now.Sub(throttle.LastFailureAt) > throttleWindow {
+			throttle.Failures = 0
+		}
+		throttle.Failures++
+		throttle.LastFailureAt = now
+		throttle.BlockedUntil = nil
+		if delay := policy.delay(throttle.Failures); delay > 0 {
+			blockedUntil := now.Add(delay)
+			throttle.BlockedUntil = &blockedUntil
+		}
+		failures = throttle.Failures
+		return tx.Save(&throttle).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if policy.delay(failures) == policy.max && policy.delay(failures-1) < policy.max {
+		locked(failures)
+	}
+	return nil
+}
+
+// CheckUser is Check for a signed-in user who has to give their password or
+// a two-factor code again
+func (lg *LoginGuard) CheckUser(userID uuid.UUID, ip string) error {
+	loginName, err := lg.loginNameByID(userID)
+	if err != nil {
+		return err
+	}
+	return lg.Check(loginName, ip)
+}
+
+// RecordUserFailure counts a wrong password or two-factor code from a
+// signed-in user as a failed login of their account
+func (lg *LoginGuard) RecordUserFailure(userID uuid.UUID, ip string) error {
+	loginName, err := lg.loginNameByID(userID)
+	if err != nil {
+		return err
+	}
+	return lg.RecordFailure(loginName, ip)
+}
+
+// RecordSuccess clears the account's failed logins. The IP address keeps its
+// count so one valid account cannot be used to keep guessing others.
+func (lg *LoginGuard) RecordSuccess(loginName string) error {
+	return lg.db.Where("key = ?", accountKey(loginName)).Delete(&models.LoginThrottle{}).Error
+}
+
+// Unlock clears a user's failed logins on behalf of an admin
+func (lg *LoginGuard) Unlock(userID, actorID uuid.UUID) error {
+	var user models.User
+	if err := lg.db.First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	if err := lg.RecordSuccess(user.LoginName); err != nil {
+		return err
+	}
+
+	lg.audit.Record(models.AuditLog{
+		Event:   models.AuditEventAccountUnlocked,
+		UserID:  &user.ID,
+		ActorID: &actorID,
+		Detail:  fmt.Sprintf("Login for %q unlocked", user.LoginName),
+	})
+	return nil
+}
+
+// loginNameByID returns the login name of a user
+func (lg *LoginGuard) loginNameByID(userID uuid.UUID) (string, error) {
+	var user models.User
+	if err := lg.db.Select("login_name").First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	return user.LoginName, nil
+}
+
+// userIDByLoginName returns the ID of the user with the login name, or nil if there is none
+func (lg *LoginGuard) userIDByLoginName(loginName string) *uuid.UUID {
+	var user models.User
+	if err := lg.db.Select("id").Where("LOWER(login_name) = LOWER(?)", loginName).First(&user).Error; err != nil {
+		return nil
+	}
+	return &user.ID
+}
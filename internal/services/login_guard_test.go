@@ -0,0 +1,247 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// guardTest has a login guard whose clock only moves when the test advances it
+type guardTest struct {
+	t     *testing.T
+	db    *gorm.DB
+	guard *LoginGuard
+	clock time.Time
+}
+
+func newGuardTest(t *testing.T) *guardTest {
+	t.Helper()
+	db := testdb.Open(t)
+	gt := &guardTest{
+		t:     t,
+		db:    db,
+		guard: NewLoginGuard(db, NewAuditService(db)),
+		clock: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	gt.guard.now = func() time.Time { return gt.clock }
+	return gt
+}
+
+func (gt *guardTest) advance(d time.Duration) {
+	gt.clock = gt.clock.Add(d)
+}
+
+// fail records n failed logins
+func (gt *guardTest) fail(n int, loginName func(i int) string, ip func(i int) string) {
+	gt.t.Helper()
+	for i := range n {
+		if err := gt.guard.RecordFailure(loginName(i), ip(i)); err != nil {
+			gt.t.Fatalf("RecordFailure() error = %v", err)
+		}
+	}
+}
+
+// wait returns how long the login name from the address has to wait, 0 if it may try now
+func (gt *guardTest) wait(loginName, ip string) time.Duration {
+	gt.t.Helper()
+	err := gt.guard.Check(loginName, ip)
+	if err == nil {
+		return 0
+	}
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		gt.t.Fatalf("Check() error = %v", err)
+	}
+	return rateLimitErr.RetryAfter
+}
+
+// audited counts the audit log entries of an event
+func (gt *guardTest) audited(event string) int64 {
+	gt.t.Helper()
+	var count int64
+	if err := gt.db.Model(&models.AuditLog{}).Where("event = ?", event).Count(&count).Error; err != nil {
+		gt.t.Fatalf("failed to count audit log: %v", err)
+	}
+	return count
+}
+
+// same returns the value for every attempt; numbered returns a new one for each
+func same(value string) func(int) string { return func(int) string { return value } }
+
+func numbered(prefix string) func(int) string {
+	return func(i int) string { return fmt.Sprintf("%s%d", prefix, i) }
+}
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   throttlePolicy
+		failures int
+		want     time.Duration
+	}{
+		{"account none", accountThrottle, 0, 0},
+		{"account last free", accountThrottle, 5, 0},
+		{"account first", accountThrottle, 6, time.Second},
+		{"account doubles", accountThrottle, 7, 2 * time.Second},
+		{"account below cap", accountThrottle, 15, 512 * time.Second},
+		{"account capped", accountThrottle, 16, 15 * time.Minute},
+		{"account far past cap", accountThrottle, 1000, 15 * time.Minute},
+		{"ip last free", ipThrottle, 20, 0},
+		{"ip first", ipThrottle, 21, time.Second},
+		{"ip below cap", ipThrottle, 32, 2048 * time.Second},
+		{"ip capped", ipThrottle, 33, time.Hour},
+		{"ip far past cap", ipThrottle, 1000, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.failures); got != tt.want {
+				t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginGuardThrottles(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		loginName func(int) string
+		ip        func(int) string
+		checkName string
+		checkIP   string
+		want      time.Duration
+	}{
+		{"account free failures", 5, same("alice"), numbered("10.0.0."), "alice", "10.0.1.1", 0},
+		{"account first backoff", 6, same("alice"), numbered("10.0.0."), "alice", "10.0.1.1", time.Second},
+		{"account backoff doubles", 8, same("alice"), numbered("10.0.0."), "alice", "10.0.1.1", 4 * time.Second},
+		{"account name is case insensitive", 6, same("Alice"), numbered("10.0.0."), "ALICE", "10.0.1.1", time.Second},
+		{"account lockout", 20, same("alice"), numbered("10.0.0."), "alice", "10.0.1.1", 15 * time.Minute},
+		{"other account unaffected", 20, same("alice"), numbered("10.0.0."), "bob", "10.0.1.1", 0},
+		{"ip free failures", 20, numbered("user"), same("10.0.0.1"), "someone", "10.0.0.1", 0},
+		{"ip first backoff", 21, numbered("user"), same("10.0.0.1"), "someone", "10.0.0.1", time.Second},
+		{"ip lockout", 40, numbered("user"), same("10.0.0.1"), "someone", "10.0.0.1", time.Hour},
+		{"other ip unaffected", 40, numbered("user"), same("10.0.0.1"), "someone", "10.0.0.2", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gt := newGuardTest(t)
+			gt.fail(tt.failures, tt.loginName, tt.ip)
+			if got := gt.wait(tt.checkName, tt.checkIP); got != tt.want {
+				t.Errorf("wait = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginGuardBackoffExpires(t *testing.T) {
+	gt := newGuardTest(t)
+	gt.fail(7, same("alice"), numbered("10.0.0."))
+
+	if got := gt.wait("alice", "10.0.1.1"); got != 2*time.Second {
+		t.Fatalf("wait = %s, want 2s", got)
+	}
+	gt.advance(time.Second)
+	if got := gt.wait("alice", "10.0.1.1"); got != time.Second {
+		t.Errorf("wait after 1s = %s, want 1s", got)
+	}
+	gt.advance(time.Second)
+	if got := gt.wait("alice", "10.0.1.1"); got != 0 {
+		t.Errorf("wait after 2s = %s, want 0", got)
+	}
+
+	// Failures still count after the wait: the next one doubles it again
+	gt.fail(1, same("alice"), same("10.0.1.1"))
+	if got := gt.wait("alice", "10.0.1.1"); got != 4*time.Second {
+		t.Errorf("wait after another failure = %s, want 4s", got)
+	}
+}
+
+func TestLoginGuardWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		quiet time.Duration
+		want  time.Duration
+	}{
+		{"within the window", throttleWindow - time.Minute, 4 * time.Second},
+		{"after the window", throttleWindow + time.Minute, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gt := newGuardTest(t)
+			gt.fail(7, same("alice"), numbered("10.0.0."))
+			gt.advance(tt.quiet)
+			gt.fail(1, same("alice"), same("10.0.1.1"))
+			if got := gt.wait("alice", "10.0.1.2"); got != tt.want {
+				t.Errorf("wait = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginGuardAuditsLockouts(t *testing.T) {
+	gt := newGuardTest(t)
+	user := createTestUser(t, gt.db, "alice")
+
+	gt.fail(15, same("alice"), numbered("10.0.0."))
+	if got := gt.audited(models.AuditEventAccountLocked); got != 0 {
+		t.Fatalf("account_locked entries before the longest wait = %d, want 0", got)
+	}
+	gt.fail(1, same("alice"), same("10.0.1.1"))
+	if got := gt.audited(models.AuditEventAccountLocked); got != 1 {
+		t.Fatalf("account_locked entries at the longest wait = %d, want 1", got)
+	}
+	var entry models.AuditLog
+	if err := gt.db.First(&entry, "event = ?", models.AuditEventAccountLocked).Error; err != nil {
+		t.Fatalf("failed to load audit entry: %v", err)
+	}
+	if entry.UserID == nil || *entry.UserID != user.ID || entry.IPAddress != "10.0.1.1" {
+		t.Errorf("audit entry = user %v from %q, want %s from 10.0.1.1", entry.UserID, entry.IPAddress, user.ID)
+	}
+	// Staying at the longest wait is not a new lockout
+	gt.fail(3, same("alice"), numbered("10.0.2."))
+	if got := gt.audited(models.AuditEventAccountLocked); got != 1 {
+		t.Errorf("account_locked entries past the longest wait = %d, want 1", got)
+	}
+
+	gt.fail(32, numbered("user"), same("10.9.9.9"))
+	if got := gt.audited(models.AuditEventIPBlocked); got != 0 {
+		t.Fatalf("ip_blocked entries before the longest wait = %d, want 0", got)
+	}
+	gt.fail(2, numbered("other"), same("10.9.9.9"))
+	if got := gt.audited(models.AuditEventIPBlocked); got != 1 {
+		t.Errorf("ip_blocked entries = %d, want 1", got)
+	}
+}
+
+func TestLoginGuardSuccess(t *testing.T) {
+	gt := newGuardTest(t)
+	user := createTestUser(t, gt.db, "alice")
+	gt.fail(6, same("alice"), numbered("10.0.1."))
+	gt.advance(2 * time.Second)
+	gt.fail(21, numbered("user"), same("10.0.0.1"))
+
+	// A signed-in user giving a wrong password or code counts against the account
+	if err := gt.guard.RecordUserFailure(user.ID, "10.0.2.1"); err != nil {
+		t.Fatalf("RecordUserFailure() error = %v", err)
+	}
+	var rateLimitErr *RateLimitError
+	if err := gt.guard.CheckUser(user.ID, "10.0.2.1"); !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != 2*time.Second {
+		t.Fatalf("CheckUser() error = %v, want a 2s RateLimitError", err)
+	}
+
+	if err := gt.guard.RecordSuccess("alice"); err != nil {
+		t.Fatalf("RecordSuccess() error = %v", err)
+	}
+	if got := gt.wait("alice", "10.0.2.1"); got != 0 {
+		t.Errorf("account wait after a success = %s, want 0", got)
+	}
+	// The address keeps its failures
+	if got := gt.wait("alice", "10.0.0.1"); got == 0 {
+		t.Error("address unblocked by a success")
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/mailer"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// How long a password reset token can be used
+	passwordResetTTL = time.Hour
+
+	// Minimum time between two reset emails to the same user
+	passwordResetInterval = time.Minute
+)
+
+// PasswordService changes and recovers passwords. Every password change
+// revokes the user's other sessions; a reset also revokes their device keys.
+type PasswordService struct {
+	db       *gorm.DB
+	auth     *auth.AuthService
+	mailer   mailer.Mailer
+	sessions *SessionService
+	devices  *DeviceService
+	resetURL string
+}
+
+// NewPasswordService creates a new password service. resetURL is the page
+// that accepts a reset token from a link; without it emails only carry the token.
+func NewPasswordService(db *gorm.DB, authService *auth.AuthService, mailer mailer.Mailer, sessionService *SessionService, deviceService *DeviceService, resetURL string) *PasswordService {
+	return &PasswordService{
+		db:       db,
+		auth:     authService,
+		mailer:   mailer,
+		sessions: sessionService,
+		devices:  deviceService,
+		resetURL: resetURL,
+	}
+}
+
+// RequestReset emails a reset token to the user with the email address. To
+// keep accounts from being enumerated it behaves the same whether or not the
+// address belongs to a user: unknown addresses and requests repeated within
+// passwordResetInterval are silently ignored, and the email is sent in the
+// background.
+func (ps *PasswordService) RequestReset(email string) error {
+	var user models.User
+	err := ps.db.Where("LOWER(email) = LOWER(?)", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	sent := false
+	err = ps.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the user so concurrent requests cannot both pass the interval check
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", user.ID).Error; err != nil {
+			return err
+		}
+
+		var recent int64
+		err := tx.Model(&models.PasswordReset{}).
+			Where("user_id = ? AND created_at > ?", user.ID, now.Add(-passwordResetInterval)).
+			Count(&recent).Error
+		if err != nil || recent > 0 {
+			return err
+		}
+
+		// Only the newest token works
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordReset{}).Error; err != nil {
+			return err
+		}
+
+		reset := models.PasswordReset{
+			UserID:    user.ID,
+			TokenHash: tokenHash,
+			ExpiresAt: now.Add(passwordResetTTL),
+		}
+		if err := tx.Create(&reset).Error; err != nil {
+			return err
+		}
+		sent = true
+		return nil
+	})
+	if err != nil || !sent {
+		return err
+	}
+
+	message := ps.resetMessage(&user, token)
+	go func() {
+		if err := ps.mailer.Send(message); err != nil {
+			logrus.WithError(err).WithField("user_id", user.ID).Warn("Failed to send password reset email")
+		}
+	}()
+	return nil
+}
+
+// resetMessage builds the email carrying a password reset token
+func (ps *PasswordService) resetMessage(user *models.User, token string) mailer.Message {
+	body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your ControlMe account.\n", user.ScreenName)
+	if ps.resetURL != "" {
+		body += "\nChoose a new password here:\n" + ps.resetURL + "?token=" + url.QueryEscape(token) + "\n"
+	} else {
+		body += "\nYour reset token is:\n" + token + "\n"
+	}
+	body += fmt.Sprintf("\nIt can be used once within %d minutes. If you did not ask for this, ignore this email; your password stays the same.\n", int(passwordResetTTL.Minutes()))
+
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your ControlMe password",
+		Body:    body,
+	}
+}
+
+// ResetPassword sets a new password with an emailed reset token, uses up the
+// token and revokes all of the user's sessions and device keys, since whoever
+// knew the old password may have paired a device with it. A password the
+// policy refuses returns an *auth.PasswordPolicyError and leaves the token usable.
+func (ps *PasswordService) ResetPassword(token, newPassword string) error {
+	var userID uuid.UUID
+	err := ps.db.Transaction(func(tx *gorm.DB) error {
+		var reset models.PasswordReset
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL", auth.HashToken(token)).
+			First(&reset).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if now.After(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+
+		var user models.User
+		if err := tx.First(&user, "id = ?", reset.UserID).Error; err != nil {
+			return err
+		}
+		if err := ps.auth.PasswordPolicy.Check(newPassword, user.LoginName, user.Email); err != nil {
+			return err
+		}
+		hash, err := ps.auth.PasswordManager.HashPassword(newPassword)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+
+		if err := tx.Model(&reset).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", reset.UserID).Update("password", hash).Error; err != nil {
+			return err
+		}
+		userID = reset.UserID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ps.sessions.RevokeAllSessions(userID, uuid.Nil); err != nil {
+		return err
+	}
+	return ps.devices.RevokeAllDevices(userID)
+}
+
+// ChangePassword replaces the user's password after checking the current one,
+// and revokes every session except the one making the change. Device keys
+// stay valid: the caller proved they know the password, and they can revoke
+// devices themselves. A password the policy refuses returns an
+// *auth.PasswordPolicyError.
+func (ps *PasswordService) ChangePassword(userID, sessionID uuid.UUID, currentPassword, newPassword string) error {
+	var user models.User
+	if err := ps.db.First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	if err := ps.auth.PasswordManager.VerifyPassword(currentPassword, user.Password); err != nil {
+		return ErrInvalidPassword
+	}
+	if err := ps.auth.PasswordPolicy.Check(newPassword, user.LoginName, user.Email); err != nil {
+		return err
+	}
+
+	hash, err := ps.auth.PasswordManager.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	if err := ps.db.Model(&user).Update("password", hash).Error; err != nil {
+		return err
+	}
+
+	return ps.sessions.RevokeAllSessions(userID, sessionID)
+}
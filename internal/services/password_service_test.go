@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/config"
+	"github.com/thecontrolapp/controlme-go/internal/mailer"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+	"github.com/thecontrolapp/controlme-go/internal/websocket"
+	"gorm.io/gorm"
+)
+
+// newPasswordTest returns a password service and a user with the password
+// "old password 1", two sessions and a device key
+func newPasswordTest(t *testing.T) (*PasswordService, *gorm.DB, uuid.UUID, uuid.UUID) {
+	t.Helper()
+	db := testdb.Open(t)
+	passwords, err := auth.NewPasswordManager(auth.HashParams{Algorithm: auth.HashArgon2id, Argon2Memory: 1024, Argon2Iterations: 1, Argon2Parallelism: 1})
+	if err != nil {
+		t.Fatalf("NewPasswordManager() error = %v", err)
+	}
+	policy, err := auth.NewPasswordPolicy(10, 128, "")
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy() error = %v", err)
+	}
+	authService := auth.NewAuthService("test-secret", 15*time.Minute, passwords, policy)
+	hub := websocket.NewHub(config.WebSocket{})
+	sessions := NewSessionService(db, authService.JWTManager, time.Hour, hub)
+	ps := NewPasswordService(db, authService, mailer.NewMemoryMailer(), sessions, NewDeviceService(db, hub), "")
+
+	hash, err := passwords.HashPassword("old password 1")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	user := createTestUser(t, db, "alice")
+	if err := db.Model(&user).Update("password", hash).Error; err != nil {
+		t.Fatalf("failed to set password: %v", err)
+	}
+
+	var current uuid.UUID
+	for range 2 {
+		pair, err := sessions.CreateSession(user.ID, SessionInfo{ClientType: websocket.ClientTypeWeb})
+		if err != nil {
+			t.Fatalf("CreateSession() error = %v", err)
+		}
+		current = pair.SessionID
+	}
+	device := models.DeviceCredential{UserID: user.ID, KeyHash: uuid.NewString()}
+	if err := db.Create(&device).Error; err != nil {
+		t.Fatalf("failed to create device key: %v", err)
+	}
+	return ps, db, user.ID, current
+}
+
+func TestResetPasswordRevokesDeviceKeys(t *testing.T) {
+	ps, db, userID, _ := newPasswordTest(t)
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+	reset := models.PasswordReset{UserID: userID, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&reset).Error; err != nil {
+		t.Fatalf("failed to create reset: %v", err)
+	}
+
+	if err := ps.ResetPassword(token, "new password 1"); err != nil {
+		t.Fatalf("ResetPassword() error = %v", err)
+	}
+	if sessions, devices := activeAccess(t, db, userID); sessions != 0 || devices != 0 {
+		t.Errorf("active sessions and device keys after a reset = %d, %d, want none", sessions, devices)
+	}
+}
+
+func TestChangePasswordKeepsDeviceKeys(t *testing.T) {
+	ps, db, userID, current := newPasswordTest(t)
+
+	if err := ps.ChangePassword(userID, current, "wrong password", "new password 1"); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("ChangePassword() with a wrong password error = %v, want ErrInvalidPassword", err)
+	}
+	if sessions, devices := activeAccess(t, db, userID); sessions != 2 || devices != 1 {
+		t.Errorf("active sessions and device keys after a wrong password = %d, %d, want 2, 1", sessions, devices)
+	}
+
+	if err := ps.ChangePassword(userID, current, "old password 1", "new password 1"); err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+	if sessions, devices := activeAccess(t, db, userID); sessions != 1 || devices != 1 {
+		t.Errorf("active sessions and device keys after a change = %d, %d, want 1, 1", sessions, devices)
+	}
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/websocket"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReportService handles user reports and their moderation
+type ReportService struct {
+	db    *gorm.DB
+	hub   *websocket.Hub
+	audit *AuditService
+}
+
+// NewReportService creates a new report service
+func NewReportService(db *gorm.DB, hub *websocket.Hub, audit *AuditService) *ReportService {
+	return &ReportService{
+		db:    db,
+		hub:   hub,
+		audit: audit,
+	}
+}
+
+// CreateReportRequest is used for reporting a user
+type CreateReportRequest struct {
+	ReportedID uuid.UUID  `json:"reported_id" binding:"required"`
+	CommandID  *uuid.UUID `json:"command_id,omitempty"` // Optional command from the reported user as evidence
+	Reason     string     `json:"reason" binding:"required"`
+}
+
+// ResolveReportRequest is used by moderators to resolve a report
+type ResolveReportRequest struct {
+	Resolution   string `json:"resolution" binding:"required" enums:"dismiss,warn,suspend,ban"`
+	Note         string `json:"note"`
+	SuspendHours int    `json:"suspend_hours,omitempty"` // Required for suspend
+}
+
+// ModerationNotice is the data of a "moderation_notice" message sent to a moderated user
+type ModerationNotice struct {
+	Action         string     `json:"action"`
+	Note           string     `json:"note,omitempty"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+}
+
+// CreateReport files a report against another user. An evidence command must
+// have been sent by the reported user to the reporter.
+func (rs *ReportService) CreateReport(reporterID uuid.UUID, req CreateReportRequest) (*models.Report, error) {
+	if reporterID == req.ReportedID {
+		return nil, ErrCannotReportSelf
+	}
+
+	var reported models.User
+	if err := rs.db.First(&reported, "id = ?", req.ReportedID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	if req.CommandID != nil {
+		var count int64
+		err := rs.db.Model(&models.CommandAssignment{}).
+			Joins("JOIN commands ON commands.id = command_assignments.command_id").
+			Where("commands.id = ? AND commands.sender_id = ? AND command_assignments.user_id = ?",
+				*req.CommandID, req.ReportedID, reporterID).
+			Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrInvalidEvidence
+		}
+	}
+
+	report := models.Report{
+		ReporterID: reporterID,
+		ReportedID: req.ReportedID,
+		CommandID:  req.CommandID,
+		Reason:     req.Reason,
+		Status:     models.ReportStatusPending,
+	}
+	if err := rs.db.Create(&report).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ListReports returns one page of reports with the given status, oldest first,
+// and the total number of reports with that status. An empty status lists all reports.
+func (rs *ReportService) ListReports(status string, page, pageSize int) ([]models.Report, int64, error) {
+	query := rs.db.Model(&models.Report{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	// Count and Find each start from the filter rather than sharing one statement
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var reports []models.Report
+	err := query.Preload("Reporter").
+		Preload("Reported").
+		Preload("Moderator").
+		Order("created_at ASC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&reports).Error
+	return reports, total, err
+}
+
+// GetReport returns a report with its users and evidence command loaded
+func (rs *ReportService) GetReport(reportID uuid.UUID) (*models.Report, error) {
+	var report models.Report
+	err := rs.db.Preload("Reporter").
+		Preload("Reported").
+		Preload("Moderator").
+		Preload("Command").
+		First(&report, "id = ?", reportID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReportNotFound
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ResolveReport records a moderator's resolution of a pending report and
+// applies it to the reported user. Suspended and banned users lose their
+// sessions and device keys and are disconnected.
+func (rs *ReportService) ResolveReport(reportID, moderatorID uuid.UUID, req ResolveReportRequest) (*models.Report, error) {
+	switch req.Resolution {
+	case models.ReportResolutionDismiss, models.ReportResolutionWarn, models.ReportResolutionBan:
+	case models.ReportResolutionSuspend:
+		if req.SuspendHours <= 0 {
+			return nil, ErrInvalidResolution
+		}
+	default:
+		return nil, ErrInvalidResolution
+	}
+
+	now := time.Now()
+	notice := ModerationNotice{Action: req.Resolution, Note: req.Note}
+	var reportedID uuid.UUID
+
+	err := rs.db.Transaction(func(tx *gorm.DB) error {
+		var report models.Report
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&report, "id = ?", reportID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrReportNotFound
+			}
+			return err
+		}
+		if report.Status != models.ReportStatusPending {
+			return ErrReportAlreadyResolved
+		}
+		reportedID = report.ReportedID
+
+		err = tx.Model(&report).Updates(map[string]interface{}{
+			"status":         models.ReportStatusResolved,
+			"resolution":     req.Resolution,
+			"moderator_id":   moderatorID,
+			"moderator_note": req.Note,
+			"resolved_at":    now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		switch req.Resolution {
+		case models.ReportResolutionSuspend:
+			until := now.Add(time.Duration(req.SuspendHours) * time.Hour)
+			notice.SuspendedUntil = &until
+			err = tx.Model(&models.User{}).Where("id = ?", report.ReportedID).
+				Update("suspended_until", until).Error
+		case models.ReportResolutionBan:
+			err = tx.Model(&models.User{}).Where("id = ?", report.ReportedID).
+				Update("banned_at", now).Error
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return revokeAccess(tx, report.ReportedID, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"report_id":    reportID,
+		"moderator_id": moderatorID,
+		"reported_id":  reportedID,
+		"resolution":   req.Resolution,
+	}).Info("Report resolved")
+
+	if req.Resolution != models.ReportResolutionDismiss {
+		rs.hub.SendToUser(reportedID, websocket.Message{
+			Type:      websocket.MessageTypeModerationNotice,
+			Timestamp: now,
+			Data:      notice,
+		})
+	}
+	if req.Resolution == models.ReportResolutionSuspend || req.Resolution == models.ReportResolutionBan {
+		rs.hub.DisconnectUser(reportedID)
+	}
+
+	return rs.GetReport(reportID)
+}
+
+// revokeAccess ends every session of a user and revokes their device keys
+func revokeAccess(tx *gorm.DB, userID uuid.UUID, now time.Time) error {
+	err := tx.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.DeviceCredential{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
+
+// ReleaseReviewHold lifts the hold placed on a user whose upload matched the
+// blocklist, once a moderator has reviewed it. Suspensions and bans are not
+// affected.
+func (rs *ReportService) ReleaseReviewHold(userID, moderatorID uuid.UUID) error {
+	var user models.User
+	if err := rs.db.First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	result := rs.db.Model(&models.User{}).
+		Where("id = ? AND review_hold_at IS NOT NULL", userID).
+		Update("review_hold_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNoReviewHold
+	}
+
+	rs.audit.Record(models.AuditLog{
+		Event:   models.AuditEventReviewHoldReleased,
+		UserID:  &user.ID,
+		ActorID: &moderatorID,
+		Detail:  fmt.Sprintf("Review hold on %q released", user.LoginName),
+	})
+	return nil
+}
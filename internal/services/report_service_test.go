@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/config"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+	"github.com/thecontrolapp/controlme-go/internal/websocket"
+	"gorm.io/gorm"
+)
+
+// newReportTest returns a report service, a moderator and a reported user
+// with an active session and device key
+func newReportTest(t *testing.T) (*ReportService, *gorm.DB, uuid.UUID, uuid.UUID) {
+	t.Helper()
+	db := testdb.Open(t)
+	rs := NewReportService(db, websocket.NewHub(config.WebSocket{}), NewAuditService(db))
+	moderator := createTestUser(t, db, "moderator").ID
+	reported := createTestUser(t, db, "reported").ID
+
+	session := models.Session{
+		ID:               uuid.New(),
+		UserID:           reported,
+		RefreshTokenHash: uuid.NewString(),
+		ExpiresAt:        time.Now().Add(time.Hour),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	device := models.DeviceCredential{ID: uuid.New(), UserID: reported, KeyHash: uuid.NewString()}
+	if err := db.Create(&device).Error; err != nil {
+		t.Fatalf("failed to create device key: %v", err)
+	}
+	return rs, db, moderator, reported
+}
+
+// report files a report against the reported user
+func report(t *testing.T, rs *ReportService, reporterID, reportedID uuid.UUID) *models.Report {
+	t.Helper()
+	r, err := rs.CreateReport(reporterID, CreateReportRequest{ReportedID: reportedID, Reason: "spam"})
+	if err != nil {
+		t.Fatalf("CreateReport() error = %v", err)
+	}
+	return r
+}
+
+// activeAccess counts the user's sessions and device keys that are not revoked
+func activeAccess(t *testing.T, db *gorm.DB, userID uuid.UUID) (int64, int64) {
+	t.Helper()
+	var sessions, devices int64
+	if err := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&sessions).Error; err != nil {
+		t.Fatalf("failed to count sessions: %v", err)
+	}
+	if err := db.Model(&models.DeviceCredential{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&devices).Error; err != nil {
+		t.Fatalf("failed to count device keys: %v", err)
+	}
+	return sessions, devices
+}
+
+func TestResolveReportRevokesAccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolution ResolveReportRequest
+		restricted bool
+	}{
+		{"dismiss", ResolveReportRequest{Resolution: models.ReportResolutionDismiss}, false},
+		{"warn", ResolveReportRequest{Resolution: models.ReportResolutionWarn}, false},
+		{"suspend", ResolveReportRequest{Resolution: models.ReportResolutionSuspend, SuspendHours: 24}, true},
+		{"ban", ResolveReportRequest{Resolution: models.ReportResolutionBan}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, db, moderator, reported := newReportTest(t)
+			r := report(t, rs, moderator, reported)
+
+			if _, err := rs.ResolveReport(r.ID, moderator, tt.resolution); err != nil {
+				t.Fatalf("ResolveReport() error = %v", err)
+			}
+
+			var user models.User
+			if err := db.First(&user, "id = ?", reported).Error; err != nil {
+				t.Fatalf("failed to load user: %v", err)
+			}
+			if restricted := user.IsRestricted(time.Now()); restricted != tt.restricted {
+				t.Errorf("IsRestricted() = %v, want %v", restricted, tt.restricted)
+			}
+			want := int64(1)
+			if tt.restricted {
+				want = 0
+			}
+			if sessions, devices := activeAccess(t, db, reported); sessions != want || devices != want {
+				t.Errorf("active sessions and device keys = %d, %d, want %d each", sessions, devices, want)
+			}
+
+			if _, err := rs.ResolveReport(r.ID, moderator, tt.resolution); !errors.Is(err, ErrReportAlreadyResolved) {
+				t.Errorf("second ResolveReport() error = %v, want ErrReportAlreadyResolved", err)
+			}
+		})
+	}
+}
+
+func TestListReports(t *testing.T) {
+	rs, _, moderator, reported := newReportTest(t)
+	var reports []*models.Report
+	for range 5 {
+		reports = append(reports, report(t, rs, moderator, reported))
+	}
+	if _, err := rs.ResolveReport(reports[0].ID, moderator, ResolveReportRequest{Resolution: models.ReportResolutionDismiss}); err != nil {
+		t.Fatalf("ResolveReport() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		status    string
+		page      int
+		wantTotal int64
+		wantLen   int
+	}{
+		{"all", "", 1, 5, 2},
+		{"pending", models.ReportStatusPending, 1, 4, 2},
+		{"pending last page", models.ReportStatusPending, 2, 4, 2},
+		{"pending past the end", models.ReportStatusPending, 3, 4, 0},
+		{"resolved", models.ReportStatusResolved, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := rs.ListReports(tt.status, tt.page, 2)
+			if err != nil {
+				t.Fatalf("ListReports() error = %v", err)
+			}
+			if total != tt.wantTotal || len(got) != tt.wantLen {
+				t.Errorf("ListReports() = %d reports of %d, want %d of %d", len(got), total, tt.wantLen, tt.wantTotal)
+			}
+			for _, r := range got {
+				if tt.status != "" && r.Status != tt.status {
+					t.Errorf("report with status %s listed for %s", r.Status, tt.status)
+				}
+				if r.Reporter.ID != moderator || r.Reported.ID != reported {
+					t.Errorf("report users not loaded: %+v", r)
+				}
+			}
+		})
+	}
+}
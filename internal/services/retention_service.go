@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+)
+
+// Files checked per query of a retention sweep
+const retentionBatch = 100
+
+// retentionMetrics are published with the other expvar metrics
+var retentionMetrics = expvar.NewMap("file_retention")
+
+// RetentionService deletes stored files once their retention window has
+// passed and no unfinished command refers to them
+type RetentionService struct {
+	db         *gorm.DB
+	files      *FileService
+	window     time.Duration
+	tagWindows map[string]time.Duration
+	dryRun     bool
+}
+
+// RetentionResult summarises one sweep
+type RetentionResult struct {
+	Files int   // Files deleted, or that would be deleted in a dry run
+	Bytes int64 // Bytes reclaimed, or that would be reclaimed in a dry run
+}
+
+// NewRetentionService creates a new retention service. Files are kept for
+// window after their latest upload. Files that commands refer to are kept
+// for the longest window of those commands instead, which is the window of
+// their tags in tagWindows or the default window. In a dry run nothing is
+// deleted.
+func NewRetentionService(db *gorm.DB, files *FileService, window time.Duration, tagWindows map[string]time.Duration, dryRun bool) *RetentionService {
+	return &RetentionService{
+		db:         db,
+		files:      files,
+		window:     window,
+		tagWindows: tagWindows,
+		dryRun:     dryRun,
+	}
+}
+
+// Run sweeps once and then every interval. It returns when ctx is cancelled.
+func (rs *RetentionService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := rs.Sweep(); err != nil {
+			logrus.WithError(err).Error("File retention sweep failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes every file whose retention window has passed and that no
+// unfinished command refers to, unless it is being downloaded
+func (rs *RetentionService) Sweep() (RetentionResult, error) {
+	var result RetentionResult
+	started := time.Now()
+	defer func() {
+		rs.record(result, started)
+	}()
+
+	// Files used more recently than the shortest window cannot have expired
+	oldest := started.Add(-rs.shortestWindow())
+	lastHash := ""
+	for {
+		var candidates []models.FileMetadata
+		err := rs.db.Select("hash", "size", "last_used_at", "created_at").
+			Where("hash > ? AND COALESCE(last_used_at, created_at) < ?", lastHash, oldest).
+			Order("hash").
+			Limit(retentionBatch).
+			Find(&candidates).Error
+		if err != nil {
+			return result, err
+		}
+		if len(candidates) == 0 {
+			return result, nil
+		}
+		lastHash = candidates[len(candidates)-1].Hash
+
+		checkedAt := time.Now()
+		retention, err := rs.fileRetention(candidates)
+		if err != nil {
+			return result, err
+		}
+
+		for _, file := range candidates {
+			keep, ok := retention[file.Hash]
+			if !ok {
+				keep = fileRetention{window: rs.window}
+			}
+			if keep.inUse {
+				continue
+			}
+			cutoff := started.Add(-keep.window)
+			lastUsed := file.CreatedAt
+			if file.LastUsedAt != nil {
+				lastUsed = *file.LastUsedAt
+			}
+			if !lastUsed.Before(cutoff) {
+				continue
+			}
+
+			log := logrus.WithFields(logrus.Fields{"file_hash": file.Hash, "size": file.Size, "window": keep.window})
+			if rs.dryRun {
+				log.Info("Retention dry run: file would be deleted")
+				result.Files++
+				result.Bytes += file.Size
+				continue
+			}
+
+			deleted, err := rs.files.deleteUnused(file.Hash, cutoff, checkedAt)
+			if err != nil {
+				log.WithError(err).Error("Failed to delete expired file")
+				continue
+			}
+			if deleted {
+				log.Info("Expired file deleted")
+				result.Files++
+				result.Bytes += file.Size
+			}
+		}
+	}
+}
+
+// fileRetention is how long the commands referring to a file keep it
+type fileRetention struct {
+	window time.Duration // Longest window of the commands
+	inUse  bool          // Whether any of the commands is unfinished
+}
+
+// fileRetention returns the retention of the files that commands refer to.
+// Files without commands are missing from the result. The references are
+// read in a single query.
+func (rs *RetentionService) fileRetention(files []models.FileMetadata) (map[string]fileRetention, error) {
+	hashes := make([]string, len(files))
+	for i, file := range files {
+		hashes[i] = file.Hash
+	}
+
+	var references []struct {
+		FileHash   string
+		Tags       string
+		Unfinished bool
+	}
+	err := rs.db.Table("command_files").
+		Select("command_files.file_hash, commands.tags, EXISTS (SELECT 1 FROM command_assignments "+
+			"WHERE command_assignments.command_id = commands.id AND command_assignments.status IN ?) AS unfinished", unfinishedStatuses).
+		Joins("JOIN commands ON commands.id = command_files.command_id").
+		Where("command_files.file_hash IN ?", hashes).
+		Scan(&references).Error
+	if err != nil {
+		return nil, err
+	}
+
+	retention := make(map[string]fileRetention)
+	for _, reference := range references {
+		keep := retention[reference.FileHash]
+		keep.window = max(keep.window, rs.commandWindow(reference.Tags))
+		keep.inUse = keep.inUse || reference.Unfinished
+		retention[reference.FileHash] = keep
+	}
+	return retention, nil
+}
+
+// commandWindow returns how long a command with the JSON encoded tags keeps
+// its files: the longest window among its tags, where tags without a window
+// of their own use the default window. Tag names are compared in lower
+// case, as the configuration stores them.
+func (rs *RetentionService) commandWindow(encodedTags string) time.Duration {
+	var tags []string
+	if err := json.Unmarshal([]byte(encodedTags), &tags); err != nil || len(tags) == 0 {
+		return rs.window
+	}
+
+	var window time.Duration
+	for _, tag := range tags {
+		tagWindow, ok := rs.tagWindows[strings.ToLower(tag)]
+		if !ok {
+			tagWindow = rs.window
+		}
+		if tagWindow > window {
+			window = tagWindow
+		}
+	}
+	return window
+}
+
+// shortestWindow returns the shortest window any file can have
+func (rs *RetentionService) shortestWindow() time.Duration {
+	shortest := rs.window
+	for _, window := range rs.tagWindows {
+		if window < shortest {
+			shortest = window
+		}
+	}
+	return shortest
+}
+
+// record logs a finished sweep and adds it to the metrics
+func (rs *RetentionService) record(result RetentionResult, started time.Time) {
+	retentionMetrics.Add("sweeps", 1)
+	retentionMetrics.Set("last_sweep_unix", expvarInt(started.Unix()))
+	if rs.dryRun {
+		retentionMetrics.Add("dry_run_files", int64(result.Files))
+		retentionMetrics.Add("dry_run_bytes", result.Bytes)
+	} else {
+		retentionMetrics.Add("files_deleted", int64(result.Files))
+		retentionMetrics.Add("bytes_reclaimed", result.Bytes)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"files":    result.Files,
+		"bytes":    result.Bytes,
+		"dry_run":  rs.dryRun,
+		"duration": time.Since(started),
+	}).Info("File retention sweep finished")
+}
+
+// expvarInt returns an expvar integer holding n
+func expvarInt(n int64) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(n)
+	return v
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"expvar"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/storage"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+	"gorm.io/gorm"
+)
+
+const (
+	testRetentionWindow = 14 * 24 * time.Hour
+	day                 = 24 * time.Hour
+)
+
+// retentionTest has a file service without scanners, so uploads are clean
+// straight away, and a sender and receiver for commands referring to files
+type retentionTest struct {
+	t        *testing.T
+	db       *gorm.DB
+	store    *storage.Store
+	files    *FileService
+	commands *CommandService
+	sender   uuid.UUID
+	receiver uuid.UUID
+}
+
+func newRetentionTest(t *testing.T) *retentionTest {
+	t.Helper()
+	db := testdb.Open(t)
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	rt := &retentionTest{
+		t:        t,
+		db:       db,
+		store:    store,
+		files:    NewFileService(db, store, 1<<20, nil, nil, nil, nil),
+		commands: NewCommandService(db, false),
+	}
+	rt.sender = createTestUser(t, db, "sender").ID
+	rt.receiver = createTestUser(t, db, "receiver").ID
+	for _, name := range []string{"quick", "archive"} {
+		if err := db.Create(&models.Tag{Name: name}).Error; err != nil {
+			t.Fatalf("failed to create tag: %v", err)
+		}
+	}
+	return rt
+}
+
+// service returns a retention service with the test window and tagWindows
+func (rt *retentionTest) service(tagWindows map[string]time.Duration, dryRun bool) *RetentionService {
+	return NewRetentionService(rt.db, rt.files, testRetentionWindow, tagWindows, dryRun)
+}
+
+// upload stores content that was last uploaded age ago and returns its hash
+func (rt *retentionTest) upload(content string, age time.Duration) string {
+	rt.t.Helper()
+	metadata, _, err := rt.files.Upload(rt.sender, "file.txt", strings.NewReader(content))
+	if err != nil {
+		rt.t.Fatalf("Upload() error = %v", err)
+	}
+	lastUsed := time.Now().Add(-age)
+	err = rt.db.Model(&models.FileMetadata{}).Where("hash = ?", metadata.Hash).
+		Updates(map[string]interface{}{"last_used_at": lastUsed, "created_at": lastUsed}).Error
+	if err != nil {
+		rt.t.Fatalf("failed to backdate file: %v", err)
+	}
+	return metadata.Hash
+}
+
+// send creates a command to the receiver with the tags that downloads the file
+func (rt *retentionTest) send(hash string, tags ...string) *models.Command {
+	rt.t.Helper()
+	command, err := rt.commands.CreateCommand(rt.sender, CreateCommandRequest{
+		Instructions: []models.Instruction{{
+			Type:    "download-file",
+			Content: &models.DownloadFileContent{FileHash: hash, FileName: "file.txt"},
+		}},
+		Receiver: rt.receiver.String(),
+		Tags:     tags,
+	})
+	if err != nil {
+		rt.t.Fatalf("CreateCommand() error = %v", err)
+	}
+	return command
+}
+
+// finish moves the command's assignments to the status
+func (rt *retentionTest) finish(command *models.Command, status string) {
+	rt.t.Helper()
+	err := rt.db.Model(&models.CommandAssignment{}).Where("command_id = ?", command.ID).Update("status", status).Error
+	if err != nil {
+		rt.t.Fatalf("failed to finish command: %v", err)
+	}
+}
+
+// sweep runs a sweep and fails the test if it fails
+func (rt *retentionTest) sweep(rs *RetentionService) RetentionResult {
+	rt.t.Helper()
+	result, err := rs.Sweep()
+	if err != nil {
+		rt.t.Fatalf("Sweep() error = %v", err)
+	}
+	return result
+}
+
+// stored reports whether both the metadata and the blob of a file exist
+func (rt *retentionTest) stored(hash string) bool {
+	rt.t.Helper()
+	var count int64
+	if err := rt.db.Model(&models.FileMetadata{}).Where("hash = ?", hash).Count(&count).Error; err != nil {
+		rt.t.Fatalf("failed to count files: %v", err)
+	}
+	exists, err := rt.store.Exists(hash)
+	if err != nil {
+		rt.t.Fatalf("Exists() error = %v", err)
+	}
+	if exists != (count == 1) {
+		rt.t.Fatalf("file %s: metadata stored %v, blob stored %v", hash, count == 1, exists)
+	}
+	return exists
+}
+
+// retentionCounter returns the current value of a retention metric
+func retentionCounter(name string) int64 {
+	if v, ok := retentionMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestRetentionSweepDeletesExpiredFiles(t *testing.T) {
+	rt := newRetentionTest(t)
+	expired := rt.upload("expired", testRetentionWindow+day)
+	recent := rt.upload("recent", testRetentionWindow-day)
+
+	files, bytes := retentionCounter("files_deleted"), retentionCounter("bytes_reclaimed")
+	result := rt.sweep(rt.service(nil, false))
+
+	if result != (RetentionResult{Files: 1, Bytes: int64(len("expired"))}) {
+		t.Errorf("Sweep() = %+v, want 1 file of %d bytes", result, len("expired"))
+	}
+	if rt.stored(expired) {
+		t.Error("expired file still stored")
+	}
+	if !rt.stored(recent) {
+		t.Error("recent file deleted")
+	}
+	if got := retentionCounter("files_deleted") - files; got != 1 {
+		t.Errorf("files_deleted grew by %d, want 1", got)
+	}
+	if got := retentionCounter("bytes_reclaimed") - bytes; got != int64(len("expired")) {
+		t.Errorf("bytes_reclaimed grew by %d, want %d", got, len("expired"))
+	}
+}
+
+func TestRetentionDryRun(t *testing.T) {
+	rt := newRetentionTest(t)
+	expired := rt.upload("expired", testRetentionWindow+day)
+
+	files, bytes := retentionCounter("dry_run_files"), retentionCounter("dry_run_bytes")
+	deleted := retentionCounter("bytes_reclaimed")
+	result := rt.sweep(rt.service(nil, true))
+
+	if result != (RetentionResult{Files: 1, Bytes: int64(len("expired"))}) {
+		t.Errorf("Sweep() = %+v, want 1 file of %d bytes", result, len("expired"))
+	}
+	if !rt.stored(expired) {
+		t.Error("dry run deleted the file")
+	}
+	if got := retentionCounter("dry_run_files") - files; got != 1 {
+		t.Errorf("dry_run_files grew by %d, want 1", got)
+	}
+	if got := retentionCounter("dry_run_bytes") - bytes; got != int64(len("expired")) {
+		t.Errorf("dry_run_bytes grew by %d, want %d", got, len("expired"))
+	}
+	if got := retentionCounter("bytes_reclaimed") - deleted; got != 0 {
+		t.Errorf("bytes_reclaimed grew by %d in a dry run", got)
+	}
+}
+
+func TestRetentionKeepsFilesOfUnfinishedCommands(t *testing.T) {
+	for _, status := range []string{
+		models.CommandStatusAwaitingApproval, models.CommandStatusPending, models.CommandStatusDelivered, models.CommandStatusAcknowledged,
+	} {
+		t.Run(status, func(t *testing.T) {
+			rt := newRetentionTest(t)
+			hash := rt.upload("referenced", testRetentionWindow+day)
+			rt.finish(rt.send(hash), status)
+
+			if result := rt.sweep(rt.service(nil, false)); result.Files != 0 {
+				t.Errorf("Sweep() deleted %d files", result.Files)
+			}
+			if !rt.stored(hash) {
+				t.Error("file of an unfinished command deleted")
+			}
+		})
+	}
+
+	for _, status := range []string{
+		models.CommandStatusCompleted, models.CommandStatusFailed, models.CommandStatusCancelled, models.CommandStatusExpired,
+	} {
+		t.Run(status, func(t *testing.T) {
+			rt := newRetentionTest(t)
+			hash := rt.upload("referenced", testRetentionWindow+day)
+			rt.finish(rt.send(hash), status)
+
+			rt.sweep(rt.service(nil, false))
+			if rt.stored(hash) {
+				t.Error("file of a finished command kept")
+			}
+		})
+	}
+
+	t.Run("one of several commands unfinished", func(t *testing.T) {
+		rt := newRetentionTest(t)
+		hash := rt.upload("referenced", testRetentionWindow+day)
+		rt.finish(rt.send(hash), models.CommandStatusCompleted)
+		rt.send(hash)
+
+		rt.sweep(rt.service(nil, false))
+		if !rt.stored(hash) {
+			t.Error("file of an unfinished command deleted")
+		}
+	})
+}
+
+func TestRetentionTagWindows(t *testing.T) {
+	tagWindows := map[string]time.Duration{"quick": day, "archive": 30 * day}
+	tests := []struct {
+		name string
+		age  time.Duration
+		tags [][]string // Tags of each finished command referring to the file
+		kept bool
+	}{
+		{"shorter tag window passed", 2 * day, [][]string{{"quick"}}, false},
+		{"shorter tag window not passed", day / 2, [][]string{{"quick"}}, true},
+		{"default window of untagged command", 2 * day, [][]string{nil}, true},
+		{"longest tag of a command", 2 * day, [][]string{{"quick", "archive"}}, true},
+		{"tag without window counts as default", 2 * day, [][]string{{"quick", "general"}}, true},
+		{"longest window of several commands", 2 * day, [][]string{{"quick"}, nil}, true},
+		{"longer tag window not passed", 20 * day, [][]string{{"archive"}}, true},
+		{"longer tag window passed", 31 * day, [][]string{{"archive"}}, false},
+		{"unreferenced file", 20 * day, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newRetentionTest(t)
+			if err := rt.db.Create(&models.Tag{Name: "general"}).Error; err != nil {
+				t.Fatalf("failed to create tag: %v", err)
+			}
+			hash := rt.upload("file", tt.age)
+			for _, tags := range tt.tags {
+				rt.finish(rt.send(hash, tags...), models.CommandStatusCompleted)
+			}
+
+			rt.sweep(rt.service(tagWindows, false))
+			if kept := rt.stored(hash); kept != tt.kept {
+				t.Errorf("file kept = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestRetentionSkipsFilesBeingDownloaded(t *testing.T) {
+	rt := newRetentionTest(t)
+	hash := rt.upload("downloading", testRetentionWindow+day)
+	rs := rt.service(nil, false)
+
+	_, download, err := rt.files.Open(hash)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if result := rt.sweep(rs); result.Files != 0 {
+		t.Errorf("Sweep() deleted %d files during a download", result.Files)
+	}
+	if !rt.stored(hash) {
+		t.Fatal("file deleted during a download")
+	}
+
+	if err := download.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if result := rt.sweep(rs); result.Files != 1 {
+		t.Errorf("Sweep() after the download deleted %d files, want 1", result.Files)
+	}
+	if rt.stored(hash) {
+		t.Error("file kept after the download finished")
+	}
+}
+
+func TestRetentionRecordsCommandFiles(t *testing.T) {
+	rt := newRetentionTest(t)
+	hash := rt.upload("referenced", 0)
+	other := rt.upload("other", 0)
+
+	command, err := rt.commands.CreateCommand(rt.sender, CreateCommandRequest{
+		Instructions: []models.Instruction{
+			{Type: "download-file", Content: &models.DownloadFileContent{FileHash: hash, FileName: "a.txt"}},
+			{Type: "download-file", Content: &models.DownloadFileContent{FileHash: hash, FileName: "b.txt"}},
+		},
+		Receiver: rt.receiver.String(),
+	})
+	if err != nil {
+		t.Fatalf("CreateCommand() error = %v", err)
+	}
+
+	var references []models.CommandFile
+	if err := rt.db.Find(&references).Error; err != nil {
+		t.Fatalf("failed to load command files: %v", err)
+	}
+	if len(references) != 1 || references[0].CommandID != command.ID || references[0].FileHash != hash {
+		t.Errorf("command files = %+v, want one for %s", references, hash)
+	}
+	for _, reference := range references {
+		if reference.FileHash == other {
+			t.Error("unrelated file recorded")
+		}
+	}
+}
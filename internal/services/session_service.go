@@ -0,0 +1,210 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/websocket"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SessionService issues access and refresh tokens for signed-in devices and revokes them
+type SessionService struct {
+	db              *gorm.DB
+	jwtManager      *auth.JWTManager
+	refreshDuration time.Duration
+	hub             *websocket.Hub
+}
+
+// NewSessionService creates a new session service
+func NewSessionService(db *gorm.DB, jwtManager *auth.JWTManager, refreshDuration time.Duration, hub *websocket.Hub) *SessionService {
+	return &SessionService{
+		db:              db,
+		jwtManager:      jwtManager,
+		refreshDuration: refreshDuration,
+		hub:             hub,
+	}
+}
+
+// SessionInfo describes the device a session is created for
+type SessionInfo struct {
+	DeviceName string
+	ClientType string
+	UserAgent  string
+	IPAddress  string
+}
+
+// TokenPair is a short-lived access token and the refresh token that renews it
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int // Access token lifetime in seconds
+	SessionID    uuid.UUID
+}
+
+// CreateSession starts a session for a user who just signed in
+func (ss *SessionService) CreateSession(userID uuid.UUID, info SessionInfo) (*TokenPair, error) {
+	refreshToken, refreshHash, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:           userID,
+		RefreshTokenHash: refreshHash,
+		DeviceName:       info.DeviceName,
+		ClientType:       info.ClientType,
+		UserAgent:        info.UserAgent,
+		IPAddress:        info.IPAddress,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(ss.refreshDuration),
+	}
+	if err := ss.db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return ss.tokenPair(&session, refreshToken)
+}
+
+// Refresh exchanges a refresh token for a new token pair and rotates the
+// refresh token. Presenting a refresh token that was already rotated revokes
+// the session, since it means the token was copied.
+func (ss *SessionService) Refresh(refreshToken string) (*TokenPair, error) {
+	hash := auth.HashToken(refreshToken)
+	newToken, newHash, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.Session
+	reused := false
+	err = ss.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("refresh_token_hash = ?", hash).
+			First(&session).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("previous_token_hash = ? AND revoked_at IS NULL", hash).
+				First(&session).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			if err != nil {
+				return err
+			}
+			reused = true
+			return tx.Model(&session).Update("revoked_at", time.Now()).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !session.IsActive(now) {
+			return ErrInvalidRefreshToken
+		}
+
+		var user models.User
+		if err := tx.First(&user, "id = ?", session.UserID).Error; err != nil {
+			return err
+		}
+		if user.IsRestricted(now) {
+			return ErrAccountRestricted
+		}
+
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"refresh_token_hash":  newHash,
+			"previous_token_hash": hash,
+			"last_used_at":        now,
+		}).Error
+	})
+	if reused {
+		logrus.WithFields(logrus.Fields{
+			"session_id": session.ID,
+			"user_id":    session.UserID,
+		}).Warn("Rotated refresh token reused, session revoked")
+		ss.hub.DisconnectSession(session.ID)
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ss.tokenPair(&session, newToken)
+}
+
+// tokenPair issues an access token for the session alongside its refresh token
+func (ss *SessionService) tokenPair(session *models.Session, refreshToken string) (*TokenPair, error) {
+	accessToken, err := ss.jwtManager.GenerateToken(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(ss.jwtManager.Duration().Seconds()),
+		SessionID:    session.ID,
+	}, nil
+}
+
+// IsActive reports whether a session of the user exists and has not been revoked or expired
+func (ss *SessionService) IsActive(userID, sessionID uuid.UUID) bool {
+	var session models.Session
+	err := ss.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	return err == nil && session.IsActive(time.Now())
+}
+
+// GetActiveSessions returns the user's sessions that are still usable, most recently used first
+func (ss *SessionService) GetActiveSessions(userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	err := ss.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession ends one of the user's sessions and disconnects its live connections
+func (ss *SessionService) RevokeSession(userID, sessionID uuid.UUID) error {
+	result := ss.db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	ss.hub.DisconnectSession(sessionID)
+	return nil
+}
+
+// RevokeAllSessions ends every session of the user except the one given, which
+// may be uuid.Nil, and disconnects their live connections
+func (ss *SessionService) RevokeAllSessions(userID, exceptID uuid.UUID) error {
+	var ids []uuid.UUID
+	err := ss.db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, exceptID).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	err = ss.db.Model(&models.Session{}).
+		Where("id IN ?", ids).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		ss.hub.DisconnectSession(id)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+)
+
+// TagService handles content tags and broadcast subscriptions
+type TagService struct {
+	db *gorm.DB
+}
+
+// NewTagService creates a new tag service
+func NewTagService(db *gorm.DB) *TagService {
+	return &TagService{db: db}
+}
+
+// GetAllTags returns every defined tag ordered by name
+func (ts *TagService) GetAllTags() ([]models.Tag, error) {
+	var tags []models.Tag
+	err := ts.db.Order("name ASC").Find(&tags).Error
+	return tags, err
+}
+
+// GetTagByName retrieves a tag by name
+func (ts *TagService) GetTagByName(name string) (*models.Tag, error) {
+	var tag models.Tag
+	if err := ts.db.Where("name = ?", name).First(&tag).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownTag
+		}
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// GetSubscriptions returns the tags a user receives broadcasts for
+func (ts *TagService) GetSubscriptions(userID uuid.UUID) ([]models.TagSubscription, error) {
+	var subscriptions []models.TagSubscription
+	err := ts.db.Where("user_id = ?", userID).
+		Preload("Tag").
+		Order("created_at ASC").
+		Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// Subscribe subscribes a user to broadcasts carrying the tag. Subscribing twice is a no-op.
+func (ts *TagService) Subscribe(userID uuid.UUID, tagName string) (*models.TagSubscription, error) {
+	tag, err := ts.GetTagByName(tagName)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription := models.TagSubscription{UserID: userID, TagID: tag.ID}
+	err = ts.db.Where("user_id = ? AND tag_id = ?", userID, tag.ID).
+		FirstOrCreate(&subscription).Error
+	if err != nil {
+		return nil, err
+	}
+	subscription.Tag = *tag
+	return &subscription, nil
+}
+
+// Unsubscribe removes a user's subscription to the tag
+func (ts *TagService) Unsubscribe(userID uuid.UUID, tagName string) error {
+	tag, err := ts.GetTagByName(tagName)
+	if err != nil {
+		return err
+	}
+	return ts.db.Where("user_id = ? AND tag_id = ?", userID, tag.ID).
+		Delete(&models.TagSubscription{}).Error
+}
+
+// GetPreferences returns the tag preferences a user has set
+func (ts *TagService) GetPreferences(userID uuid.UUID) ([]models.UserTagPreference, error) {
+	var preferences []models.UserTagPreference
+	err := ts.db.Where("user_id = ?", userID).
+		Preload("Tag").
+		Order("created_at ASC").
+		Find(&preferences).Error
+	return preferences, err
+}
+
+// SetPreference sets how a user wants commands carrying the tag handled,
+// replacing any earlier preference for the tag
+func (ts *TagService) SetPreference(userID uuid.UUID, tagName, preference string) (*models.UserTagPreference, error) {
+	if !models.IsValidTagPreference(preference) {
+		return nil, ErrInvalidTagPreference
+	}
+
+	tag, err := ts.GetTagByName(tagName)
+	if err != nil {
+		return nil, err
+	}
+
+	userPreference := models.UserTagPreference{UserID: userID, TagID: tag.ID}
+	err = ts.db.Where("user_id = ? AND tag_id = ?", userID, tag.ID).
+		Assign(models.UserTagPreference{Preference: preference}).
+		FirstOrCreate(&userPreference).Error
+	if err != nil {
+		return nil, err
+	}
+	userPreference.Tag = *tag
+	return &userPreference, nil
+}
+
+// ClearPreference removes a user's preference for the tag, which allows it again
+func (ts *TagService) ClearPreference(userID uuid.UUID, tagName string) error {
+	tag, err := ts.GetTagByName(tagName)
+	if err != nil {
+		return err
+	}
+	return ts.db.Where("user_id = ? AND tag_id = ?", userID, tag.ID).
+		Delete(&models.UserTagPreference{}).Error
+}
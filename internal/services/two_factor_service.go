@@ -0,0 +1,380 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Recovery codes issued at once; issuing new ones replaces the old
+	recoveryCodeCount = 10
+
+	// How long the second step of a login can be completed
+	loginChallengeTTL = 5 * time.Minute
+
+	// Wrong codes allowed before a login challenge is discarded
+	maxChallengeAttempts = 5
+)
+
+// TwoFactorService manages TOTP two-factor authentication and the second step of logins
+type TwoFactorService struct {
+	db     *gorm.DB
+	audit  *AuditService
+	issuer string
+}
+
+// NewTwoFactorService creates a new two-factor service. issuer names the
+// account in authenticator apps.
+func NewTwoFactorService(db *gorm.DB, auditService *AuditService, issuer string) *TwoFactorService {
+	return &TwoFactorService{
+		db:     db,
+		audit:  auditService,
+		issuer: issuer,
+	}
+}
+
+// TwoFactorSetup is a new TOTP secret for the user to add to their authenticator
+type TwoFactorSetup struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+// TwoFactorStatus tells whether a user has two-factor authentication enabled
+type TwoFactorStatus struct {
+	Enabled                bool
+	RecoveryCodesRemaining int64
+}
+
+// GetStatus returns whether the user has two-factor authentication enabled
+// and how many recovery codes they have left
+func (ts *TwoFactorService) GetStatus(userID uuid.UUID) (*TwoFactorStatus, error) {
+	enabled, err := ts.IsEnabled(userID)
+	if err != nil || !enabled {
+		return &TwoFactorStatus{}, err
+	}
+
+	status := TwoFactorStatus{Enabled: true}
+	err = ts.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&status.RecoveryCodesRemaining).Error
+	return &status, err
+}
+
+// IsEnabled reports whether the user must give a code to log in
+func (ts *TwoFactorService) IsEnabled(userID uuid.UUID) (bool, error) {
+	var count int64
+	err := ts.db.Model(&models.TwoFactor{}).
+		Where("user_id = ? AND enabled_at IS NOT NULL", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Setup starts enrollment with a new secret, replacing one from an unfinished
+// enrollment. Two-factor authentication takes effect once Enable confirms a code.
+func (ts *TwoFactorService) Setup(userID uuid.UUID) (*TwoFactorSetup, error) {
+	var user models.User
+	if err := ts.db.First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ts.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.TwoFactor
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existing, "user_id = ?", userID).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return tx.Create(&models.TwoFactor{UserID: userID, Secret: secret}).Error
+		case err != nil:
+			return err
+		case existing.IsEnabled():
+			return ErrTwoFactorEnabled
+		}
+		return tx.Model(&existing).Updates(map[string]interface{}{
+			"secret":         secret,
+			"last_used_step": 0,
+			"created_at":     time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(ts.issuer, user.LoginName, secret),
+	}, nil
+}
+
+// Enable finishes enrollment with a code from the authenticator and returns
+// the user's recovery codes, which are only available now
+func (ts *TwoFactorService) Enable(userID uuid.UUID, code string) ([]string, error) {
+	var codes []string
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := lockTwoFactor(tx, userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTwoFactorNotSetUp
+		}
+		if err != nil {
+			return err
+		}
+		if twoFactor.IsEnabled() {
+			return ErrTwoFactorEnabled
+		}
+
+		ok, err := useTOTP(tx, twoFactor, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+
+		if err := tx.Model(twoFactor).Update("enabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ts.audit.Record(models.AuditLog{
+		Event:  models.AuditEventTwoFactorEnabled,
+		UserID: &userID,
+		Detail: "Two-factor authentication enabled",
+	})
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off. It requires a current code
+// from the authenticator; recovery codes are not accepted.
+func (ts *TwoFactorService) Disable(userID uuid.UUID, code string) error {
+	err := ts.withCurrentCode(userID, code, func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.TwoFactor{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	ts.audit.Record(models.AuditLog{
+		Event:  models.AuditEventTwoFactorDisabled,
+		UserID: &userID,
+		Detail: "Two-factor authentication disabled",
+	})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. It requires a
+// current code from the authenticator.
+func (ts *TwoFactorService) RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error) {
+	var codes []string
+	err := ts.withCurrentCode(userID, code, func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// withCurrentCode runs fn in a transaction if the user has two-factor
+// authentication enabled and the code is valid
+func (ts *TwoFactorService) withCurrentCode(userID uuid.UUID, code string, fn func(tx *gorm.DB) error) error {
+	// An accepted code is used up, so a wrong code is reported after the transaction commits
+	var outcome error
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := lockTwoFactor(tx, userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTwoFactorNotEnabled
+		}
+		if err != nil {
+			return err
+		}
+		if !twoFactor.IsEnabled() {
+			return ErrTwoFactorNotEnabled
+		}
+
+		ok, err := useTOTP(tx, twoFactor, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			outcome = ErrInvalidTwoFactorCode
+			return nil
+		}
+		return fn(tx)
+	})
+	if err != nil {
+		return err
+	}
+	return outcome
+}
+
+// StartChallenge begins the second step of a login and returns the token
+// that completes it, which is valid for loginChallengeTTL
+func (ts *TwoFactorService) StartChallenge(userID uuid.UUID, deviceName, clientType string) (string, int, error) {
+	now := time.Now()
+	if err := ts.db.Where("expires_at < ?", now).Delete(&models.LoginChallenge{}).Error; err != nil {
+		return "", 0, err
+	}
+
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		return "", 0, err
+	}
+
+	challenge := models.LoginChallenge{
+		UserID:     userID,
+		TokenHash:  tokenHash,
+		DeviceName: deviceName,
+		ClientType: clientType,
+		ExpiresAt:  now.Add(loginChallengeTTL),
+	}
+	if err := ts.db.Create(&challenge).Error; err != nil {
+		return "", 0, err
+	}
+	return token, int(loginChallengeTTL.Seconds()), nil
+}
+
+// ChallengeUser returns the user a login challenge belongs to without using
+// it up, or ErrInvalidLoginChallenge if the challenge is unknown or expired
+func (ts *TwoFactorService) ChallengeUser(token string) (*models.User, error) {
+	var challenge models.LoginChallenge
+	err := ts.db.Preload("User").
+		Where("token_hash = ? AND expires_at > ?", auth.HashToken(token), time.Now()).
+		First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidLoginChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &challenge.User, nil
+}
+
+// CompleteChallenge finishes a login with a code from the authenticator or a
+// recovery code. The challenge is discarded once it succeeds or after
+// maxChallengeAttempts wrong codes. The returned challenge has its user loaded.
+func (ts *TwoFactorService) CompleteChallenge(token, code string) (*models.LoginChallenge, error) {
+	var challenge models.LoginChallenge
+	// Wrong codes are counted, so they are reported after the transaction commits
+	var outcome error
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("User").
+			Where("token_hash = ?", auth.HashToken(token)).
+			First(&challenge).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidLoginChallenge
+		}
+		if err != nil {
+			return err
+		}
+		if time.Now().After(challenge.ExpiresAt) {
+			outcome = ErrInvalidLoginChallenge
+			return tx.Delete(&challenge).Error
+		}
+
+		twoFactor, err := lockTwoFactor(tx, challenge.UserID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		ok := false
+		if err == nil && twoFactor.IsEnabled() {
+			if ok, err = useTOTP(tx, twoFactor, code); err != nil {
+				return err
+			}
+			if !ok {
+				if ok, err = useRecoveryCode(tx, challenge.UserID, code); err != nil {
+					return err
+				}
+			}
+		}
+
+		if ok {
+			return tx.Delete(&challenge).Error
+		}
+
+		outcome = ErrInvalidTwoFactorCode
+		challenge.Attempts++
+		if challenge.Attempts >= maxChallengeAttempts {
+			return tx.Delete(&challenge).Error
+		}
+		return tx.Model(&challenge).Update("attempts", challenge.Attempts).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if outcome != nil {
+		return nil, outcome
+	}
+	return &challenge, nil
+}
+
+// lockTwoFactor loads a user's two-factor settings for update
+func lockTwoFactor(tx *gorm.DB, userID uuid.UUID) (*models.TwoFactor, error) {
+	var twoFactor models.TwoFactor
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&twoFactor, "user_id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &twoFactor, nil
+}
+
+// useTOTP checks a code from the authenticator and records its time step, so
+// the same code, or an older one, cannot be used again
+func useTOTP(tx *gorm.DB, twoFactor *models.TwoFactor, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(twoFactor.Secret, code, time.Now())
+	if !ok || step <= twoFactor.LastUsedStep {
+		return false, nil
+	}
+
+	twoFactor.LastUsedStep = step
+	return true, tx.Model(twoFactor).Update("last_used_step", step).Error
+}
+
+// useRecoveryCode uses up one of the user's recovery codes if the code matches it
+func useRecoveryCode(tx *gorm.DB, userID uuid.UUID, code string) (bool, error) {
+	result := tx.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, auth.HashToken(auth.NormalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// replaceRecoveryCodes issues a new set of recovery codes for the user, invalidating the old ones
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := auth.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: auth.HashToken(code)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
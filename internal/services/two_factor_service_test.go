@@ -0,0 +1,113 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/auth"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+)
+
+// totpAt computes the authenticator code of a secret for a time step
+func totpAt(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %v", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// newTwoFactorTest returns a two-factor service and a user who enabled two-factor
+// authentication with a code of the current step, their secret and recovery codes
+func newTwoFactorTest(t *testing.T) (*TwoFactorService, uuid.UUID, string, []string) {
+	t.Helper()
+	db := testdb.Open(t)
+	ts := NewTwoFactorService(db, NewAuditService(db), "ControlMe")
+	userID := createTestUser(t, db, "alice").ID
+
+	setup, err := ts.Setup(userID)
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	codes, err := ts.Enable(userID, totpAt(t, setup.Secret, auth.TOTPStep(time.Now())))
+	if err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("Enable() returned %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	return ts, userID, setup.Secret, codes
+}
+
+func TestTwoFactorCodesCannotBeReplayed(t *testing.T) {
+	ts, userID, secret, _ := newTwoFactorTest(t)
+	step := auth.TOTPStep(time.Now())
+
+	// The code that enabled two-factor authentication is used up
+	if _, err := ts.RegenerateRecoveryCodes(userID, totpAt(t, secret, step)); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("RegenerateRecoveryCodes() with the used code error = %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	// A code of the next step is within the allowed drift
+	if _, err := ts.RegenerateRecoveryCodes(userID, totpAt(t, secret, step+1)); err != nil {
+		t.Fatalf("RegenerateRecoveryCodes() with the next code error = %v", err)
+	}
+	for _, replay := range []int64{step + 1, step, step - 1} {
+		if err := ts.Disable(userID, totpAt(t, secret, replay)); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Errorf("Disable() with the code of step %+d error = %v, want ErrInvalidTwoFactorCode", replay-step, err)
+		}
+	}
+	if enabled, err := ts.IsEnabled(userID); err != nil || !enabled {
+		t.Errorf("IsEnabled() = %v, %v after replayed codes", enabled, err)
+	}
+}
+
+func TestTwoFactorRecoveryCodesAreSingleUse(t *testing.T) {
+	ts, userID, _, codes := newTwoFactorTest(t)
+
+	complete := func(code string) error {
+		t.Helper()
+		token, _, err := ts.StartChallenge(userID, "laptop", "web")
+		if err != nil {
+			t.Fatalf("StartChallenge() error = %v", err)
+		}
+		_, err = ts.CompleteChallenge(token, code)
+		return err
+	}
+
+	// Recovery codes may be typed in upper case and without the dash
+	if err := complete(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))); err != nil {
+		t.Fatalf("CompleteChallenge() with a recovery code error = %v", err)
+	}
+	if err := complete(codes[0]); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("CompleteChallenge() with a used recovery code error = %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	status, err := ts.GetStatus(userID)
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if status.RecoveryCodesRemaining != recoveryCodeCount-1 {
+		t.Errorf("RecoveryCodesRemaining = %d, want %d", status.RecoveryCodesRemaining, recoveryCodeCount-1)
+	}
+
+	// Recovery codes cannot turn two-factor authentication off
+	if err := ts.Disable(userID, codes[1]); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("Disable() with a recovery code error = %v, want ErrInvalidTwoFactorCode", err)
+	}
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Expired uploads deleted per sweep
+const uploadExpiryBatch = 100
+
+// UploadService handles resumable uploads. Chunks are appended to a
+// partial blob until the whole file has arrived; the file is then checked
+// against the hash given when the upload was created and handed to the
+// file service like any other upload.
+type UploadService struct {
+	db         *gorm.DB
+	store      *storage.Store
+	files      *FileService
+	expiration time.Duration
+	maxOpen    int
+
+	busy sync.Map // Uploads a chunk is being written to or that are being deleted, so neither happens twice at once
+}
+
+// NewUploadService creates a new upload service. Unfinished uploads expire
+// once no chunk has arrived for the expiration. A user can have up to
+// maxOpen unfinished uploads at a time, or any number if it is 0.
+func NewUploadService(db *gorm.DB, store *storage.Store, files *FileService, expiration time.Duration, maxOpen int) *UploadService {
+	return &UploadService{
+		db:         db,
+		store:      store,
+		files:      files,
+		expiration: expiration,
+		maxOpen:    maxOpen,
+	}
+}
+
+// MaxSize returns the largest upload accepted, in bytes
+func (us *UploadService) MaxSize() int64 {
+	return us.files.MaxSize()
+}
+
+// Create starts an upload of length bytes to be stored under the file name.
+// The finished file must have the hex SHA-256 hash sha256. A user with
+// maxOpen unfinished uploads gets ErrTooManyUploads.
+func (us *UploadService) Create(userID uuid.UUID, fileName string, length int64, sha256 string) (*models.FileUpload, error) {
+	name, err := cleanFileName(fileName)
+	if err != nil {
+		return nil, err
+	}
+	sha256 = strings.ToLower(sha256)
+	if !storage.ValidHash(sha256) {
+		return nil, ErrInvalidUploadHash
+	}
+	if length < 0 {
+		return nil, ErrInvalidUploadLength
+	}
+	if length > us.files.MaxSize() {
+		return nil, ErrFileTooLarge
+	}
+	if err := us.files.checkUploader(userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload := models.FileUpload{
+		UserID:       userID,
+		FileName:     name,
+		UploadLength: length,
+		SHA256:       sha256,
+		ExpiresAt:    now.Add(us.expiration),
+	}
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		if us.maxOpen > 0 {
+			// Lock the user so concurrent requests cannot both pass the limit
+			var user models.User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+				return err
+			}
+			var open int64
+			err := tx.Model(&models.FileUpload{}).
+				Where("user_id = ? AND completed = ? AND expires_at > ?", userID, false, now).
+				Count(&open).Error
+			if err != nil {
+				return err
+			}
+			if open >= int64(us.maxOpen) {
+				return ErrTooManyUploads
+			}
+		}
+		return tx.Create(&upload).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := us.store.CreatePartial(upload.ID.String()); err != nil {
+		us.db.Delete(&upload)
+		return nil, err
+	}
+
+	// An empty file is complete as soon as it exists
+	if length == 0 {
+		return us.complete(&upload)
+	}
+	return &upload, nil
+}
+
+// Get returns an upload of the user
+func (us *UploadService) Get(userID, uploadID uuid.UUID) (*models.FileUpload, error) {
+	var upload models.FileUpload
+	err := us.db.First(&upload, "id = ? AND user_id = ?", uploadID, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !upload.Completed && time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadNotFound
+	}
+	return &upload, nil
+}
+
+// Append writes the chunk read from r to an upload at offset, which must be
+// the upload's current offset. Bytes received before the chunk broke off
+// are kept, so the client can resume from the returned offset. When the
+// last byte arrives the file is verified and stored. length is the size of
+// the chunk, or -1 if unknown; a chunk running past the end of the upload
+// is rejected and none of it is kept.
+func (us *UploadService) Append(userID, uploadID uuid.UUID, offset int64, r io.Reader, length int64) (*models.FileUpload, error) {
+	if _, busy := us.busy.LoadOrStore(uploadID, struct{}{}); busy {
+		return nil, ErrUploadBusy
+	}
+	defer us.busy.Delete(uploadID)
+
+	upload, err := us.Get(userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.UploadOffset {
+		return upload, ErrUploadOffsetMismatch
+	}
+	if upload.Completed {
+		return upload, nil
+	}
+	if err := us.files.checkUploader(userID); err != nil {
+		return nil, err
+	}
+	// Every byte arrived before, but storing the file failed
+	if offset == upload.UploadLength {
+		return us.complete(upload)
+	}
+
+	remaining := upload.UploadLength - offset
+	if length > remaining {
+		return upload, ErrUploadChunkTooLong
+	}
+
+	// One byte more than fits shows that the chunk is too long. The next
+	// chunk truncates the partial file back to the offset.
+	written, appendErr := us.store.AppendPartial(upload.ID.String(), offset, r, remaining+1)
+	if written > remaining {
+		return upload, ErrUploadChunkTooLong
+	}
+	if written > 0 {
+		expiresAt := time.Now().Add(us.expiration)
+		err := us.db.Model(upload).Updates(map[string]interface{}{
+			"upload_offset": offset + written,
+			"expires_at":    expiresAt,
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+		upload.UploadOffset, upload.ExpiresAt = offset+written, expiresAt
+	}
+	if appendErr != nil {
+		return upload, appendErr
+	}
+
+	if upload.UploadOffset == upload.UploadLength {
+		return us.complete(upload)
+	}
+	return upload, nil
+}
+
+// complete verifies the hash of a fully received upload and stores the file.
+// An upload with the wrong hash is discarded.
+func (us *UploadService) complete(upload *models.FileUpload) (*models.FileUpload, error) {
+	size, err := us.store.CommitPartial(upload.ID.String(), upload.SHA256)
+	if errors.Is(err, storage.ErrHashMismatch) {
+		us.discard(upload)
+		return nil, ErrUploadHashMismatch
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		// Committed by an earlier attempt that failed after moving the blob
+		exists, existsErr := us.store.Exists(upload.SHA256)
+		if existsErr != nil {
+			return nil, existsErr
+		}
+		if !exists {
+			return nil, err
+		}
+		size, err = upload.UploadLength, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, err := us.files.contentTypeOf(upload.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = us.files.register(upload.UserID, upload.FileName, upload.SHA256, size, contentType)
+	if errors.Is(err, ErrFileBlocked) {
+		us.discard(upload)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.db.Model(upload).Update("completed", true).Error; err != nil {
+		return nil, err
+	}
+	upload.Completed = true
+	return upload, nil
+}
+
+// discard deletes an upload and its partial blob
+func (us *UploadService) discard(upload *models.FileUpload) {
+	if err := us.store.DeletePartial(upload.ID.String()); err != nil {
+		logrus.WithError(err).WithField("upload_id", upload.ID).Error("Failed to delete partial upload")
+	}
+	if err := us.db.Delete(upload).Error; err != nil {
+		logrus.WithError(err).WithField("upload_id", upload.ID).Error("Failed to delete upload")
+	}
+}
+
+// ExpireUploads deletes uploads past their expiry every interval, see
+// DeleteExpired. It returns when ctx is cancelled.
+func (us *UploadService) ExpireUploads(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := us.DeleteExpired(time.Now()); err != nil {
+			logrus.WithError(err).Error("Failed to delete expired uploads")
+		}
+	}
+}
+
+// DeleteExpired deletes up to uploadExpiryBatch uploads that expired before
+// now, with the partial blobs of those that never finished, and returns how
+// many it deleted. Uploads a chunk is being written to are left for the next
+// sweep.
+func (us *UploadService) DeleteExpired(now time.Time) (int, error) {
+	var uploads []models.FileUpload
+	err := us.db.Where("expires_at < ?", now).
+		Limit(uploadExpiryBatch).
+		Find(&uploads).Error
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for i := range uploads {
+		if us.deleteIfExpired(uploads[i].ID, now) {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// deleteIfExpired claims an upload so no chunk can be written to it, and
+// deletes it if it is still expired. A chunk may have extended it since it
+// was listed.
+func (us *UploadService) deleteIfExpired(uploadID uuid.UUID, now time.Time) bool {
+	if _, busy := us.busy.LoadOrStore(uploadID, struct{}{}); busy {
+		return false
+	}
+	defer us.busy.Delete(uploadID)
+
+	var upload models.FileUpload
+	err := us.db.First(&upload, "id = ? AND expires_at < ?", uploadID, now).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logrus.WithError(err).WithField("upload_id", uploadID).Error("Failed to load expired upload")
+		}
+		return false
+	}
+	us.discard(&upload)
+	return true
+}
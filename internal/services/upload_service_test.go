@@ -0,0 +1,167 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/storage"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// newUploadTest returns an upload service allowing maxOpen unfinished
+// uploads per user, its database and store, and a user
+func newUploadTest(t *testing.T, maxOpen int) (*UploadService, *gorm.DB, *storage.Store, models.User) {
+	t.Helper()
+	db := testdb.Open(t)
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	files := NewFileService(db, store, 1<<20, nil, nil, nil, nil)
+	return NewUploadService(db, store, files, time.Hour, maxOpen), db, store, createTestUser(t, db, "alice")
+}
+
+// hashOf returns the hex SHA-256 hash of content
+func hashOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadAppendRejectsOverlongChunk(t *testing.T) {
+	uploads, _, _, user := newUploadTest(t, 0)
+	content := "hello world"
+	upload, err := uploads.Create(user.ID, "hello.txt", int64(len(content)), hashOf(content))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err := uploads.Append(user.ID, upload.ID, 0, strings.NewReader("hello"), 5); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		length int64
+	}{
+		{"declared length", int64(len(content))},
+		{"unknown length", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := uploads.Append(user.ID, upload.ID, 5, strings.NewReader(" world and more"), tt.length)
+			if !errors.Is(err, ErrUploadChunkTooLong) {
+				t.Fatalf("Append() error = %v, want ErrUploadChunkTooLong", err)
+			}
+			if got.UploadOffset != 5 || got.Completed {
+				t.Errorf("upload after Append() = offset %d, completed %v, want offset 5", got.UploadOffset, got.Completed)
+			}
+		})
+	}
+
+	got, err := uploads.Append(user.ID, upload.ID, 5, strings.NewReader(" world"), -1)
+	if err != nil {
+		t.Fatalf("Append() with the rest error = %v", err)
+	}
+	if !got.Completed || got.UploadOffset != int64(len(content)) {
+		t.Errorf("upload after the last chunk = offset %d, completed %v", got.UploadOffset, got.Completed)
+	}
+}
+
+func TestUploadLimitsOpenUploads(t *testing.T) {
+	uploads, db, _, user := newUploadTest(t, 2)
+	other := createTestUser(t, db, "bob")
+
+	var open []*models.FileUpload
+	for i := range 2 {
+		upload, err := uploads.Create(user.ID, "file.txt", 5, hashOf("hello"))
+		if err != nil {
+			t.Fatalf("Create() %d error = %v", i+1, err)
+		}
+		open = append(open, upload)
+	}
+	if _, err := uploads.Create(user.ID, "file.txt", 5, hashOf("hello")); !errors.Is(err, ErrTooManyUploads) {
+		t.Fatalf("Create() over the limit error = %v, want ErrTooManyUploads", err)
+	}
+	if _, err := uploads.Create(other.ID, "file.txt", 5, hashOf("hello")); err != nil {
+		t.Errorf("Create() by another user error = %v", err)
+	}
+
+	// A finished upload frees its place
+	if _, err := uploads.Append(user.ID, open[0].ID, 0, strings.NewReader("hello"), 5); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if _, err := uploads.Create(user.ID, "file.txt", 5, hashOf("hello")); err != nil {
+		t.Fatalf("Create() after finishing one error = %v", err)
+	}
+
+	// So does an expired one
+	if err := db.Model(open[1]).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("failed to expire upload: %v", err)
+	}
+	if _, err := uploads.Create(user.ID, "file.txt", 5, hashOf("hello")); err != nil {
+		t.Errorf("Create() after one expired error = %v", err)
+	}
+}
+
+func TestUploadDeleteExpired(t *testing.T) {
+	uploads, db, store, user := newUploadTest(t, 0)
+	var created []*models.FileUpload
+	for range 3 {
+		upload, err := uploads.Create(user.ID, "file.txt", 5, hashOf("hello"))
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		created = append(created, upload)
+	}
+	expired, busy, current := created[0], created[1], created[2]
+
+	later := time.Now().Add(2 * time.Hour)
+	// A chunk is being written to busy, which extends it once it has arrived
+	uploads.busy.Store(busy.ID, struct{}{})
+	if err := db.Model(current).Update("expires_at", later.Add(time.Hour)).Error; err != nil {
+		t.Fatalf("failed to extend upload: %v", err)
+	}
+
+	deleted, err := uploads.DeleteExpired(later)
+	if err != nil {
+		t.Fatalf("DeleteExpired() error = %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpired() = %d, want 1", deleted)
+	}
+	if _, busy := uploads.busy.Load(expired.ID); busy {
+		t.Error("deleted upload still claimed")
+	}
+
+	exists := func(upload *models.FileUpload) bool {
+		t.Helper()
+		var count int64
+		if err := db.Model(&models.FileUpload{}).Where("id = ?", upload.ID).Count(&count).Error; err != nil {
+			t.Fatalf("failed to count uploads: %v", err)
+		}
+		return count == 1
+	}
+	if exists(expired) {
+		t.Error("expired upload kept")
+	}
+	if err := store.CreatePartial(expired.ID.String()); err != nil {
+		t.Errorf("partial blob of the expired upload not deleted: %v", err)
+	}
+	if !exists(busy) {
+		t.Error("upload deleted while a chunk was being written")
+	}
+	if !exists(current) {
+		t.Error("extended upload deleted")
+	}
+
+	uploads.busy.Delete(busy.ID)
+	if deleted, err := uploads.DeleteExpired(later); err != nil || deleted != 1 {
+		t.Errorf("DeleteExpired() once the chunk finished = %d, %v, want 1", deleted, err)
+	}
+}
@@ -1,13 +1,16 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"github.com/thecontrolapp/controlme-go/internal/auth"
 	"github.com/thecontrolapp/controlme-go/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // UserService handles user-related operations
@@ -24,15 +27,16 @@ func NewUserService(db *gorm.DB, authService *auth.AuthService) *UserService {
 	}
 }
 
-// AuthenticateUser authenticates a user with username and password
-func (us *UserService) AuthenticateUser(username, password string) (*models.User, error) {
+// AuthenticateUser authenticates a user with login name and password. Screen
+// names are not unique, so they are not accepted. An unknown login name and a
+// wrong password both return ErrInvalidCredentials.
+func (us *UserService) AuthenticateUser(loginName, password string) (*models.User, error) {
 	var user models.User
 
-	// Try to find user by login name or screen name
-	err := us.db.Where("login_name = ? OR screen_name = ?", username, username).First(&user).Error
+	err := us.db.Where("login_name = ?", loginName).First(&user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrInvalidCredentials
 		}
 		return nil, fmt.Errorf("database error: %w", err)
 	}
@@ -40,16 +44,45 @@ func (us *UserService) AuthenticateUser(username, password string) (*models.User
 	// Verify password
 	err = us.Auth.PasswordManager.VerifyPassword(password, user.Password)
 	if err != nil {
-		return nil, fmt.Errorf("invalid password")
+		return nil, ErrInvalidCredentials
+	}
+
+	// The password is known only now, so this is when an old hash can be upgraded
+	if us.Auth.PasswordManager.NeedsRehash(user.Password) {
+		us.rehashPassword(&user, password)
+	}
+
+	if user.IsRestricted(time.Now()) {
+		return nil, ErrAccountRestricted
 	}
 
 	// Update login date
 	user.LoginDate = time.Now()
-	us.db.Save(&user)
+	us.db.Model(&user).Update("login_date", user.LoginDate)
 
 	return &user, nil
 }
 
+// rehashPassword replaces the user's password hash with one made by the
+// current algorithm and parameters. Failing leaves the old hash, which still works.
+func (us *UserService) rehashPassword(user *models.User, password string) {
+	hash, err := us.Auth.PasswordManager.HashPassword(password)
+	if err != nil {
+		logrus.WithError(err).WithField("user_id", user.ID).Warn("Failed to rehash password")
+		return
+	}
+
+	// Only replace the hash that was verified, not a password changed in the meantime
+	err = us.db.Model(&models.User{}).
+		Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", hash).Error
+	if err != nil {
+		logrus.WithError(err).WithField("user_id", user.ID).Warn("Failed to store rehashed password")
+		return
+	}
+	user.Password = hash
+}
+
 // CreateUserRequest is used for creating a new user via modern API
 type CreateUserRequest struct {
 	LoginName   string `json:"login_name" binding:"required"`
@@ -59,8 +92,13 @@ type CreateUserRequest struct {
 	RandomOptIn bool   `json:"random_opt_in" binding:"required"`
 }
 
-// CreateUser creates a new user with the modern API
+// CreateUser creates a new user with the modern API. A password the policy
+// refuses returns an *auth.PasswordPolicyError.
 func (us *UserService) CreateUser(req CreateUserRequest) (*models.User, error) {
+	if err := us.Auth.PasswordPolicy.Check(req.Password, req.LoginName, req.Email); err != nil {
+		return nil, err
+	}
+
 	hashedPassword, err := us.Auth.PasswordManager.HashPassword(req.Password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %w", err)
@@ -72,7 +110,7 @@ func (us *UserService) CreateUser(req CreateUserRequest) (*models.User, error) {
 		Password:    hashedPassword,
 		Email:       req.Email,
 		RandomOptIn: req.RandomOptIn,
-		Role:        "user",
+		Role:        models.RoleUser,
 	}
 	err = us.db.Create(&user).Error
 	if err != nil {
@@ -81,10 +119,10 @@ func (us *UserService) CreateUser(req CreateUserRequest) (*models.User, error) {
 	return &user, nil
 }
 
-// GetAllUsers returns all users
-func (us *UserService) GetAllUsers() ([]models.User, error) {
+// GetAllUsers returns all users, except those who have blocked the viewer
+func (us *UserService) GetAllUsers(viewerID uuid.UUID) ([]models.User, error) {
 	var users []models.User
-	err := us.db.Find(&users).Error
+	err := us.db.Scopes(notBlocking(viewerID)).Find(&users).Error
 	return users, err
 }
 
@@ -101,6 +139,67 @@ func (us *UserService) GetUserByID(id uuid.UUID) (*models.User, error) {
 	return &user, nil
 }
 
+// GetVisibleUser retrieves a user by ID, reporting users who have blocked the viewer as not found
+func (us *UserService) GetVisibleUser(id, viewerID uuid.UUID) (*models.User, error) {
+	var user models.User
+	err := us.db.Scopes(notBlocking(viewerID)).First(&user, "users.id = ?", id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return &user, nil
+}
+
+// GetUserRole returns the role of a user
+func (us *UserService) GetUserRole(id uuid.UUID) (string, error) {
+	user, err := us.GetUserByID(id)
+	if err != nil {
+		return "", err
+	}
+	return user.Role, nil
+}
+
+// SetUserRole changes a user's role. The last admin cannot be demoted.
+func (us *UserService) SetUserRole(id uuid.UUID, role string) (*models.User, error) {
+	if !models.IsValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	var user models.User
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", id).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		if user.Role == models.RoleAdmin && role != models.RoleAdmin {
+			// Lock every admin so concurrent demotions cannot both pass the check
+			var admins []uuid.UUID
+			err := tx.Model(&models.User{}).
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("role = ?", models.RoleAdmin).
+				Pluck("id", &admins).Error
+			if err != nil {
+				return err
+			}
+			if len(admins) <= 1 {
+				return ErrLastAdmin
+			}
+		}
+
+		return tx.Model(&user).Update("role", role).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 // GetUserByUsername retrieves a user by username (login name or screen name)
 func (us *UserService) GetUserByUsername(username string) (*models.User, error) {
 	var user models.User
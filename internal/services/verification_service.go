@@ -0,0 +1,152 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/mailer"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// How long a verification code can be used
+	verificationCodeTTL = 24 * time.Hour
+
+	// Wrong guesses allowed before a code stops working
+	maxVerificationAttempts = 5
+
+	// Minimum time between two verification emails to the same user
+	verificationResendInterval = time.Minute
+)
+
+// VerificationService verifies users' email addresses with emailed codes
+type VerificationService struct {
+	db              *gorm.DB
+	mailer          mailer.Mailer
+	verificationURL string
+}
+
+// NewVerificationService creates a new verification service. verificationURL
+// is the page that confirms a code from a link; without it emails only carry the code.
+func NewVerificationService(db *gorm.DB, mailer mailer.Mailer, verificationURL string) *VerificationService {
+	return &VerificationService{
+		db:              db,
+		mailer:          mailer,
+		verificationURL: verificationURL,
+	}
+}
+
+// SendCode emails the user a new verification code, replacing any earlier one.
+// Codes can only be sent once per verificationResendInterval.
+func (vs *VerificationService) SendCode(userID uuid.UUID) error {
+	code, err := newVerificationCode()
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	err = vs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if user.Verified {
+			return ErrAlreadyVerified
+		}
+
+		now := time.Now()
+		if user.VerificationSentAt != nil {
+			if wait := user.VerificationSentAt.Add(verificationResendInterval).Sub(now); wait > 0 {
+				return &RateLimitError{RetryAfter: wait}
+			}
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"verified_code":         code,
+			"verification_sent_at":  now,
+			"verification_attempts": 0,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return vs.mailer.Send(vs.verificationMessage(&user, code))
+}
+
+// verificationMessage builds the email carrying a verification code
+func (vs *VerificationService) verificationMessage(user *models.User, code int) mailer.Message {
+	body := fmt.Sprintf("Hi %s,\n\nYour ControlMe verification code is %d.\n", user.ScreenName, code)
+	if vs.verificationURL != "" {
+		link := vs.verificationURL + "?code=" + url.QueryEscape(strconv.Itoa(code))
+		body += "\nOr confirm your email address by opening this link while signed in:\n" + link + "\n"
+	}
+	body += fmt.Sprintf("\nThe code expires in %d hours. If you did not create an account, ignore this email.\n", int(verificationCodeTTL.Hours()))
+
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your ControlMe email address",
+		Body:    body,
+	}
+}
+
+// newVerificationCode returns a random six-digit code
+func newVerificationCode() (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(900000))
+	if err != nil {
+		return 0, err
+	}
+	return 100000 + int(n.Int64()), nil
+}
+
+// Verify marks the user's email address as verified if the code matches.
+// Every wrong guess counts against the code, which stops working after
+// maxVerificationAttempts of them.
+func (vs *VerificationService) Verify(userID uuid.UUID, code string) error {
+	// Wrong guesses are counted, so they are reported after the transaction commits
+	var outcome error
+	err := vs.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if user.Verified {
+			return ErrAlreadyVerified
+		}
+		if user.VerifiedCode == 0 || user.VerificationSentAt == nil ||
+			time.Since(*user.VerificationSentAt) > verificationCodeTTL ||
+			user.VerificationAttempts >= maxVerificationAttempts {
+			return ErrVerificationExpired
+		}
+
+		if subtle.ConstantTimeCompare([]byte(code), []byte(strconv.Itoa(user.VerifiedCode))) != 1 {
+			outcome = ErrInvalidVerificationCode
+			return tx.Model(&user).Update("verification_attempts", gorm.Expr("verification_attempts + 1")).Error
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"verified":              true,
+			"verified_code":         0,
+			"verification_sent_at":  nil,
+			"verification_attempts": 0,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return outcome
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/mailer"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"github.com/thecontrolapp/controlme-go/internal/testdb"
+	"gorm.io/gorm"
+)
+
+var verificationCodePattern = regexp.MustCompile(`verification code is (\d{6})`)
+
+// newVerificationTest returns a verification service sending to a memory
+// mailer, and an unverified user
+func newVerificationTest(t *testing.T) (*VerificationService, *mailer.MemoryMailer, *gorm.DB, uuid.UUID) {
+	t.Helper()
+	db := testdb.Open(t)
+	mail := mailer.NewMemoryMailer()
+	user := models.User{
+		ScreenName: "Alice",
+		LoginName:  "alice",
+		Email:      "alice@example.com",
+		Password:   "unused",
+		Role:       models.RoleUser,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return NewVerificationService(db, mail, "https://example.com/verify"), mail, db, user.ID
+}
+
+// lastCode returns the code in the last message the mailer recorded
+func lastCode(t *testing.T, mail *mailer.MemoryMailer) string {
+	t.Helper()
+	messages := mail.Messages()
+	if len(messages) == 0 {
+		t.Fatal("no verification email sent")
+	}
+	match := verificationCodePattern.FindStringSubmatch(messages[len(messages)-1].Body)
+	if match == nil {
+		t.Fatalf("no code in the verification email:\n%s", messages[len(messages)-1].Body)
+	}
+	return match[1]
+}
+
+// wrongCode returns a six-digit code other than code
+func wrongCode(code string) string {
+	n, _ := strconv.Atoi(code)
+	return strconv.Itoa(100000 + (n-100000+1)%900000)
+}
+
+// backdateCode makes the user's code look as if it was sent d ago
+func backdateCode(t *testing.T, db *gorm.DB, userID uuid.UUID, d time.Duration) {
+	t.Helper()
+	err := db.Model(&models.User{}).Where("id = ?", userID).Update("verification_sent_at", time.Now().Add(-d)).Error
+	if err != nil {
+		t.Fatalf("failed to backdate code: %v", err)
+	}
+}
+
+func TestVerificationSendAndConfirm(t *testing.T) {
+	vs, mail, db, userID := newVerificationTest(t)
+
+	if err := vs.SendCode(userID); err != nil {
+		t.Fatalf("SendCode() error = %v", err)
+	}
+	messages := mail.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(messages))
+	}
+	if messages[0].To != "alice@example.com" {
+		t.Errorf("email sent to %q, want alice@example.com", messages[0].To)
+	}
+	code := lastCode(t, mail)
+	if !strings.Contains(messages[0].Body, "https://example.com/verify?code="+code) {
+		t.Errorf("email has no verification link:\n%s", messages[0].Body)
+	}
+
+	if err := vs.Verify(userID, code); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	if !user.Verified || user.VerifiedCode != 0 || user.VerificationSentAt != nil {
+		t.Errorf("user after Verify() = verified %v, code %d, sent at %v", user.Verified, user.VerifiedCode, user.VerificationSentAt)
+	}
+
+	if err := vs.Verify(userID, code); !errors.Is(err, ErrAlreadyVerified) {
+		t.Errorf("second Verify() error = %v, want ErrAlreadyVerified", err)
+	}
+	if err := vs.SendCode(userID); !errors.Is(err, ErrAlreadyVerified) {
+		t.Errorf("SendCode() after verifying error = %v, want ErrAlreadyVerified", err)
+	}
+	if len(mail.Messages()) != 1 {
+		t.Errorf("sent %d emails, want 1", len(mail.Messages()))
+	}
+}
+
+func TestVerificationResend(t *testing.T) {
+	vs, mail, db, userID := newVerificationTest(t)
+
+	if err := vs.SendCode(userID); err != nil {
+		t.Fatalf("SendCode() error = %v", err)
+	}
+	first := lastCode(t, mail)
+
+	var rateLimit *RateLimitError
+	if err := vs.SendCode(userID); !errors.As(err, &rateLimit) {
+		t.Fatalf("immediate resend error = %v, want a RateLimitError", err)
+	}
+	if rateLimit.RetryAfter <= 0 || rateLimit.RetryAfter > verificationResendInterval {
+		t.Errorf("RetryAfter = %s, want up to %s", rateLimit.RetryAfter, verificationResendInterval)
+	}
+	if len(mail.Messages()) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(mail.Messages()))
+	}
+
+	// A wrong guess on the first code does not carry over to the next one
+	if err := vs.Verify(userID, wrongCode(first)); !errors.Is(err, ErrInvalidVerificationCode) {
+		t.Fatalf("Verify() with a wrong code error = %v, want ErrInvalidVerificationCode", err)
+	}
+
+	backdateCode(t, db, userID, verificationResendInterval)
+	if err := vs.SendCode(userID); err != nil {
+		t.Fatalf("resend error = %v", err)
+	}
+	if len(mail.Messages()) != 2 {
+		t.Fatalf("sent %d emails, want 2", len(mail.Messages()))
+	}
+	second := lastCode(t, mail)
+
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	if user.VerificationAttempts != 0 {
+		t.Errorf("VerificationAttempts after resend = %d, want 0", user.VerificationAttempts)
+	}
+
+	if first != second {
+		if err := vs.Verify(userID, first); !errors.Is(err, ErrInvalidVerificationCode) {
+			t.Errorf("Verify() with the replaced code error = %v, want ErrInvalidVerificationCode", err)
+		}
+	}
+	if err := vs.Verify(userID, second); err != nil {
+		t.Errorf("Verify() with the new code error = %v", err)
+	}
+}
+
+func TestVerificationExpiry(t *testing.T) {
+	vs, mail, db, userID := newVerificationTest(t)
+
+	if err := vs.Verify(userID, "123456"); !errors.Is(err, ErrVerificationExpired) {
+		t.Errorf("Verify() before any code was sent error = %v, want ErrVerificationExpired", err)
+	}
+
+	if err := vs.SendCode(userID); err != nil {
+		t.Fatalf("SendCode() error = %v", err)
+	}
+	code := lastCode(t, mail)
+
+	backdateCode(t, db, userID, verificationCodeTTL+time.Minute)
+	if err := vs.Verify(userID, code); !errors.Is(err, ErrVerificationExpired) {
+		t.Errorf("Verify() with an expired code error = %v, want ErrVerificationExpired", err)
+	}
+
+	// An expired code can be replaced straight away
+	if err := vs.SendCode(userID); err != nil {
+		t.Fatalf("SendCode() after expiry error = %v", err)
+	}
+	if err := vs.Verify(userID, lastCode(t, mail)); err != nil {
+		t.Errorf("Verify() with the new code error = %v", err)
+	}
+}
+
+func TestVerificationAttemptLimit(t *testing.T) {
+	vs, mail, db, userID := newVerificationTest(t)
+
+	if err := vs.SendCode(userID); err != nil {
+		t.Fatalf("SendCode() error = %v", err)
+	}
+	code := lastCode(t, mail)
+
+	for i := range maxVerificationAttempts {
+		if err := vs.Verify(userID, wrongCode(code)); !errors.Is(err, ErrInvalidVerificationCode) {
+			t.Fatalf("wrong guess %d error = %v, want ErrInvalidVerificationCode", i+1, err)
+		}
+	}
+
+	// The right code no longer works once the guesses are used up
+	if err := vs.Verify(userID, code); !errors.Is(err, ErrVerificationExpired) {
+		t.Fatalf("Verify() after %d wrong guesses error = %v, want ErrVerificationExpired", maxVerificationAttempts, err)
+	}
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	if user.Verified {
+		t.Error("user verified after too many wrong guesses")
+	}
+	if user.VerificationAttempts != maxVerificationAttempts {
+		t.Errorf("VerificationAttempts = %d, want %d", user.VerificationAttempts, maxVerificationAttempts)
+	}
+
+	backdateCode(t, db, userID, verificationResendInterval)
+	if err := vs.SendCode(userID); err != nil {
+		t.Fatalf("SendCode() error = %v", err)
+	}
+	if err := vs.Verify(userID, lastCode(t, mail)); err != nil {
+		t.Errorf("Verify() with a new code error = %v", err)
+	}
+}
+
+func TestVerificationUnknownUser(t *testing.T) {
+	vs, mail, _, _ := newVerificationTest(t)
+
+	if err := vs.SendCode(uuid.New()); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("SendCode() error = %v, want ErrUserNotFound", err)
+	}
+	if err := vs.Verify(uuid.New(), "123456"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Verify() error = %v, want ErrUserNotFound", err)
+	}
+	if len(mail.Messages()) != 0 {
+		t.Errorf("sent %d emails, want 0", len(mail.Messages()))
+	}
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// prefixLength is the number of hash characters naming a blob's directory
+const prefixLength = 2
+
+// ErrNotFound is returned for a hash that has no stored blob
+var ErrNotFound = errors.New("blob not found")
+
+// ErrTooLarge is returned when a blob exceeds the size limit of Put
+var ErrTooLarge = errors.New("blob too large")
+
+// ErrOffsetMismatch is returned when appending to a partial blob that holds
+// fewer bytes than the offset
+var ErrOffsetMismatch = errors.New("partial blob is shorter than the offset")
+
+// ErrHashMismatch is returned when a finished partial blob does not have the expected hash
+var ErrHashMismatch = errors.New("blob hash does not match")
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidHash reports whether hash is a lowercase hex SHA-256 digest
+func ValidHash(hash string) bool {
+	return hashPattern.MatchString(hash)
+}
+
+// Store keeps blobs on disk under their SHA-256 hash at
+// {root}/{hash_prefix}/{full_hash}, so identical content is stored once.
+// Partial blobs of resumable uploads are kept by upload ID until they are
+// complete.
+type Store struct {
+	root string
+}
+
+// NewStore creates a store in root, creating the directory if needed
+func NewStore(root string) (*Store, error) {
+	store := &Store{root: root}
+	for _, dir := range []string{store.tempDir(), store.partialDir()} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// tempDir holds blobs being written. It is inside root so finished blobs
+// can be renamed into place.
+func (s *Store) tempDir() string {
+	return filepath.Join(s.root, ".tmp")
+}
+
+// partialDir holds the partial blobs of resumable uploads
+func (s *Store) partialDir() string {
+	return filepath.Join(s.root, ".partial")
+}
+
+// partialPath returns where the partial blob of an upload is kept
+func (s *Store) partialPath(id string) string {
+	return filepath.Join(s.partialDir(), filepath.Base(id))
+}
+
+// Path returns where the blob with the hash is stored
+func (s *Store) Path(hash string) string {
+	return filepath.Join(s.root, hash[:prefixLength], hash)
+}
+
+// Put stores the content read from r and returns its hash and size. If a
+// blob with the same hash exists, the new copy is discarded. Content longer
+// than maxSize bytes returns ErrTooLarge.
+func (s *Store) Put(r io.Reader, maxSize int64) (hash string, size int64, err error) {
+	temp, err := os.CreateTemp(s.tempDir(), "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	tempPath := temp.Name()
+	defer func() {
+		// Cleared once the file was moved into place
+		if tempPath != "" {
+			os.Remove(tempPath)
+		}
+	}()
+
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(temp, hasher), io.LimitReader(r, maxSize+1))
+	if err == nil && size > maxSize {
+		err = ErrTooLarge
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash = hex.EncodeToString(hasher.Sum(nil))
+	if err := s.place(tempPath, hash); err != nil {
+		return "", 0, err
+	}
+	tempPath = ""
+	return hash, size, nil
+}
+
+// place moves a finished temporary file to the blob path of hash, unless the blob already exists
+func (s *Store) place(tempPath, hash string) error {
+	path := s.Path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return os.Remove(tempPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// Open opens the blob with the hash for reading
+func (s *Store) Open(hash string) (*os.File, error) {
+	if !ValidHash(hash) {
+		return nil, ErrNotFound
+	}
+	file, err := os.Open(s.Path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Exists reports whether a blob with the hash is stored
+func (s *Store) Exists(hash string) (bool, error) {
+	if !ValidHash(hash) {
+		return false, nil
+	}
+	_, err := os.Stat(s.Path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the blob with the hash. Deleting a missing blob is not an error.
+func (s *Store) Delete(hash string) error {
+	if !ValidHash(hash) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.Path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CreatePartial creates the empty partial blob of a resumable upload
+func (s *Store) CreatePartial(id string) error {
+	file, err := os.OpenFile(s.partialPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// AppendPartial writes the content read from r, up to maxBytes, to the
+// partial blob of an upload starting at offset, and returns how many bytes
+// were written. Bytes already past offset, left by a write whose offset was
+// never recorded, are overwritten. Bytes written before an error are kept.
+func (s *Store) AppendPartial(id string, offset int64, r io.Reader, maxBytes int64) (int64, error) {
+	file, err := os.OpenFile(s.partialPath(id), os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < offset {
+		return 0, ErrOffsetMismatch
+	}
+	if info.Size() > offset {
+		if err := file.Truncate(offset); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(file, io.LimitReader(r, maxBytes))
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	return written, err
+}
+
+// CommitPartial moves the finished partial blob of an upload into the store
+// and returns its size. If its hash is not expectedHash, ErrHashMismatch is
+// returned and the partial blob is left in place.
+func (s *Store) CommitPartial(id, expectedHash string) (int64, error) {
+	path := s.partialPath(id)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	file.Close()
+	if err != nil {
+		return 0, err
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != expectedHash {
+		return 0, ErrHashMismatch
+	}
+
+	if err := s.place(path, expectedHash); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// DeletePartial removes the partial blob of an upload. Deleting a missing
+// partial blob is not an error.
+func (s *Store) DeletePartial(id string) error {
+	err := os.Remove(s.partialPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hashOf returns the hex SHA-256 hash of content
+func hashOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	root := t.TempDir()
+	store, err := NewStore(root)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	return store, root
+}
+
+// readBlob returns the content of the blob with the hash
+func readBlob(t *testing.T, store *Store, hash string) string {
+	t.Helper()
+	file, err := store.Open(hash)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("failed to read blob: %v", err)
+	}
+	return string(content)
+}
+
+// dirEntries returns the names of the files in dir
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestValidHash(t *testing.T) {
+	tests := []struct {
+		hash string
+		want bool
+	}{
+		{hashOf("hello"), true},
+		{strings.ToUpper(hashOf("hello")), false},
+		{hashOf("hello")[:63], false},
+		{hashOf("hello") + "0", false},
+		{"../" + hashOf("hello")[3:], false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidHash(tt.hash); got != tt.want {
+			t.Errorf("ValidHash(%q) = %v, want %v", tt.hash, got, tt.want)
+		}
+	}
+}
+
+func TestPutStoresByHash(t *testing.T) {
+	store, root := newTestStore(t)
+
+	hash, size, err := store.Put(strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if hash != hashOf("hello") || size != 5 {
+		t.Errorf("Put() = %s, %d, want %s, 5", hash, size, hashOf("hello"))
+	}
+
+	want := filepath.Join(root, hash[:2], hash)
+	if store.Path(hash) != want {
+		t.Errorf("Path() = %s, want %s", store.Path(hash), want)
+	}
+	if content, err := os.ReadFile(want); err != nil || string(content) != "hello" {
+		t.Errorf("blob at %s = %q, %v, want hello", want, content, err)
+	}
+	if names := dirEntries(t, filepath.Join(root, ".tmp")); len(names) != 0 {
+		t.Errorf("temporary files left behind: %v", names)
+	}
+	if exists, err := store.Exists(hash); err != nil || !exists {
+		t.Errorf("Exists() = %v, %v, want true", exists, err)
+	}
+}
+
+func TestPutDeduplicates(t *testing.T) {
+	store, root := newTestStore(t)
+
+	first, _, err := store.Put(strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	info, err := os.Stat(store.Path(first))
+	if err != nil {
+		t.Fatalf("failed to stat blob: %v", err)
+	}
+
+	second, _, err := store.Put(strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatalf("second Put() error = %v", err)
+	}
+	if second != first {
+		t.Errorf("second Put() = %s, want %s", second, first)
+	}
+	again, err := os.Stat(store.Path(first))
+	if err != nil {
+		t.Fatalf("failed to stat blob: %v", err)
+	}
+	if !os.SameFile(info, again) {
+		t.Error("second Put() replaced the stored blob")
+	}
+	if names := dirEntries(t, filepath.Join(root, ".tmp")); len(names) != 0 {
+		t.Errorf("temporary files left behind: %v", names)
+	}
+}
+
+func TestPutTooLarge(t *testing.T) {
+	store, root := newTestStore(t)
+
+	if _, _, err := store.Put(strings.NewReader("hello world"), 5); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Put() error = %v, want ErrTooLarge", err)
+	}
+	if exists, _ := store.Exists(hashOf("hello world")); exists {
+		t.Error("oversized blob stored")
+	}
+	if names := dirEntries(t, filepath.Join(root, ".tmp")); len(names) != 0 {
+		t.Errorf("temporary files left behind: %v", names)
+	}
+}
+
+func TestOpenAndDeleteMissing(t *testing.T) {
+	store, _ := newTestStore(t)
+	hash := hashOf("missing")
+
+	if _, err := store.Open(hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Open("../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() of an invalid hash error = %v, want ErrNotFound", err)
+	}
+	if exists, err := store.Exists("not-a-hash"); exists || err != nil {
+		t.Errorf("Exists() of an invalid hash = %v, %v, want false", exists, err)
+	}
+	if err := store.Delete(hash); err != nil {
+		t.Errorf("Delete() of a missing blob error = %v", err)
+	}
+
+	stored, _, err := store.Put(strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := store.Delete(stored); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Open(stored); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() after Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPartialUpload(t *testing.T) {
+	store, root := newTestStore(t)
+	const id = "upload-1"
+
+	if err := store.CreatePartial(id); err != nil {
+		t.Fatalf("CreatePartial() error = %v", err)
+	}
+	if err := store.CreatePartial(id); !errors.Is(err, os.ErrExist) {
+		t.Errorf("second CreatePartial() error = %v, want os.ErrExist", err)
+	}
+
+	if n, err := store.AppendPartial(id, 0, strings.NewReader("hello"), 100); err != nil || n != 5 {
+		t.Fatalf("AppendPartial() = %d, %v, want 5", n, err)
+	}
+	if _, err := store.AppendPartial(id, 6, strings.NewReader("world"), 100); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("AppendPartial() past the end error = %v, want ErrOffsetMismatch", err)
+	}
+	// A chunk whose offset was never recorded is overwritten by the retry
+	if _, err := store.AppendPartial(id, 5, strings.NewReader(" lost"), 100); err != nil {
+		t.Fatalf("AppendPartial() error = %v", err)
+	}
+	if n, err := store.AppendPartial(id, 5, strings.NewReader(" world and more"), 6); err != nil || n != 6 {
+		t.Fatalf("AppendPartial() = %d, %v, want 6", n, err)
+	}
+
+	if _, err := store.CommitPartial(id, hashOf("hello")); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("CommitPartial() with the wrong hash error = %v, want ErrHashMismatch", err)
+	}
+	if names := dirEntries(t, filepath.Join(root, ".partial")); len(names) != 1 {
+		t.Fatalf("partial blobs after a hash mismatch = %v, want the upload kept", names)
+	}
+
+	hash := hashOf("hello world")
+	size, err := store.CommitPartial(id, hash)
+	if err != nil || size != 11 {
+		t.Fatalf("CommitPartial() = %d, %v, want 11", size, err)
+	}
+	if content := readBlob(t, store, hash); content != "hello world" {
+		t.Errorf("committed blob = %q, want hello world", content)
+	}
+	if _, err := os.Stat(filepath.Join(root, hash[:2], hash)); err != nil {
+		t.Errorf("committed blob not at its content address: %v", err)
+	}
+	if names := dirEntries(t, filepath.Join(root, ".partial")); len(names) != 0 {
+		t.Errorf("partial blobs after commit = %v, want none", names)
+	}
+	if _, err := store.CommitPartial(id, hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second CommitPartial() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPartialUploadOfStoredContent(t *testing.T) {
+	store, root := newTestStore(t)
+	hash, _, err := store.Put(strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := store.CreatePartial("upload-1"); err != nil {
+		t.Fatalf("CreatePartial() error = %v", err)
+	}
+	if _, err := store.AppendPartial("upload-1", 0, strings.NewReader("hello"), 5); err != nil {
+		t.Fatalf("AppendPartial() error = %v", err)
+	}
+
+	if _, err := store.CommitPartial("upload-1", hash); err != nil {
+		t.Fatalf("CommitPartial() error = %v", err)
+	}
+	if content := readBlob(t, store, hash); content != "hello" {
+		t.Errorf("blob = %q, want hello", content)
+	}
+	if names := dirEntries(t, filepath.Join(root, ".partial")); len(names) != 0 {
+		t.Errorf("duplicate partial blob kept: %v", names)
+	}
+}
+
+func TestPartialIDCannotEscape(t *testing.T) {
+	store, root := newTestStore(t)
+
+	if err := store.CreatePartial("../escaped"); err != nil {
+		t.Fatalf("CreatePartial() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial blob created outside the partial directory: %v", err)
+	}
+	if err := store.DeletePartial("../escaped"); err != nil {
+		t.Fatalf("DeletePartial() error = %v", err)
+	}
+	if err := store.DeletePartial("../escaped"); err != nil {
+		t.Errorf("DeletePartial() of a missing blob error = %v", err)
+	}
+	if _, err := store.AppendPartial("missing", 0, strings.NewReader("hello"), 5); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AppendPartial() of a missing upload error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Package testdb provides a throwaway database for tests that need one.
+package testdb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/thecontrolapp/controlme-go/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open returns a SQLite database in the test's temporary directory with
+// every model migrated. It is closed when the test finishes.
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(
+		&models.User{},
+		&models.Tag{},
+		&models.TagSubscription{},
+		&models.UserTagPreference{},
+		&models.Session{},
+		&models.AuditLog{},
+		&models.LoginThrottle{},
+		&models.PasswordReset{},
+		&models.DevicePairing{},
+		&models.DeviceCredential{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.LoginChallenge{},
+		&models.FileMetadata{},
+		&models.FileName{},
+		&models.FileUpload{},
+		&models.Command{},
+		&models.CommandEvent{},
+		&models.CommandAssignment{},
+		&models.CommandFile{},
+		&models.Block{},
+		&models.Report{},
+	)
+	if err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	return db
+}
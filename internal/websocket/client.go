@@ -0,0 +1,214 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+
+	// Maximum message size allowed from peer
+	maxMessageSize = 64 * 1024
+
+	// Number of outbound messages buffered per client
+	sendBufferSize = 256
+)
+
+// Client types
+const (
+	ClientTypeWeb     = "web"
+	ClientTypeDesktop = "desktop"
+)
+
+// NewClient creates a client for an upgraded connection authenticated with a session
+func NewClient(hub *Hub, conn *websocket.Conn, userID, sessionID uuid.UUID, clientType string) *Client {
+	client := &Client{
+		conn:       conn,
+		userID:     userID,
+		sessionID:  sessionID,
+		clientType: clientType,
+		canSend:    true,
+		send:       make(chan []byte, sendBufferSize),
+		hub:        hub,
+	}
+	client.touch()
+	return client
+}
+
+// NewDeviceClient creates a desktop client for an upgraded connection
+// authenticated with a device credential. canSend is false for credentials
+// that are only allowed to receive commands.
+func NewDeviceClient(hub *Hub, conn *websocket.Conn, userID, deviceID uuid.UUID, canSend bool) *Client {
+	client := &Client{
+		conn:       conn,
+		userID:     userID,
+		deviceID:   deviceID,
+		clientType: ClientTypeDesktop,
+		canSend:    canSend,
+		send:       make(chan []byte, sendBufferSize),
+		hub:        hub,
+	}
+	client.touch()
+	return client
+}
+
+// UserID returns the ID of the user that owns the connection
+func (c *Client) UserID() uuid.UUID {
+	return c.userID
+}
+
+// SessionID returns the ID of the session the connection was authenticated with
+func (c *Client) SessionID() uuid.UUID {
+	return c.sessionID
+}
+
+// DeviceID returns the ID of the device credential the connection was authenticated with
+func (c *Client) DeviceID() uuid.UUID {
+	return c.deviceID
+}
+
+// CanSend reports whether the connection may send commands
+func (c *Client) CanSend() bool {
+	return c.canSend
+}
+
+// ClientType returns the client type (web, desktop)
+func (c *Client) ClientType() string {
+	return c.clientType
+}
+
+// LastSeen returns when the last frame was received from the peer
+func (c *Client) LastSeen() time.Time {
+	return time.Unix(0, c.lastSeen.Load())
+}
+
+// touch records activity from the peer and extends the read deadline
+func (c *Client) touch() {
+	now := time.Now()
+	c.lastSeen.Store(now.UnixNano())
+	if c.conn != nil {
+		c.conn.SetReadDeadline(now.Add(c.hub.staleAfter()))
+	}
+}
+
+// Register adds the client to the hub and starts its read and write pumps
+func (c *Client) Register() {
+	c.hub.register <- c
+	go c.WritePump()
+	go c.ReadPump()
+}
+
+// Send queues a message for delivery to this client only
+func (c *Client) Send(message Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal message")
+		return
+	}
+
+	if !c.trySend(data) {
+		c.hub.requestUnregister(c)
+	}
+}
+
+// trySend queues data without blocking. It reports false if the buffer
+// is full or the client has already been closed.
+func (c *Client) trySend(data []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes the send channel once. Only the hub calls it, from removeClient.
+func (c *Client) close() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// ReadPump pumps messages from the websocket connection to the hub.
+// It runs in its own goroutine and unregisters the client when the connection ends.
+func (c *Client) ReadPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.touch()
+	c.conn.SetPongHandler(func(string) error {
+		c.touch()
+		return nil
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logrus.WithError(err).WithField("user_id", c.userID).Warn("WebSocket closed unexpectedly")
+			}
+			return
+		}
+		c.touch()
+
+		var message IncomingMessage
+		if err := json.Unmarshal(data, &message); err != nil || message.Type == "" {
+			c.Send(NewErrorMessage(ErrorCodeInvalidRequest, "Malformed message"))
+			continue
+		}
+
+		c.hub.dispatch(c, message)
+	}
+}
+
+// WritePump pumps messages from the hub to the websocket connection.
+// It runs in its own goroutine and is the only writer on the connection.
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(c.hub.heartbeatInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The hub closed the channel
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
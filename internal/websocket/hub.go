@@ -2,15 +2,22 @@ package websocket
 
 import (
 	"encoding/json"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
+	"github.com/thecontrolapp/controlme-go/internal/config"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to the clients
+// Hub maintains the set of active clients and broadcasts messages to the clients.
+// It is safe for concurrent use.
 type Hub struct {
+	// Guards clients and userConnections. Only the Run loop writes to them.
+	mu sync.RWMutex
+
 	// Registered clients
 	clients map[*Client]bool
 
@@ -25,6 +32,18 @@ type Hub struct {
 
 	// User connections map for targeted messaging
 	userConnections map[uuid.UUID][]*Client
+
+	// Handlers for inbound client messages, keyed by message type
+	handlers map[string]MessageHandler
+
+	// Called when a user's first client connects
+	onUserConnected func(userID uuid.UUID)
+
+	// Interval between server heartbeats
+	heartbeatInterval time.Duration
+
+	// Number of heartbeat intervals a client may stay silent before it is dropped
+	maxMissedHeartbeats int
 }
 
 // Client represents a WebSocket client
@@ -35,93 +54,289 @@ type Client struct {
 	// User ID
 	userID uuid.UUID
 
+	// Session the connection was authenticated with, if any
+	sessionID uuid.UUID
+
+	// Device credential the connection was authenticated with, if any
+	deviceID uuid.UUID
+
+	// Whether the connection may send commands
+	canSend bool
+
 	// Client type (web, desktop)
 	clientType string
 
 	// Buffered channel of outbound messages
 	send chan []byte
 
+	// Guards send against writes after close
+	sendMu sync.Mutex
+
+	// Whether send has been closed
+	closed bool
+
 	// Reference to the hub
 	hub *Hub
+
+	// Unix nanoseconds of the last frame received from the peer
+	lastSeen atomic.Int64
 }
 
 // Message represents a WebSocket message
 type Message struct {
 	Type      string      `json:"type"`
-	ID        string      `json:"id"`
+	ID        string      `json:"id,omitempty"`
 	Timestamp time.Time   `json:"timestamp"`
-	From      uuid.UUID   `json:"from"`
-	To        uuid.UUID   `json:"to"`
-	Data      interface{} `json:"data"`
+	From      uuid.UUID   `json:"from,omitzero"`
+	To        uuid.UUID   `json:"to,omitzero"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`   // Error code for "error" messages
+	Detail    string      `json:"message,omitempty"` // Human-readable error description
+}
+
+// IncomingMessage represents a message sent by a client
+type IncomingMessage struct {
+	Type      string          `json:"type"`
+	ID        string          `json:"id,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// MessageHandler handles an inbound message of a registered type
+type MessageHandler func(client *Client, message IncomingMessage)
+
+// Message types used by the WebSocket protocol
+const (
+	MessageTypeCommand          = "command"
+	MessageTypeSendCommand      = "send_command"
+	MessageTypeCommandCreated   = "command_created"
+	MessageTypeCommandStatus    = "command_status"
+	MessageTypeApprovalRequired = "approval_required"
+	MessageTypeModerationNotice = "moderation_notice"
+	MessageTypeHeartbeat        = "heartbeat"
+	MessageTypeError            = "error"
+)
+
+// Error codes sent in "error" messages
+const (
+	ErrorCodeAuthenticationFailed = "authentication_failed"
+	ErrorCodeInvalidRequest       = "invalid_request"
+	ErrorCodeUnknownMessageType   = "unknown_message_type"
+	ErrorCodeCommandFailed        = "command_failed"
+	ErrorCodeCommandNotFound      = "command_not_found"
+	ErrorCodeUserNotFound         = "user_not_found"
+	ErrorCodeInvalidTransition    = "invalid_status_transition"
+	ErrorCodeCommandBlocked       = "command_blocked"
+	ErrorCodeAccountRestricted    = "account_restricted"
+	ErrorCodeInsufficientScope    = "insufficient_scope"
+	ErrorCodeEmailNotVerified     = "email_not_verified"
+)
+
+// NewErrorMessage builds an "error" message with the given code and description
+func NewErrorMessage(code, detail string) Message {
+	return Message{
+		Type:      MessageTypeError,
+		Timestamp: time.Now(),
+		Error:     code,
+		Detail:    detail,
+	}
 }
 
 // NewHub creates a new WebSocket hub
-func NewHub() *Hub {
+func NewHub(cfg config.WebSocket) *Hub {
+	interval := cfg.HeartbeatInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	maxMissed := cfg.MaxMissedHeartbeats
+	if maxMissed <= 0 {
+		maxMissed = 3
+	}
+
 	return &Hub{
-		clients:         make(map[*Client]bool),
-		broadcast:       make(chan []byte),
-		register:        make(chan *Client),
-		unregister:      make(chan *Client),
-		userConnections: make(map[uuid.UUID][]*Client),
+		clients:             make(map[*Client]bool),
+		broadcast:           make(chan []byte),
+		register:            make(chan *Client),
+		unregister:          make(chan *Client),
+		userConnections:     make(map[uuid.UUID][]*Client),
+		handlers:            make(map[string]MessageHandler),
+		heartbeatInterval:   interval,
+		maxMissedHeartbeats: maxMissed,
 	}
 }
 
-// Run starts the WebSocket hub
+// staleAfter returns how long a client may stay silent before it is dropped
+func (h *Hub) staleAfter() time.Duration {
+	return h.heartbeatInterval * time.Duration(h.maxMissedHeartbeats)
+}
+
+// HandleFunc registers the handler for an inbound message type.
+// Handlers must be registered before the hub starts accepting clients.
+func (h *Hub) HandleFunc(messageType string, handler MessageHandler) {
+	h.handlers[messageType] = handler
+}
+
+// OnUserConnected sets the callback run when a user's first client registers.
+// It runs in its own goroutine so it may block on I/O.
+func (h *Hub) OnUserConnected(fn func(userID uuid.UUID)) {
+	h.onUserConnected = fn
+}
+
+// dispatch routes an inbound message to its registered handler
+func (h *Hub) dispatch(client *Client, message IncomingMessage) {
+	// Client heartbeats only refresh lastSeen, which the read pump already did
+	if message.Type == MessageTypeHeartbeat {
+		return
+	}
+
+	handler, ok := h.handlers[message.Type]
+	if !ok {
+		client.Send(NewErrorMessage(ErrorCodeUnknownMessageType, "Unknown message type: "+message.Type))
+		return
+	}
+	handler(client, message)
+}
+
+// Run starts the WebSocket hub. It is the only goroutine that adds or
+// removes clients, and removeClient is the only place a client is closed.
 func (h *Hub) Run() {
+	ticker := time.NewTicker(h.heartbeatInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
-
-			// Add to user connections
-			if _, exists := h.userConnections[client.userID]; !exists {
-				h.userConnections[client.userID] = []*Client{}
-			}
-			h.userConnections[client.userID] = append(h.userConnections[client.userID], client)
-
-			logrus.WithFields(logrus.Fields{
-				"user_id":       client.userID,
-				"client_type":   client.clientType,
-				"total_clients": len(h.clients),
-			}).Info("Client connected")
+			h.addClient(client)
 
 		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-
-				// Remove from user connections
-				if connections, exists := h.userConnections[client.userID]; exists {
-					for i, conn := range connections {
-						if conn == client {
-							h.userConnections[client.userID] = append(connections[:i], connections[i+1:]...)
-							break
-						}
-					}
-					// Remove user from map if no more connections
-					if len(h.userConnections[client.userID]) == 0 {
-						delete(h.userConnections, client.userID)
-					}
-				}
+			h.removeClient(client, "Client disconnected")
 
-				logrus.WithFields(logrus.Fields{
-					"user_id":       client.userID,
-					"client_type":   client.clientType,
-					"total_clients": len(h.clients),
-				}).Info("Client disconnected")
-			}
+		case <-ticker.C:
+			h.heartbeat()
 
 		case message := <-h.broadcast:
+			h.mu.RLock()
+			var slow []*Client
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if !client.trySend(message) {
+					slow = append(slow, client)
 				}
 			}
+			h.mu.RUnlock()
+
+			for _, client := range slow {
+				h.removeClient(client, "Client send buffer full")
+			}
+		}
+	}
+}
+
+// addClient adds a client to the hub
+func (h *Hub) addClient(client *Client) {
+	h.mu.Lock()
+	h.clients[client] = true
+	h.userConnections[client.userID] = append(h.userConnections[client.userID], client)
+	first := len(h.userConnections[client.userID]) == 1
+	total := len(h.clients)
+	h.mu.Unlock()
+
+	if first && h.onUserConnected != nil {
+		go h.onUserConnected(client.userID)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":       client.userID,
+		"client_type":   client.clientType,
+		"total_clients": total,
+	}).Info("Client connected")
+}
+
+// removeClient drops a client from the hub and closes it
+func (h *Hub) removeClient(client *Client, reason string) {
+	h.mu.Lock()
+	if _, ok := h.clients[client]; !ok {
+		h.mu.Unlock()
+		return
+	}
+
+	delete(h.clients, client)
+
+	// Remove from user connections
+	if connections, exists := h.userConnections[client.userID]; exists {
+		for i, conn := range connections {
+			if conn == client {
+				h.userConnections[client.userID] = append(connections[:i], connections[i+1:]...)
+				break
+			}
+		}
+		// Remove user from map if no more connections
+		if len(h.userConnections[client.userID]) == 0 {
+			delete(h.userConnections, client.userID)
 		}
 	}
+	total := len(h.clients)
+	h.mu.Unlock()
+
+	// Closing send makes the write pump close the connection
+	client.close()
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":       client.userID,
+		"client_type":   client.clientType,
+		"total_clients": total,
+	}).Info(reason)
+}
+
+// requestUnregister asks the hub loop to remove a client without blocking the caller
+func (h *Hub) requestUnregister(client *Client) {
+	go func() {
+		h.unregister <- client
+	}()
+}
+
+// heartbeat reaps clients that missed too many heartbeats and sends a heartbeat to the rest
+func (h *Hub) heartbeat() {
+	now := time.Now()
+	data, err := json.Marshal(Message{Type: MessageTypeHeartbeat, Timestamp: now})
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal heartbeat")
+		return
+	}
+
+	var stale, slow []*Client
+	h.mu.RLock()
+	for client := range h.clients {
+		if now.Sub(client.LastSeen()) > h.staleAfter() {
+			stale = append(stale, client)
+		} else if !client.trySend(data) {
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range stale {
+		h.removeClient(client, "Client timed out")
+	}
+	for _, client := range slow {
+		h.removeClient(client, "Client send buffer full")
+	}
+}
+
+// sendToUser delivers data to the user's clients that match the filter.
+// Clients whose buffers are full are handed to the hub loop for removal.
+func (h *Hub) sendToUser(userID uuid.UUID, data []byte, match func(*Client) bool) {
+	var slow []*Client
+	h.mu.RLock()
+	for _, client := range h.userConnections[userID] {
+		if match(client) && !client.trySend(data) {
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range slow {
+		h.requestUnregister(client)
+	}
 }
 
 // SendToUser sends a message to a specific user
@@ -132,16 +347,7 @@ func (h *Hub) SendToUser(userID uuid.UUID, message Message) {
 		return
 	}
 
-	if connections, exists := h.userConnections[userID]; exists {
-		for _, client := range connections {
-			select {
-			case client.send <- data:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-			}
-		}
-	}
+	h.sendToUser(userID, data, func(*Client) bool { return true })
 }
 
 // SendToUserByType sends a message to a specific user's client type
@@ -152,18 +358,9 @@ func (h *Hub) SendToUserByType(userID uuid.UUID, clientType string, message Mess
 		return
 	}
 
-	if connections, exists := h.userConnections[userID]; exists {
-		for _, client := range connections {
-			if client.clientType == clientType {
-				select {
-				case client.send <- data:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-		}
-	}
+	h.sendToUser(userID, data, func(client *Client) bool {
+		return client.clientType == clientType
+	})
 }
 
 // Broadcast sends a message to all connected clients
@@ -177,8 +374,52 @@ func (h *Hub) Broadcast(message Message) {
 	h.broadcast <- data
 }
 
+// DisconnectUser closes every connection of a user, such as after they are suspended
+func (h *Hub) DisconnectUser(userID uuid.UUID) {
+	h.mu.RLock()
+	clients := append([]*Client(nil), h.userConnections[userID]...)
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.requestUnregister(client)
+	}
+}
+
+// DisconnectSession closes every connection authenticated with a session, such as after it is revoked
+func (h *Hub) DisconnectSession(sessionID uuid.UUID) {
+	h.disconnectWhere(func(client *Client) bool {
+		return client.sessionID == sessionID
+	})
+}
+
+// DisconnectDevice closes every connection authenticated with a device credential, such as after it is revoked
+func (h *Hub) DisconnectDevice(deviceID uuid.UUID) {
+	h.disconnectWhere(func(client *Client) bool {
+		return client.deviceID == deviceID
+	})
+}
+
+// disconnectWhere hands the clients that match the filter to the hub loop for removal
+func (h *Hub) disconnectWhere(match func(*Client) bool) {
+	var clients []*Client
+	h.mu.RLock()
+	for client := range h.clients {
+		if match(client) {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.requestUnregister(client)
+	}
+}
+
 // GetConnectedUsers returns a list of connected user IDs
 func (h *Hub) GetConnectedUsers() []uuid.UUID {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
 	users := make([]uuid.UUID, 0, len(h.userConnections))
 	for userID := range h.userConnections {
 		users = append(users, userID)
@@ -188,14 +429,17 @@ func (h *Hub) GetConnectedUsers() []uuid.UUID {
 
 // IsUserConnected checks if a user is connected
 func (h *Hub) IsUserConnected(userID uuid.UUID) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
 	_, exists := h.userConnections[userID]
 	return exists
 }
 
 // GetUserConnections returns the number of connections for a user
 func (h *Hub) GetUserConnections(userID uuid.UUID) int {
-	if connections, exists := h.userConnections[userID]; exists {
-		return len(connections)
-	}
-	return 0
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.userConnections[userID])
 }
@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thecontrolapp/controlme-go/internal/config"
+)
+
+// newTestClient creates a client without a connection, whose messages are
+// drained until the hub closes it. done is closed once that has happened.
+func newTestClient(hub *Hub, userID uuid.UUID) (client *Client, done chan struct{}) {
+	client = NewClient(hub, nil, userID, uuid.New(), ClientTypeWeb)
+	done = make(chan struct{})
+	go func() {
+		for range client.send {
+		}
+		close(done)
+	}()
+	return client, done
+}
+
+// waitClosed fails the test unless the client's send channel is closed in time
+func waitClosed(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client was not closed")
+	}
+}
+
+// waitNoUsers fails the test unless every client leaves the hub in time
+func waitNoUsers(t *testing.T, hub *Hub) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hub.GetConnectedUsers()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d users still connected", len(hub.GetConnectedUsers()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubConcurrentRegisterSendUnregister(t *testing.T) {
+	hub := NewHub(config.WebSocket{HeartbeatInterval: 10 * time.Millisecond, MaxMissedHeartbeats: 1000})
+	go hub.Run()
+
+	const users, clientsPerUser = 20, 5
+	userIDs := make([]uuid.UUID, users)
+	for i := range userIDs {
+		userIDs[i] = uuid.New()
+	}
+
+	var wg sync.WaitGroup
+	var doneMu sync.Mutex
+	var dones []chan struct{}
+	for _, userID := range userIDs {
+		for range clientsPerUser {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client, done := newTestClient(hub, userID)
+				doneMu.Lock()
+				dones = append(dones, done)
+				doneMu.Unlock()
+
+				hub.register <- client
+				for i := range 50 {
+					hub.SendToUser(userID, Message{Type: MessageTypeCommand, ID: uuid.NewString()})
+					hub.SendToUser(userIDs[i%users], Message{Type: MessageTypeCommand})
+					hub.GetConnectedUsers()
+					hub.IsUserConnected(userID)
+					hub.GetUserConnections(userID)
+				}
+
+				// The read pump and a full buffer can both unregister a client
+				hub.unregister <- client
+				hub.unregister <- client
+				client.Send(Message{Type: MessageTypeCommand})
+			}()
+		}
+	}
+
+	// Disconnects racing with the clients' own unregistration
+	for _, userID := range userIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hub.DisconnectUser(userID)
+		}()
+	}
+
+	wg.Wait()
+	for _, done := range dones {
+		waitClosed(t, done)
+	}
+	waitNoUsers(t, hub)
+}
+
+func TestHubRemoveClientTwice(t *testing.T) {
+	hub := NewHub(config.WebSocket{})
+	userID := uuid.New()
+	client, done := newTestClient(hub, userID)
+
+	hub.addClient(client)
+	if !hub.IsUserConnected(userID) {
+		t.Fatal("user not connected after addClient")
+	}
+
+	// Removals racing each other and sends to the client being closed
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			hub.removeClient(client, "test")
+		}()
+		go func() {
+			defer wg.Done()
+			client.trySend([]byte("{}"))
+		}()
+	}
+	wg.Wait()
+	waitClosed(t, done)
+
+	if hub.IsUserConnected(userID) {
+		t.Error("user still connected after removeClient")
+	}
+	if n := hub.GetUserConnections(userID); n != 0 {
+		t.Errorf("GetUserConnections = %d, want 0", n)
+	}
+
+	// A closed client refuses messages instead of panicking
+	client.close()
+	if client.trySend([]byte("{}")) {
+		t.Error("trySend succeeded on a closed client")
+	}
+	hub.SendToUser(userID, Message{Type: MessageTypeCommand})
+}
+
+func TestHubDropsClientWithFullBuffer(t *testing.T) {
+	hub := NewHub(config.WebSocket{HeartbeatInterval: time.Hour})
+	go hub.Run()
+
+	userID := uuid.New()
+	client := NewClient(hub, nil, userID, uuid.New(), ClientTypeWeb)
+	hub.register <- client
+
+	// Nothing drains the buffer, so the message after it fills up drops the client
+	for range sendBufferSize + 1 {
+		hub.SendToUser(userID, Message{Type: MessageTypeCommand})
+	}
+	waitNoUsers(t, hub)
+
+	for range client.send {
+	}
+	if client.trySend([]byte("{}")) {
+		t.Error("trySend succeeded on a dropped client")
+	}
+}